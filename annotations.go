@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxAnnotationLength caps the text of a single annotation, and of a note
+const maxAnnotationLength = 4000
+
+// Annotation is a timestamped comment on an execution
+type Annotation struct {
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AnnotationRequest represents a request to annotate an execution
+type AnnotationRequest struct {
+	Text string `json:"text"`
+}
+
+// UpdateExecutionRequest represents a request to edit an execution. Only
+// the fields given are changed.
+type UpdateExecutionRequest struct {
+	Note *string `json:"note"` // An empty note removes it
+}
+
+// SetNote replaces the note of an execution, recording who changed it and
+// when, and returns a snapshot of the execution
+func (e *Executor) SetNote(id, note, author string) (*Execution, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	execution, ok := e.executions[id]
+	if !ok {
+		return nil, ErrExecutionNotFound
+	}
+	now := time.Now()
+	execution.Note = note
+	execution.NoteUpdatedBy = author
+	execution.NoteUpdatedAt = &now
+	e.touchLocked(execution)
+	e.saveLocked()
+	snapshot := *execution
+	return &snapshot, nil
+}
+
+// AddAnnotation appends an annotation to an execution's timeline and returns
+// the whole timeline
+func (e *Executor) AddAnnotation(id string, annotation Annotation) ([]Annotation, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	execution, ok := e.executions[id]
+	if !ok {
+		return nil, ErrExecutionNotFound
+	}
+	execution.Annotations = append(execution.Annotations, annotation)
+	e.touchLocked(execution)
+	e.saveLocked()
+	return append([]Annotation(nil), execution.Annotations...), nil
+}
+
+// ListAnnotationsHandler handles GET /api/executions/:id/annotations
+func (app *App) ListAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	execution, ok := app.executor.GetExecution(mux.Vars(r)["id"])
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+
+	annotations := execution.Annotations
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+	respondJSON(w, http.StatusOK, annotations)
+}
+
+// AddAnnotationHandler handles POST /api/executions/:id/annotations
+func (app *App) AddAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	var req AnnotationRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Text is required"})
+		return
+	}
+	if len(text) > maxAnnotationLength {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Text is too long"})
+		return
+	}
+
+	annotations, err := app.executor.AddAnnotation(mux.Vars(r)["id"], Annotation{
+		Author:    currentUsername(r),
+		Text:      text,
+		CreatedAt: time.Now(),
+	})
+	if errors.Is(err, ErrExecutionNotFound) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, annotations)
+}
+
+// UpdateExecutionHandler handles PATCH /api/executions/:id, which sets the
+// execution's note
+func (app *App) UpdateExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	var req UpdateExecutionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Note == nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Nothing to update, only note can be changed"})
+		return
+	}
+	note := strings.TrimSpace(*req.Note)
+	if len(note) > maxAnnotationLength {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Note is too long"})
+		return
+	}
+
+	execution, err := app.executor.SetNote(mux.Vars(r)["id"], note, currentUsername(r))
+	if errors.Is(err, ErrExecutionNotFound) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, execution)
+}
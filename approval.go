@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// pendingApprovalStatus is the status of an execution waiting for approval
+const pendingApprovalStatus = "pending_approval"
+
+var (
+	// ErrNotPendingApproval is returned when deciding on an execution that is
+	// not waiting for approval
+	ErrNotPendingApproval = errors.New("execution is not waiting for approval")
+	// ErrSelfApproval is returned when the requester decides on their own
+	// execution
+	ErrSelfApproval = errors.New("execution must be approved by someone other than the requester")
+)
+
+// Approval records the decision on an execution of a command that requires
+// approval
+type Approval struct {
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	Decision    string     `json:"decision,omitempty"` // approved or rejected, empty while pending
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"` // Given when rejecting
+}
+
+// RejectRequest is the optional body of a rejection
+type RejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RequestApproval records an execution of params that waits for approval
+// before it runs
+func (e *Executor) RequestApproval(params ExecuteParams) (*Execution, error) {
+	execution := newExecution(params)
+	execution.Status = pendingApprovalStatus
+	execution.Approval = &Approval{RequestedBy: params.Username, RequestedAt: execution.StartedAt}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closing {
+		return nil, ErrShuttingDown
+	}
+	execution.Seq = e.nextSeqLocked()
+	e.executions[execution.ID] = execution
+	e.indexLastRunLocked(execution)
+	e.evictOverCapLocked()
+	e.touchLocked(execution)
+	e.saveLocked()
+	slog.Info("Execution waiting for approval", "execution_id", execution.ID, "command_id", execution.CommandID, "user", execution.ExecutedBy)
+
+	snapshot := *execution
+	return &snapshot, nil
+}
+
+// pendingApprovalLocked returns the execution id if it waits for approval
+// and approver may decide on it. Caller must hold e.mu.
+func (e *Executor) pendingApprovalLocked(id, approver string) (*Execution, error) {
+	execution, ok := e.executions[id]
+	if !ok {
+		return nil, ErrExecutionNotFound
+	}
+	if execution.Status != pendingApprovalStatus || execution.Approval == nil || execution.Approval.Decision != "" {
+		return nil, ErrNotPendingApproval
+	}
+	if execution.Approval.RequestedBy == approver {
+		return nil, ErrSelfApproval
+	}
+	return execution, nil
+}
+
+// Approve starts an execution waiting for approval with params, rebuilt for
+// it from the record
+func (e *Executor) Approve(id, approver string, params ExecuteParams) (*Execution, error) {
+	e.mu.Lock()
+	execution, err := e.pendingApprovalLocked(id, approver)
+	if err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	// Claim the decision so a concurrent one fails while the logs are created
+	pending := execution.Approval
+	execution.Approval = decideApproval(pending, "approved", approver, "")
+	e.mu.Unlock()
+
+	proc, err := e.newProcess(execution, params, 1)
+
+	e.mu.Lock()
+	if err == nil && e.closing {
+		proc.closeLogs()
+		removeLog(proc.logPath)
+		err = ErrShuttingDown
+	}
+	if err == nil && params.SingleInstance && e.commandActiveLocked(params.CommandID) {
+		proc.closeLogs()
+		removeLog(proc.logPath)
+		err = ErrCommandRunning
+	}
+	if err != nil {
+		execution.Approval = pending
+		e.mu.Unlock()
+		return nil, err
+	}
+	if _, ok := e.executions[id]; !ok || execution.Status != pendingApprovalStatus {
+		// Deleted or cancelled while the logs were created
+		e.mu.Unlock()
+		proc.closeLogs()
+		removeLog(proc.logPath)
+		return nil, ErrNotPendingApproval
+	}
+
+	execution.Env = redactEnv(params.Env)
+	execution.Steps = newStepResults(params.Steps)
+	execution.setTimeout(params)
+	if params.MaxRetries > 0 {
+		execution.Attempt = 1
+		execution.MaxAttempts = params.MaxRetries + 1
+	}
+	execution.LogFile = proc.logPath
+	execution.StartedAt = time.Now()
+	slog.Info("Execution approved", "execution_id", execution.ID, "approver", approver)
+	return e.launch(execution, proc), nil
+}
+
+// decideApproval returns a copy of approval with the decision made now.
+// Snapshots share the original, so it is not modified.
+func decideApproval(approval *Approval, decision, approver, reason string) *Approval {
+	now := time.Now()
+	decided := *approval
+	decided.Decision = decision
+	decided.DecidedBy = approver
+	decided.DecidedAt = &now
+	decided.Reason = reason
+	return &decided
+}
+
+// Reject declines an execution waiting for approval, so it never runs
+func (e *Executor) Reject(id, approver, reason string) (*Execution, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	execution, err := e.pendingApprovalLocked(id, approver)
+	if err != nil {
+		return nil, err
+	}
+	execution.Approval = decideApproval(execution.Approval, "rejected", approver, reason)
+	execution.Status = "declined"
+	execution.EndedAt = *execution.Approval.DecidedAt
+	e.touchLocked(execution)
+	e.saveLocked()
+	slog.Info("Execution rejected", "execution_id", execution.ID, "approver", approver)
+
+	snapshot := *execution
+	return &snapshot, nil
+}
+
+// executeSaved runs params of the saved command cmd, or records them to wait
+// for approval if cmd requires it
+func (app *App) executeSaved(params ExecuteParams, cmd *Command) (*Execution, error) {
+	if cmd.RequireApproval {
+		return app.executor.RequestApproval(params)
+	}
+	return app.executor.Execute(params)
+}
+
+// ApproveExecutionHandler handles POST /api/executions/:id/approve. An admin
+// other than the requester starts an execution waiting for approval.
+func (app *App) ApproveExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	approver := currentUsername(r)
+	if !app.isAdmin(approver) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	pending, ok := app.executor.GetExecution(mux.Vars(r)["id"])
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+	if pending.Status != pendingApprovalStatus {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is not waiting for approval"})
+		return
+	}
+
+	// Run as the requester, with the command's current environment
+	params, _, err := app.rerunParams(pending, pending.ExecutedBy)
+	if err != nil {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	}
+	params.RerunOf = pending.RerunOf
+	if pending.TimeoutOverridden {
+		params.HardTimeout = time.Duration(pending.TimeoutSeconds) * time.Second
+		params.TimeoutOverridden = true
+	}
+	if err := app.validateRerunParams(params); err != nil {
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	execution, err := app.executor.Approve(pending.ID, approver, params)
+	if err != nil {
+		respondApprovalError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, app.executeResponse(execution))
+}
+
+// RejectExecutionHandler handles POST /api/executions/:id/reject. An admin
+// other than the requester declines an execution waiting for approval.
+func (app *App) RejectExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	approver := currentUsername(r)
+	if !app.isAdmin(approver) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	var req RejectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondInvalidBody(w, err)
+		return
+	}
+
+	execution, err := app.executor.Reject(mux.Vars(r)["id"], approver, req.Reason)
+	if err != nil {
+		respondApprovalError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, execution)
+}
+
+// respondApprovalError writes the response for a failed approval decision
+func respondApprovalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrExecutionNotFound):
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+	case errors.Is(err, ErrNotPendingApproval):
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is not waiting for approval"})
+	case errors.Is(err, ErrCommandRunning):
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Command is already running"})
+	case errors.Is(err, ErrSelfApproval):
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Execution must be approved by someone other than the requester"})
+	default:
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Audit entry outcomes
+const (
+	auditAttempt = "attempt"
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+const (
+	// defaultAuditLimit is the number of audit entries returned when ?limit= is not given
+	defaultAuditLimit = 100
+	// maxAuditLimit caps the number of audit entries returned
+	maxAuditLimit = 1000
+)
+
+// AuditEntry records a mutating API request. Every request gets an
+// "attempt" entry before it is handled and a "success" or "failure" entry
+// with the same ID once it completes.
+type AuditEntry struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`               // Authenticated username, empty for login and setup
+	Action   string    `json:"action"`              // Method and route, e.g. "DELETE /api/commands/{id}"
+	TargetID string    `json:"target_id,omitempty"` // ID, username or name from the route
+	SourceIP string    `json:"source_ip"`
+	Outcome  string    `json:"outcome"`          // attempt, success or failure
+	Status   int       `json:"status,omitempty"` // HTTP status of a success or failure
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Hijack implements http.Hijacker, for WebSocket upgrades
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
+
+// auditMiddleware writes POST, PUT, PATCH and DELETE requests to the audit log. The
+// attempt is stored before the handler runs, so it is recorded even if the
+// operation fails or never completes.
+func (app *App) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		action := r.Method + " " + r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				action = r.Method + " " + template
+			}
+		}
+		vars := mux.Vars(r)
+		entry := AuditEntry{
+			ID:       uuid.New().String(),
+			Time:     time.Now(),
+			Actor:    currentUsername(r),
+			Action:   action,
+			TargetID: firstNonEmpty(vars["id"], vars["username"], vars["name"]),
+			SourceIP: app.loginLimiter.clientIP(r),
+			Outcome:  auditAttempt,
+		}
+		app.appendAudit(entry)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		entry.Time = time.Now()
+		entry.Status = rec.status
+		entry.Outcome = auditSuccess
+		if rec.status >= http.StatusBadRequest {
+			entry.Outcome = auditFailure
+		}
+		app.appendAudit(entry)
+	})
+}
+
+// appendAudit stores an audit entry, logging failures
+func (app *App) appendAudit(entry AuditEntry) {
+	if err := app.storage.AppendAudit(&entry); err != nil {
+		slog.Error("Failed to write audit entry", "outcome", entry.Outcome, "action", entry.Action, "error", err)
+	}
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// isAdmin reports whether username may use admin endpoints, being listed in
+// DEPLOYAR_ADMIN_USERS or created as an admin. Caller must not hold usersMu.
+func (app *App) isAdmin(username string) bool {
+	if containsString(app.config.AdminUsers, username) {
+		return true
+	}
+	app.usersMu.RLock()
+	defer app.usersMu.RUnlock()
+	user, exists := app.users[username]
+	return exists && user.Admin
+}
+
+// promoteFirstAdmin makes the oldest user an admin when there is no admin at
+// all, so admin endpoints stay usable without DEPLOYAR_ADMIN_USERS. It returns the
+// promoted user, or nil if nothing changed.
+func promoteFirstAdmin(users map[string]*User, adminUsers []string) *User {
+	var first *User
+	for _, user := range users {
+		if user.Admin || containsString(adminUsers, user.Username) {
+			return nil
+		}
+		if first == nil || user.CreatedAt.Before(first.CreatedAt) ||
+			(user.CreatedAt.Equal(first.CreatedAt) && user.Username < first.Username) {
+			first = user
+		}
+	}
+	if first != nil {
+		first.Admin = true
+	}
+	return first
+}
+
+// AuditListResponse represents a page of audit entries
+type AuditListResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+	Total   int           `json:"total"`
+}
+
+// ListAuditHandler handles GET /api/audit
+//
+// Supports ?actor=, ?outcome=, ?from= and ?to= (RFC 3339) and ?limit=.
+// Entries are returned newest first.
+func (app *App) ListAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	query := r.URL.Query()
+
+	limit, err := parseIntParam(query.Get("limit"), defaultAuditLimit)
+	if err != nil || limit < 1 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit must be a positive integer"})
+		return
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	var from, to time.Time
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "from must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "to must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	entries, err := app.storage.LoadAudit()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read audit log"})
+		return
+	}
+
+	actor := query.Get("actor")
+	outcome := query.Get("outcome")
+	matched := make([]*AuditEntry, 0)
+	for _, entry := range entries {
+		if actor != "" && entry.Actor != actor {
+			continue
+		}
+		if outcome != "" && entry.Outcome != outcome {
+			continue
+		}
+		if !from.IsZero() && entry.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && entry.Time.After(to) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	// Stored oldest first
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Time.After(matched[j].Time)
+	})
+
+	total := len(matched)
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	respondJSON(w, http.StatusOK, AuditListResponse{Entries: matched, Total: total})
+}
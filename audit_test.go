@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromoteFirstAdmin(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		users      map[string]*User
+		adminUsers []string
+		want       string // Promoted user, "" for none
+	}{
+		{name: "no users"},
+		{
+			name: "oldest user",
+			users: map[string]*User{
+				"bob":   {Username: "bob", CreatedAt: now},
+				"alice": {Username: "alice", CreatedAt: now.Add(-time.Hour)},
+			},
+			want: "alice",
+		},
+		{
+			name: "username breaks ties",
+			users: map[string]*User{
+				"bob":   {Username: "bob", CreatedAt: now},
+				"alice": {Username: "alice", CreatedAt: now},
+			},
+			want: "alice",
+		},
+		{
+			name: "admin exists",
+			users: map[string]*User{
+				"alice": {Username: "alice", CreatedAt: now.Add(-time.Hour)},
+				"bob":   {Username: "bob", CreatedAt: now, Admin: true},
+			},
+		},
+		{
+			name: "admin configured",
+			users: map[string]*User{
+				"alice": {Username: "alice", CreatedAt: now.Add(-time.Hour)},
+				"bob":   {Username: "bob", CreatedAt: now},
+			},
+			adminUsers: []string{"bob"},
+		},
+		{
+			name: "configured admin does not exist",
+			users: map[string]*User{
+				"alice": {Username: "alice", CreatedAt: now},
+			},
+			adminUsers: []string{"carol"},
+			want:       "alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if promoted := promoteFirstAdmin(tt.users, tt.adminUsers); promoted != nil {
+				got = promoted.Username
+			}
+			if got != tt.want {
+				t.Fatalf("promoteFirstAdmin() = %q, want %q", got, tt.want)
+			}
+			for _, user := range tt.users {
+				if user.Username == tt.want && !user.Admin {
+					t.Errorf("%s was not made an admin", user.Username)
+				}
+			}
+		})
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminUsers []string
+		username   string
+		want       bool
+	}{
+		{name: "listed", adminUsers: []string{"alice"}, username: "alice", want: true},
+		{name: "not listed", adminUsers: []string{"alice"}, username: "bob"},
+		{name: "created as admin", username: "carol", want: true},
+		{name: "nobody configured", username: "bob"},
+		{name: "unknown user", username: "mallory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{
+				config: &Config{AdminUsers: tt.adminUsers},
+				users: map[string]*User{
+					"alice": {Username: "alice"},
+					"bob":   {Username: "bob"},
+					"carol": {Username: "carol", Admin: true},
+				},
+			}
+			if got := app.isAdmin(tt.username); got != tt.want {
+				t.Errorf("isAdmin(%q) = %v, want %v", tt.username, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// auditSegmentTime names rotated audit log segments, sorting oldest first
+const auditSegmentTime = "20060102T150405.000000000"
+
+// AuditRotation configures rotation of the JSON store's audit log
+type AuditRotation struct {
+	MaxBytes    int64 // Rotate once the log reaches this size; zero disables
+	Daily       bool  // Rotate the first time the log is written on a new day
+	Compress    bool  // gzip rotated segments
+	MaxSegments int   // Rotated segments to keep, oldest are removed; zero keeps all
+}
+
+// rotateAuditLocked moves the audit log to a new segment if it is due for
+// rotation. Caller must hold s.auditMutex.
+func (s *JSONStore) rotateAuditLocked(now time.Time) error {
+	rotation := s.auditRotation
+	if rotation.MaxBytes <= 0 && !rotation.Daily {
+		return nil
+	}
+
+	current := s.path(auditFile)
+	info, err := os.Stat(current)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	bySize := rotation.MaxBytes > 0 && info.Size() >= rotation.MaxBytes
+	byDay := rotation.Daily && info.ModTime().Format("2006-01-02") != now.Format("2006-01-02")
+	if !bySize && !byDay {
+		return nil
+	}
+
+	segment := s.path("audit-" + now.Format(auditSegmentTime) + ".log")
+	if err := os.Rename(current, segment); err != nil {
+		return err
+	}
+	if rotation.Compress {
+		// An uncompressed segment is still readable, so only log failures
+		if err := gzipFile(segment); err != nil {
+			slog.Error("Failed to compress audit log segment", "segment", segment, "error", err)
+		}
+	}
+	s.pruneAuditSegmentsLocked()
+	return nil
+}
+
+// pruneAuditSegmentsLocked removes the oldest rotated segments beyond
+// MaxSegments. Caller must hold s.auditMutex.
+func (s *JSONStore) pruneAuditSegmentsLocked() {
+	if s.auditRotation.MaxSegments <= 0 {
+		return
+	}
+	segments, err := s.auditSegments()
+	if err != nil {
+		slog.Error("Failed to list audit log segments", "error", err)
+		return
+	}
+	for len(segments) > s.auditRotation.MaxSegments {
+		if err := os.Remove(segments[0]); err != nil {
+			slog.Error("Failed to remove audit log segment", "segment", segments[0], "error", err)
+		}
+		segments = segments[1:]
+	}
+}
+
+// auditSegments returns the rotated audit log segments, oldest first
+func (s *JSONStore) auditSegments() ([]string, error) {
+	var segments []string
+	for _, pattern := range []string{"audit-*.log", "audit-*.log.gz"} {
+		matches, err := filepath.Glob(s.path(pattern))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, matches...)
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// gzipFile compresses path to path.gz and removes the original
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".gz.tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	zw := gzip.NewWriter(tmp)
+	if _, err := io.Copy(zw, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// readAuditFile appends the entries of an audit log file, gzipped or not, to
+// entries. A missing file has no entries.
+func readAuditFile(path string, entries []*AuditEntry) ([]*AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode %s line %d: %w", filepath.Base(path), line, err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, scanner.Err()
+}
@@ -1,40 +1,159 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// AuthMiddleware validates basic auth credentials
+// contextKey is the type of request context keys set by this package
+type contextKey string
+
+// usernameKey holds the authenticated username in the request context
+const usernameKey contextKey = "username"
+
+// reauthHeader marks 401 responses that ask for the password to be re-entered,
+// as opposed to an invalid session
+const reauthHeader = "X-Deployar-Reauth"
+
+// AuthMiddleware validates a session or API token (Bearer) or basic auth
+// credentials
 func (app *App) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if setup is needed
-		if len(app.users) == 0 {
+		if !app.hasUsers() {
 			respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Setup required"})
 			return
 		}
 
-		username, password, ok := parseBasicAuth(r.Header.Get("Authorization"))
+		authHeader := r.Header.Get("Authorization")
+		if token := websocketToken(r); authHeader == "" && token != "" {
+			authHeader = "Bearer " + token
+		}
+
+		if token, ok := parseBearerToken(authHeader); ok && strings.HasPrefix(token, apiTokenPrefix) {
+			username, valid := app.tokens.Validate(token)
+			if !valid || !app.userExists(username) {
+				respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired API token"})
+				return
+			}
+			if !app.verifySignature(w, r, token) {
+				return
+			}
+			next.ServeHTTP(w, withUsername(r, username))
+			return
+		}
+
+		if token, ok := parseBearerToken(authHeader); ok {
+			session, valid := app.sessions.Validate(token)
+			if !valid || !app.userExists(session.Username) {
+				respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired session"})
+				return
+			}
+			if !app.verifySignature(w, r, token) {
+				return
+			}
+			next.ServeHTTP(w, withUsername(r, session.Username))
+			return
+		}
+
+		username, password, ok := parseBasicAuth(authHeader)
 		if !ok {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Deployar"`)
 			respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Authentication required"})
 			return
 		}
 
+		keys := app.loginLimiter.Keys(r, username)
+		if retryAfter, locked := app.loginLimiter.Locked(keys...); locked {
+			respondLockedOut(w, retryAfter)
+			return
+		}
+
+		app.usersMu.RLock()
 		user, exists := app.users[username]
-		if !exists || user.Password != password {
+		var hash string
+		var totpEnabled bool
+		if exists {
+			hash, totpEnabled = user.Password, user.TOTPEnabled
+		}
+		app.usersMu.RUnlock()
+		if !exists || !checkPassword(hash, password) {
+			app.loginLimiter.Fail(keys...)
 			w.Header().Set("WWW-Authenticate", `Basic realm="Deployar"`)
 			respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 			return
 		}
+		// Basic auth has no room for a second factor, so 2FA users must log in
+		if totpEnabled {
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Two-factor authentication enabled; log in with a session token"})
+			return
+		}
+		app.loginLimiter.Reset(app.loginLimiter.UserKey(r, username))
+		if !app.verifySignature(w, r, password) {
+			return
+		}
 
 		// Authentication successful
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withUsername(r, username))
 	})
 }
 
+// hasUsers reports whether setup has created the first user
+func (app *App) hasUsers() bool {
+	app.usersMu.RLock()
+	defer app.usersMu.RUnlock()
+	return len(app.users) > 0
+}
+
+// userExists reports whether username is a known user
+func (app *App) userExists(username string) bool {
+	app.usersMu.RLock()
+	defer app.usersMu.RUnlock()
+	_, exists := app.users[username]
+	return exists
+}
+
+// verifySignature checks the request signature when signing is enabled,
+// responding with 401 if it is missing or invalid
+func (app *App) verifySignature(w http.ResponseWriter, r *http.Request, key string) bool {
+	if app.verifier == nil {
+		return true
+	}
+	if err := app.verifier.Verify(r, key); err != nil {
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+// withUsername returns r with the authenticated username attached
+func withUsername(r *http.Request, username string) *http.Request {
+	setRequestUser(r, username)
+	return r.WithContext(context.WithValue(r.Context(), usernameKey, username))
+}
+
+// currentUsername returns the username authenticated by AuthMiddleware
+func currentUsername(r *http.Request) string {
+	username, _ := r.Context().Value(usernameKey).(string)
+	return username
+}
+
+// parseBearerToken extracts the token from a Bearer Authorization header
+func parseBearerToken(authHeader string) (string, bool) {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(authHeader, prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(authHeader[len(prefix):])
+	return token, token != ""
+}
+
 // parseBasicAuth parses HTTP Basic Authentication header
 func parseBasicAuth(authHeader string) (username, password string, ok bool) {
 	if authHeader == "" {
@@ -63,18 +182,61 @@ func parseBasicAuth(authHeader string) (username, password string, ok bool) {
 	return username, password, true
 }
 
-// validatePassword performs basic password validation
-func validatePassword(password string) error {
-	if len(password) < 4 {
-		return errors.New("Password must be at least 4 characters")
+// PasswordPolicy is the rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength        int
+	RequireMixedCase bool // Both upper and lower case letters
+	RequireDigit     bool
+	RequireSymbol    bool // Anything other than a letter or digit
+}
+
+// validatePassword checks a new password against policy, naming every rule
+// it breaks
+func validatePassword(password string, policy PasswordPolicy) error {
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("Password cannot be longer than %d characters", maxPasswordLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+
+	var broken []string
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		broken = append(broken, fmt.Sprintf("be at least %d characters", policy.MinLength))
+	}
+	if policy.RequireMixedCase && !(upper && lower) {
+		broken = append(broken, "contain upper and lower case letters")
+	}
+	if policy.RequireDigit && !digit {
+		broken = append(broken, "contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		broken = append(broken, "contain a symbol")
+	}
+	if len(broken) > 0 {
+		return errors.New("Password must " + strings.Join(broken, ", "))
 	}
 	return nil
 }
 
-// validateUsername performs basic username validation
-func validateUsername(username string) error {
-	if len(username) < 3 {
-		return errors.New("Username must be at least 3 characters")
+// validateUsername checks a new username
+func validateUsername(username string, minLength int) error {
+	if len(username) < minLength {
+		return fmt.Errorf("Username must be at least %d characters", minLength)
+	}
+	if len(username) > maxUsernameLength {
+		return fmt.Errorf("Username cannot be longer than %d characters", maxUsernameLength)
 	}
 	if strings.Contains(username, ":") {
 		return errors.New("Username cannot contain colon")
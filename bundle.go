@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// bundleVersion is the version of the command bundle format
+const bundleVersion = 1
+
+// Import modes
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// CommandBundle is a portable export of all saved commands
+type CommandBundle struct {
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exported_at"`
+	Commands   []*Command `json:"commands"`
+}
+
+// SkippedCommand is a bundle entry that was not imported
+type SkippedCommand struct {
+	Index int    `json:"index"` // Position in the bundle's commands
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// ImportResponse summarizes a command import
+type ImportResponse struct {
+	Mode    string           `json:"mode"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Removed int              `json:"removed"`
+	Skipped []SkippedCommand `json:"skipped"`
+}
+
+// ExportCommandsHandler handles GET /api/commands/export
+func (app *App) ExportCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	commands := searchCommands(app.commands, CommandQuery{})
+	w.Header().Set("Content-Disposition", `attachment; filename="deployar-commands.json"`)
+	respondJSON(w, http.StatusOK, CommandBundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now(),
+		Commands:   commands,
+	})
+}
+
+// ImportCommandsHandler handles POST /api/commands/import
+//
+// With ?mode=merge (the default) a command with the same name as an existing
+// one updates it and the others are added. With ?mode=replace all existing
+// commands are removed first. Imported commands get new IDs; invalid ones
+// are skipped and reported.
+func (app *App) ImportCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importMerge
+	}
+	if mode != importMerge && mode != importReplace {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "mode must be 'merge' or 'replace'"})
+		return
+	}
+
+	var bundle CommandBundle
+	if !decodeJSON(w, r, &bundle) {
+		return
+	}
+	if bundle.Version != bundleVersion {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Unsupported bundle version"})
+		return
+	}
+
+	// Build the new command set on a copy so a failed save changes nothing
+	resp := ImportResponse{Mode: mode, Skipped: []SkippedCommand{}}
+	commands := make(map[string]*Command, len(app.commands)+len(bundle.Commands))
+	byName := make(map[string]*Command)
+	if mode == importMerge {
+		for id, cmd := range app.commands {
+			commands[id] = cmd
+			byName[cmd.Name] = cmd
+		}
+	} else {
+		resp.Removed = len(app.commands)
+	}
+
+	imported := make(map[string]bool)
+	now := time.Now()
+	for i, cmd := range bundle.Commands {
+		if cmd == nil {
+			resp.Skipped = append(resp.Skipped, SkippedCommand{Index: i, Error: "Empty entry"})
+			continue
+		}
+		skip := func(err string) {
+			resp.Skipped = append(resp.Skipped, SkippedCommand{Index: i, Name: cmd.Name, Error: err})
+		}
+		if imported[cmd.Name] {
+			skip("Duplicate command name in bundle")
+			continue
+		}
+		if err := app.validateCommandInput(cmd); err != nil {
+			skip(err.Error())
+			continue
+		}
+		if err := app.validatePresetRefLocked(cmd.EnvPreset); err != nil {
+			skip(err.Error())
+			continue
+		}
+
+		imported[cmd.Name] = true
+		cmd.ArchivedAt = nil
+		cmd.UpdatedAt = now
+		cmd.Revisions = nil
+		if existing, ok := byName[cmd.Name]; ok {
+			cmd.ID = existing.ID
+			cmd.CreatedAt = existing.CreatedAt
+			cmd.Revisions = existing.Revisions
+			recordRevision(existing, cmd, currentUsername(r), app.config.CommandRevisions)
+			resp.Updated++
+		} else {
+			cmd.ID = uuid.New().String()
+			cmd.CreatedAt = now
+			resp.Created++
+		}
+		commands[cmd.ID] = cmd
+	}
+
+	app.commandsChanged()
+	if err := app.storage.SaveCommands(commands); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save commands"})
+		return
+	}
+
+	previous := app.commands
+	app.commands = commands
+	for id := range previous {
+		if _, ok := commands[id]; !ok {
+			app.scheduler.Remove(id)
+		}
+	}
+	ids := make([]string, 0, len(commands))
+	for id := range commands {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		app.scheduler.Set(id, commands[id].activeSchedule())
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// ErrCommandNotAllowed is returned when a command is denied by the command
+// rules
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+// CommandRules are regular expressions commands are checked against before
+// they are saved or run. A command matching a deny pattern is rejected; with
+// allow patterns set, a command must also match one of them.
+type CommandRules struct {
+	Deny  []string `json:"deny"`
+	Allow []string `json:"allow,omitempty"`
+
+	deny  []*regexp.Regexp
+	allow []*regexp.Regexp
+}
+
+// LoadCommandRules reads command rules from a JSON file. An empty path
+// disables the rules.
+func LoadCommandRules(path string) (*CommandRules, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules CommandRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid command rules file: %w", err)
+	}
+	if rules.deny, err = compilePatterns("deny", rules.Deny); err != nil {
+		return nil, err
+	}
+	if rules.allow, err = compilePatterns("allow", rules.Allow); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// compilePatterns compiles the patterns of a rule list
+func compilePatterns(list string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("command rules: invalid %s pattern %q: %w", list, pattern, err)
+		}
+		compiled[i] = re
+	}
+	return compiled, nil
+}
+
+// Check returns an error naming the rule that rejects command, if any. Nil
+// rules allow every command.
+func (r *CommandRules) Check(command string) error {
+	if r == nil {
+		return nil
+	}
+	for i, re := range r.deny {
+		if re.MatchString(command) {
+			return fmt.Errorf("%w: matches deny rule %q", ErrCommandNotAllowed, r.Deny[i])
+		}
+	}
+	if len(r.allow) == 0 {
+		return nil
+	}
+	for _, re := range r.allow {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: does not match any allow rule", ErrCommandNotAllowed)
+}
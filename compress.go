@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters reuses gzip writers across responses
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compressMiddleware gzips responses for clients that accept it once the body
+// reaches minBytes. Smaller bodies are sent as they are, since compressing
+// them saves little. WebSocket upgrades, event streams and range requests
+// are passed through untouched.
+func compressMiddleware(enabled bool, minBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !compressible(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minBytes: minBytes}
+			defer gw.Close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// compressible reports whether the response to r may be compressed at all
+func compressible(r *http.Request) bool {
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		return false
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	return !strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding,
+// without refusing it with q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		value, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		q, err := strconv.ParseFloat(value, 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body reaches the threshold, then either compresses it or writes it
+// through unchanged
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minBytes int
+	status   int          // Status written by the handler, sent once decided
+	buf      bytes.Buffer // Body written before deciding
+	gz       *gzip.Writer // Set once compressing
+	decided  bool         // Headers have been sent
+}
+
+// WriteHeader implements http.ResponseWriter. Sending the status is delayed
+// until the encoding is decided, except for responses without a body.
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() >= w.minBytes {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher. A handler flushing a response streams it,
+// so it is compressed even before reaching the threshold.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends a response that stayed below the threshold as it is, or
+// finishes the compressed stream
+func (w *gzipResponseWriter) Close() {
+	if !w.decided {
+		if w.status == 0 && w.buf.Len() == 0 {
+			// Nothing was written; the server sends its default 200
+			return
+		}
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// decide sends the headers, compressing the body from now on if compress is
+// set and the handler did not encode it already
+func (w *gzipResponseWriter) decide(compress bool) {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if compress && header.Get("Content-Encoding") == "" && w.status != http.StatusPartialContent {
+		// Sniffing the type from the compressed bytes would get it wrong
+		if header.Get("Content-Type") == "" && w.buf.Len() > 0 {
+			header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+		}
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		// The compressed body differs from the one the ETag was computed for
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// start decides the encoding and writes out the buffered body
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.decide(compress)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
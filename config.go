@@ -0,0 +1,345 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings read from environment variables
+type Config struct {
+	Port     string
+	BasePath string
+
+	// TLSCert and TLSKey serve HTTPS with a certificate from files.
+	// Alternatively AutocertDomains obtains certificates for those domains
+	// from Let's Encrypt, cached in AutocertCacheDir. Without either the
+	// server speaks plain HTTP.
+	TLSCert          string
+	TLSKey           string
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+
+	// LogLevel is the minimum level logged: debug, info, warn or error.
+	// LogFormat is text or json.
+	LogLevel  string
+	LogFormat string
+
+	// StaticDir holds the web UI files. DataDir holds the JSON data files,
+	// execution logs and, if its path is relative, the SQLite database.
+	StaticDir string
+	DataDir   string
+
+	// Store selects the storage backend: "json" (default) or "sqlite"
+	Store      string
+	SQLitePath string
+
+	// SessionTTL is how long a session token stays valid after its last use
+	SessionTTL time.Duration
+
+	// Login lockout: LoginMaxAttempts failures within LoginWindow lock the
+	// client out for LoginLockout. Zero attempts disables the lockout.
+	LoginMaxAttempts int
+	LoginWindow      time.Duration
+	LoginLockout     time.Duration
+
+	// Rules for new usernames and passwords. Existing users can still log
+	// in when the rules get stricter.
+	UsernameMinLength int
+	PasswordPolicy    PasswordPolicy
+
+	// AllowedWorkdirs restricts command workdirs to these directories and
+	// their subdirectories. Empty means unrestricted.
+	AllowedWorkdirs []string
+	// CommandRulesFile is a JSON file of deny and allow patterns commands
+	// are checked against, compiled into CommandRules at startup unless
+	// CommandRulesDisabled is set. Nil rules allow every command.
+	CommandRulesFile     string
+	CommandRulesDisabled bool
+	CommandRules         *CommandRules
+	// AdminUsers may use admin endpoints such as the audit log. Empty means
+	// every user is an admin.
+	AdminUsers []string
+
+	// ClientIPHeader names a header set by a trusted reverse proxy (e.g.
+	// X-Forwarded-For or X-Real-IP) to take the client IP from. When empty
+	// the connection's remote address is used.
+	ClientIPHeader string
+
+	// RequestSigning requires authenticated API requests to carry an HMAC
+	// signature keyed with the caller's token or password, with a timestamp
+	// no further than RequestSigningMaxSkew from the server clock
+	RequestSigning        bool
+	RequestSigningMaxSkew time.Duration
+
+	// CORSOrigins are the origins allowed to make cross-origin requests; "*"
+	// allows any origin. Empty denies all cross-origin requests.
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	// CORSMaxAge is how long browsers may cache preflight responses. Zero
+	// omits the Access-Control-Max-Age header.
+	CORSMaxAge time.Duration
+
+	// MetricsPublic serves metrics without authentication
+	MetricsPublic bool
+	// MetricsToken, if set, must be sent as a Bearer token to scrape /metrics
+	MetricsToken string
+
+	// MaxConcurrent caps how many executions run at once; further executions
+	// are queued. Zero or less means unlimited.
+	MaxConcurrent int
+
+	// MaxOutputBytes caps the combined stdout and stderr kept per attempt;
+	// further output is discarded. Zero or less means unlimited.
+	// KillOnOutputLimit also kills a command that exceeds the cap.
+	MaxOutputBytes    int64
+	KillOnOutputLimit bool
+
+	// ExecutionRateLimit caps how many executions each user may start within
+	// ExecutionRateWindow. Zero disables it. Users listed in AdminUsers are
+	// exempt.
+	ExecutionRateLimit  int
+	ExecutionRateWindow time.Duration
+
+	// ExecuteRequestRate throttles each user's execute requests with a token
+	// bucket refilled at this many requests per second and holding up to
+	// ExecuteRequestBurst. Zero disables it.
+	ExecuteRequestRate  float64
+	ExecuteRequestBurst int
+
+	// IdempotencyTTL is how long an Idempotency-Key of an execute request
+	// keeps returning the execution it started
+	IdempotencyTTL time.Duration
+
+	// MaxTimeoutOverride is the longest hard timeout an execute request may
+	// ask for with timeout_override
+	MaxTimeoutOverride time.Duration
+
+	// MaxRequestBytes caps the size of request bodies, and Limits the fields
+	// of commands and executions. Zero or less disables a limit.
+	MaxRequestBytes int64
+	Limits          FieldLimits
+
+	// Compression gzips responses of at least CompressMinBytes for clients
+	// that accept it
+	Compression      bool
+	CompressMinBytes int
+
+	// MaxExecutionsLimit caps how many executions one list request returns,
+	// so a client that never pages cannot pull the whole history. Zero or
+	// less means unlimited.
+	MaxExecutionsLimit int
+
+	// Audit log rotation for the JSON store: rotate at AuditMaxBytes and/or
+	// daily, optionally gzip rotated segments and keep at most
+	// AuditMaxSegments of them (zero keeps all)
+	AuditMaxBytes    int64
+	AuditRotateDaily bool
+	AuditCompress    bool
+	AuditMaxSegments int
+
+	// ShutdownTimeout is how long shutdown waits for requests and running
+	// executions to finish before killing them
+	ShutdownTimeout time.Duration
+
+	// AlertRulesFile is a JSON file with tag/status based alert rules
+	AlertRulesFile string
+
+	// ExecutionRetention removes finished executions older than this, and
+	// KeepExecutionsPerCommand keeps only that many of the latest runs of
+	// each saved command. Zero disables either. The history is pruned at
+	// startup and every RetentionInterval.
+	ExecutionRetention       time.Duration
+	KeepExecutionsPerCommand int
+	RetentionInterval        time.Duration
+	// MaxExecutions caps how many executions are stored; each new execution
+	// evicts the oldest finished ones beyond it. Zero or less disables it.
+	MaxExecutions int
+
+	// CommandRevisions is how many previous versions of each saved command
+	// are kept. Zero or less keeps none.
+	CommandRevisions int
+
+	// RecordRejected stores execute attempts rejected by validation as
+	// executions with status "rejected"
+	RecordRejected bool
+	// MaxRejected caps how many rejected executions are kept
+	MaxRejected int
+}
+
+// LoadConfig reads configuration from the environment
+func LoadConfig() *Config {
+	return &Config{
+		Port:             envString("PORT", "3029"),
+		BasePath:         normalizeBasePath(os.Getenv("BASE_PATH")),
+		TLSCert:          os.Getenv("DEPLOYAR_TLS_CERT"),
+		TLSKey:           os.Getenv("DEPLOYAR_TLS_KEY"),
+		AutocertDomains:  envList("DEPLOYAR_AUTOCERT_DOMAINS"),
+		AutocertCacheDir: os.Getenv("DEPLOYAR_AUTOCERT_CACHE_DIR"),
+		AutocertEmail:    os.Getenv("DEPLOYAR_AUTOCERT_EMAIL"),
+		LogLevel:         envString("DEPLOYAR_LOG_LEVEL", "info"),
+		LogFormat:        envString("DEPLOYAR_LOG_FORMAT", "text"),
+		StaticDir:        envString("DEPLOYAR_STATIC_DIR", "./static"),
+		DataDir:          envString("DEPLOYAR_DATA_DIR", "."),
+		Store:            envString("DEPLOYAR_STORE", "json"),
+		SQLitePath:       envString("DEPLOYAR_SQLITE_PATH", "deployar.db"),
+		SessionTTL:       envDuration("DEPLOYAR_SESSION_TTL", 24*time.Hour),
+		LoginMaxAttempts: envInt("DEPLOYAR_LOGIN_MAX_ATTEMPTS", 5),
+		LoginWindow:      envDuration("DEPLOYAR_LOGIN_WINDOW", 15*time.Minute),
+		LoginLockout:     envDuration("DEPLOYAR_LOGIN_LOCKOUT", 15*time.Minute),
+		ClientIPHeader:   envString("DEPLOYAR_CLIENT_IP_HEADER", ""),
+		AllowedWorkdirs:  envList("DEPLOYAR_ALLOWED_WORKDIRS"),
+		AdminUsers:       envList("DEPLOYAR_ADMIN_USERS"),
+		CORSOrigins:      envList("DEPLOYAR_CORS_ORIGINS"),
+		CORSMethods:      envListDefault("DEPLOYAR_CORS_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		CORSHeaders:      envListDefault("DEPLOYAR_CORS_HEADERS", []string{"Content-Type", "Authorization", signatureHeader, timestampHeader, nonceHeader, "If-None-Match"}),
+		CORSMaxAge:       envOptionalDuration("DEPLOYAR_CORS_MAX_AGE", 10*time.Minute),
+
+		RequestSigning:           envBool("DEPLOYAR_REQUEST_SIGNING", false),
+		RequestSigningMaxSkew:    envDuration("DEPLOYAR_REQUEST_SIGNING_MAX_SKEW", 5*time.Minute),
+		MetricsPublic:            envBool("DEPLOYAR_METRICS_PUBLIC", false),
+		MetricsToken:             envString("DEPLOYAR_METRICS_TOKEN", ""),
+		MaxConcurrent:            envInt("DEPLOYAR_MAX_CONCURRENT", 8),
+		MaxOutputBytes:           int64(envInt("DEPLOYAR_MAX_OUTPUT_BYTES", 50<<20)),
+		KillOnOutputLimit:        envBool("DEPLOYAR_KILL_ON_OUTPUT_LIMIT", false),
+		ExecutionRateLimit:       envInt("DEPLOYAR_EXECUTION_RATE_LIMIT", 0),
+		ExecutionRateWindow:      envDuration("DEPLOYAR_EXECUTION_RATE_WINDOW", time.Minute),
+		ExecuteRequestRate:       envFloat("DEPLOYAR_EXECUTE_REQUEST_RATE", 0),
+		ExecuteRequestBurst:      envInt("DEPLOYAR_EXECUTE_REQUEST_BURST", 10),
+		IdempotencyTTL:           envDuration("DEPLOYAR_IDEMPOTENCY_TTL", 24*time.Hour),
+		MaxTimeoutOverride:       envDuration("DEPLOYAR_MAX_TIMEOUT_OVERRIDE", 24*time.Hour),
+		MaxRequestBytes:          int64(envInt("DEPLOYAR_MAX_REQUEST_BYTES", 4<<20)),
+		MaxExecutionsLimit:       envInt("DEPLOYAR_MAX_EXECUTIONS_LIMIT", 500),
+		Compression:              envBool("DEPLOYAR_COMPRESSION", true),
+		CompressMinBytes:         envInt("DEPLOYAR_COMPRESS_MIN_BYTES", 1024),
+		AuditMaxBytes:            int64(envInt("DEPLOYAR_AUDIT_MAX_BYTES", 0)),
+		AuditRotateDaily:         envBool("DEPLOYAR_AUDIT_ROTATE_DAILY", false),
+		AuditCompress:            envBool("DEPLOYAR_AUDIT_COMPRESS", false),
+		AuditMaxSegments:         envInt("DEPLOYAR_AUDIT_MAX_SEGMENTS", 0),
+		ShutdownTimeout:          envDuration("DEPLOYAR_SHUTDOWN_TIMEOUT", 30*time.Second),
+		AlertRulesFile:           os.Getenv("DEPLOYAR_ALERT_RULES_FILE"),
+		ExecutionRetention:       envDuration("DEPLOYAR_EXECUTION_RETENTION", 0),
+		KeepExecutionsPerCommand: envInt("DEPLOYAR_EXECUTION_KEEP_PER_COMMAND", 0),
+		RetentionInterval:        envDuration("DEPLOYAR_EXECUTION_RETENTION_INTERVAL", time.Hour),
+		MaxExecutions:            envInt("DEPLOYAR_EXECUTION_MAX_COUNT", 0),
+		CommandRevisions:         envInt("DEPLOYAR_COMMAND_REVISIONS", 20),
+		RecordRejected:           envBool("DEPLOYAR_RECORD_REJECTED_EXECUTIONS", false),
+		MaxRejected:              envInt("DEPLOYAR_MAX_REJECTED_EXECUTIONS", 100),
+
+		Limits: FieldLimits{
+			Name:        envInt("DEPLOYAR_MAX_NAME_LENGTH", 200),
+			Description: envInt("DEPLOYAR_MAX_DESCRIPTION_LENGTH", 4000),
+			Command:     envInt("DEPLOYAR_MAX_COMMAND_BYTES", 64<<10),
+			Workdir:     envInt("DEPLOYAR_MAX_WORKDIR_BYTES", 4096),
+			Tags:        envInt("DEPLOYAR_MAX_TAGS", 20),
+			Tag:         envInt("DEPLOYAR_MAX_TAG_LENGTH", 100),
+		},
+
+		CommandRulesFile:     os.Getenv("DEPLOYAR_COMMAND_RULES_FILE"),
+		CommandRulesDisabled: envBool("DEPLOYAR_COMMAND_RULES_DISABLED", false),
+
+		UsernameMinLength: envInt("DEPLOYAR_USERNAME_MIN_LENGTH", 3),
+		PasswordPolicy: PasswordPolicy{
+			MinLength:        envInt("DEPLOYAR_PASSWORD_MIN_LENGTH", 8),
+			RequireMixedCase: envBool("DEPLOYAR_PASSWORD_REQUIRE_MIXED_CASE", false),
+			RequireDigit:     envBool("DEPLOYAR_PASSWORD_REQUIRE_DIGIT", false),
+			RequireSymbol:    envBool("DEPLOYAR_PASSWORD_REQUIRE_SYMBOL", false),
+		},
+	}
+}
+
+// sqlitePath returns the SQLite database path, resolving a relative path
+// against the data directory
+func (c *Config) sqlitePath() string {
+	if filepath.IsAbs(c.SQLitePath) {
+		return c.SQLitePath
+	}
+	return filepath.Join(c.DataDir, c.SQLitePath)
+}
+
+// envString returns the value of key or fallback if unset
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envBool parses key as a boolean, returning fallback if unset or invalid
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envInt parses key as an integer, returning fallback if unset or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envFloat parses key as a number, returning fallback if unset or invalid
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDuration parses key as a duration (e.g. "30m"), returning fallback if
+// unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// envList splits key on commas, dropping empty entries
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envListDefault is envList returning fallback if key is unset or empty
+func envListDefault(key string, fallback []string) []string {
+	if list := envList(key); len(list) > 0 {
+		return list
+	}
+	return fallback
+}
+
+// envOptionalDuration parses key as a duration where zero is meaningful
+// (usually "disabled"), returning fallback if unset, invalid or negative
+func envOptionalDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// normalizeBasePath turns a BASE_PATH value into "/prefix" form, or "" for root
+func normalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
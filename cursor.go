@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// errInvalidCursor is returned for a ?cursor= that was not issued by the
+// server
+var errInvalidCursor = errors.New("cursor is invalid")
+
+// pageCursor is the position of an execution in the history order, as
+// encoded in the opaque next_cursor of the executions list
+type pageCursor struct {
+	StartedAt time.Time `json:"t"`
+	Seq       int64     `json:"s,omitempty"`
+	ID        string    `json:"i"`
+}
+
+// encodePageCursor returns the cursor of the page following execution
+func encodePageCursor(execution *Execution) string {
+	data, _ := json.Marshal(pageCursor{StartedAt: execution.StartedAt, Seq: execution.Seq, ID: execution.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor returns the position a cursor points after, as an
+// execution carrying only the fields the history is ordered by
+func decodePageCursor(value string) (*Execution, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, errInvalidCursor
+	}
+	return &Execution{ID: cursor.ID, Seq: cursor.Seq, StartedAt: cursor.StartedAt}, nil
+}
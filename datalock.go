@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockFile is the file in the data directory a running server holds locked
+const lockFile = "deployar.lock"
+
+// lockDataDir takes an exclusive advisory lock on the data directory, so a
+// second server started on the same directory fails fast instead of
+// overwriting the first one's files. The lock is held until the returned
+// file is closed or the process exits, also when it crashes.
+func lockDataDir(dir string) (*os.File, error) {
+	return lockPath(filepath.Join(dir, lockFile), "data directory "+dir)
+}
+
+// lockSQLite takes the same lock for a SQLite database, in a file next to
+// it. The database may live outside the data directory, where servers with
+// different data directories could otherwise share it.
+func lockSQLite(dbPath string) (*os.File, error) {
+	return lockPath(dbPath+".lock", "database "+dbPath)
+}
+
+// lockPath takes an exclusive advisory lock on the file at path, creating
+// it if needed. what names the locked resource in errors.
+func lockPath(path, what string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s is in use by another Deployar server%s", what, lockHolder(path))
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", what, err)
+	}
+
+	// Record the holder for the message above; the lock, not the content,
+	// decides who owns the directory
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// lockHolder describes the process that holds the lock file at path, if it
+// recorded its PID
+func lockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	pid := strings.TrimSpace(string(data))
+	if pid == "" {
+		return ""
+	}
+	return " (pid " + pid + ")"
+}
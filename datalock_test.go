@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		lock     func(dir string) (*os.File, error)
+		lockFile string
+		wantErr  string
+	}{
+		{
+			name:     "data directory",
+			lock:     lockDataDir,
+			lockFile: lockFile,
+			wantErr:  "data directory",
+		},
+		{
+			name:     "sqlite database",
+			lock:     func(dir string) (*os.File, error) { return lockSQLite(filepath.Join(dir, "deployar.db")) },
+			lockFile: "deployar.db.lock",
+			wantErr:  "database",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			first, err := tt.lock(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.lockFile)); err != nil {
+				t.Fatalf("lock file missing: %v", err)
+			}
+
+			second, err := tt.lock(dir)
+			if err == nil {
+				second.Close()
+				t.Fatal("second lock succeeded while the first was held")
+			}
+			pid := "pid " + strconv.Itoa(os.Getpid())
+			if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "in use") || !strings.Contains(err.Error(), pid) {
+				t.Errorf("error = %q, want it to name the %s and %s", err, tt.wantErr, pid)
+			}
+
+			// Released on close, so a restarted server can take it again
+			first.Close()
+			again, err := tt.lock(dir)
+			if err != nil {
+				t.Fatalf("lock after release: %v", err)
+			}
+			again.Close()
+		})
+	}
+}
+
+func TestLockMissingDirectory(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	if f, err := lockDataDir(missing); err == nil {
+		f.Close()
+		t.Fatal("locked a directory that does not exist")
+	}
+	if f, err := lockSQLite(filepath.Join(missing, "deployar.db")); err == nil {
+		f.Close()
+		t.Fatal("locked a database in a directory that does not exist")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "reflect"
+
+// diffCommand returns the user-editable fields that differ between old and updated
+func diffCommand(old, updated *Command) []FieldChange {
+	changes := make([]FieldChange, 0)
+
+	addChange := func(field string, oldValue, newValue interface{}) {
+		changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+	}
+
+	if old.Name != updated.Name {
+		addChange("name", old.Name, updated.Name)
+	}
+	if old.Description != updated.Description {
+		addChange("description", old.Description, updated.Description)
+	}
+	if old.Workdir != updated.Workdir {
+		addChange("workdir", old.Workdir, updated.Workdir)
+	}
+	if old.Command != updated.Command {
+		addChange("command", old.Command, updated.Command)
+	}
+	if !(len(old.Steps) == 0 && len(updated.Steps) == 0) && !reflect.DeepEqual(old.Steps, updated.Steps) {
+		addChange("steps", old.Steps, updated.Steps)
+	}
+	if old.Stdin != updated.Stdin {
+		addChange("stdin", old.Stdin, updated.Stdin)
+	}
+	if old.StdinFile != updated.StdinFile {
+		addChange("stdin_file", old.StdinFile, updated.StdinFile)
+	}
+	if !reflect.DeepEqual(old.Host, updated.Host) {
+		addChange("host", old.Host, updated.Host)
+	}
+	if old.Shell != updated.Shell {
+		addChange("shell", old.Shell, updated.Shell)
+	}
+	if !(len(old.Args) == 0 && len(updated.Args) == 0) && !reflect.DeepEqual(old.Args, updated.Args) {
+		addChange("args", old.Args, updated.Args)
+	}
+	if old.EnvPreset != updated.EnvPreset {
+		addChange("env_preset", old.EnvPreset, updated.EnvPreset)
+	}
+	if old.SoftTimeoutSeconds != updated.SoftTimeoutSeconds {
+		addChange("soft_timeout_seconds", old.SoftTimeoutSeconds, updated.SoftTimeoutSeconds)
+	}
+	if old.HardTimeoutSeconds != updated.HardTimeoutSeconds {
+		addChange("hard_timeout_seconds", old.HardTimeoutSeconds, updated.HardTimeoutSeconds)
+	}
+	if old.IsolateWorkdir != updated.IsolateWorkdir {
+		addChange("isolate_workdir", old.IsolateWorkdir, updated.IsolateWorkdir)
+	}
+	if old.SyncBack != updated.SyncBack {
+		addChange("sync_back", old.SyncBack, updated.SyncBack)
+	}
+	if old.MaxRetries != updated.MaxRetries {
+		addChange("max_retries", old.MaxRetries, updated.MaxRetries)
+	}
+	if old.RetryDelaySeconds != updated.RetryDelaySeconds {
+		addChange("retry_delay_seconds", old.RetryDelaySeconds, updated.RetryDelaySeconds)
+	}
+	if old.FailureCooldownSeconds != updated.FailureCooldownSeconds {
+		addChange("failure_cooldown_seconds", old.FailureCooldownSeconds, updated.FailureCooldownSeconds)
+	}
+	if old.RequireReauth != updated.RequireReauth {
+		addChange("require_reauth", old.RequireReauth, updated.RequireReauth)
+	}
+	if old.RequireApproval != updated.RequireApproval {
+		addChange("require_approval", old.RequireApproval, updated.RequireApproval)
+	}
+	if old.ExpandWorkdir != updated.ExpandWorkdir {
+		addChange("expand_workdir", old.ExpandWorkdir, updated.ExpandWorkdir)
+	}
+	if old.SingleInstance != updated.SingleInstance {
+		addChange("single_instance", old.SingleInstance, updated.SingleInstance)
+	}
+	if old.Schedule != updated.Schedule {
+		addChange("schedule", old.Schedule, updated.Schedule)
+	}
+	if !(len(old.Env) == 0 && len(updated.Env) == 0) && !reflect.DeepEqual(old.Env, updated.Env) {
+		addChange("env", old.Env, updated.Env)
+	}
+	if !(len(old.Parameters) == 0 && len(updated.Parameters) == 0) && !reflect.DeepEqual(old.Parameters, updated.Parameters) {
+		addChange("parameters", old.Parameters, updated.Parameters)
+	}
+	if !(len(old.Tags) == 0 && len(updated.Tags) == 0) && !reflect.DeepEqual(old.Tags, updated.Tags) {
+		addChange("tags", old.Tags, updated.Tags)
+	}
+
+	return changes
+}
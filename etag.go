@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// listETag derives the ETag of a list response from the versions of the
+// data it was built from, the requesting user and the query, so polling
+// clients can be answered without encoding the list
+func listETag(username, rawQuery string, versions ...int64) string {
+	hash := sha256.New()
+	for _, version := range versions {
+		fmt.Fprintf(hash, "%d\n", version)
+	}
+	fmt.Fprintf(hash, "%s\n%s", username, rawQuery)
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// checkNotModified sets the ETag header and reports whether the request's
+// If-None-Match matches it, in which case a 304 has been written
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	match := r.Header.Get("If-None-Match")
+	if match == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// commandsChanged invalidates the ETag of the commands list. Call it after
+// any change to the saved commands or to a user's favorites.
+func (app *App) commandsChanged() {
+	app.commandsVersion.Add(1)
+}
@@ -2,124 +2,1479 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 )
 
+// killGracePeriod is how long a cancelled or timed out process gets to exit
+// after SIGTERM before it is killed with SIGKILL
+const killGracePeriod = 5 * time.Second
+
+var (
+	// ErrShuttingDown is returned when executing while the server shuts down
+	ErrShuttingDown = errors.New("server is shutting down")
+	// ErrCommandRunning is returned when executing a single instance command
+	// that is already running or queued
+	ErrCommandRunning = errors.New("command is already running")
+	// ErrExecutionNotFound is returned when an execution ID is unknown
+	ErrExecutionNotFound = errors.New("execution not found")
+	// ErrExecutionNotRunning is returned when an execution has already finished
+	ErrExecutionNotRunning = errors.New("execution is not running")
+	// ErrExecutionStopping is returned when an execution is already being
+	// terminated, e.g. by its hard timeout
+	ErrExecutionStopping = errors.New("execution is already stopping")
+	// ErrWorkdirNotAllowed is returned when a workdir is outside the allowed
+	// directories
+	ErrWorkdirNotAllowed = errors.New("workdir not allowed")
+)
+
+// redactedValue replaces secret environment values in stored records
+const redactedValue = "********"
+
+// envKeyPattern matches valid environment variable names
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// labelKeyPattern matches valid execution label names
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// labelEnvPrefix prefixes the environment variables labels are passed as
+const labelEnvPrefix = "DEPLOYAR_LABEL_"
+
+// secretKeyPattern matches environment variable names that look secret,
+// which workdir expansion refuses to use
+var secretKeyPattern = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASS|KEY|CREDENTIAL|AUTH|PRIVATE)`)
+
+// minSecretLength is the shortest value masked in command output. Shorter
+// values would mask unrelated text.
+const minSecretLength = 4
+
+// maxPendingLine bounds how much of an unterminated line the log writer
+// buffers before writing it out anyway
+const maxPendingLine = 64 * 1024
+
+// maxStatusLog bounds how many recent status changes are kept for WebSocket
+// clients to catch up from
+const maxStatusLog = 4096
+
+// statusChange records an execution reaching a status at a revision
+type statusChange struct {
+	Rev    int64
+	ID     string
+	Status string
+}
+
+// runningProcess tracks the OS process behind a running execution
+type runningProcess struct {
+	cmd         *exec.Cmd
+	remote      *remoteProcess // Runs cmd's command over SSH instead, if set
+	stdin       io.Closer      // Standard input of cmd, if any
+	logFiles    []*os.File
+	log         *logWriter // combined stdout and stderr
+	output      *outputCap // Shared size cap of stdout and stderr
+	stdout      *logWriter
+	stderr      *logWriter
+	cancelled   bool
+	killed      string // Reason the executor killed the process, if any
+	interrupted bool   // Killed because the server shut down
+	stopping    bool   // Termination signals have been sent
+	timers      []*time.Timer
+	done        chan struct{}
+	params      ExecuteParams
+	attempt     int           // Attempt number, starting at 1
+	step        int           // Index of the running step, for multi-step commands
+	logPath     string        // Combined log file of the attempt
+	startedAt   time.Time     // When the attempt's process started
+	retryWait   chan struct{} // Closed to cut the wait before the next attempt short
+	recorded    bool          // The attempt has been added to the execution's attempts
+
+	workingCopy string // Temporary copy of the workdir the process runs in, if isolated
+	syncBack    bool   // Copy the working copy back over the workdir on success
+	isolation   string // Outcome of the working copy once the process exited
+}
+
+// logWriter streams process output to a log file, masking secret values.
+// When secrets are set output is written line by line so a value is never
+// split across writes.
+type logWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets []string
+	pending []byte
+	size    int64
+}
+
+// Write implements io.Writer
+func (lw *logWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if len(lw.secrets) == 0 {
+		n, err := lw.w.Write(p)
+		lw.size += int64(n)
+		return n, err
+	}
+
+	lw.pending = append(lw.pending, p...)
+	cut := bytes.LastIndexByte(lw.pending, '\n') + 1
+	if cut == 0 && len(lw.pending) >= maxPendingLine {
+		cut = len(lw.pending)
+	}
+	if cut > 0 {
+		if err := lw.emit(lw.pending[:cut]); err != nil {
+			return 0, err
+		}
+		lw.pending = append([]byte(nil), lw.pending[cut:]...)
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered partial line
+func (lw *logWriter) Flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if len(lw.pending) == 0 {
+		return nil
+	}
+	err := lw.emit(lw.pending)
+	lw.pending = nil
+	return err
+}
+
+func (lw *logWriter) emit(p []byte) error {
+	n, err := io.WriteString(lw.w, redactSecrets(string(p), lw.secrets))
+	lw.size += int64(n)
+	return err
+}
+
+// outputCap limits the combined output of a process. Output past the limit
+// is discarded, and a notice is written to the combined log once.
+type outputCap struct {
+	mu        sync.Mutex
+	limit     int64 // Zero or less means unlimited
+	total     int64 // Bytes produced, including discarded ones
+	truncated bool
+	notice    io.Writer
+	onExceed  func() // Called once when the limit is first exceeded
+}
+
+// writer returns a writer for one output stream that counts against the cap
+func (c *outputCap) writer(w io.Writer) io.Writer {
+	return cappedWriter{cap: c, w: w}
+}
+
+// cappedWriter is a single stream's view of an outputCap
+type cappedWriter struct {
+	cap *outputCap
+	w   io.Writer
+}
+
+// Write implements io.Writer. Discarded output is reported as written so the
+// command does not fail on a broken pipe.
+func (cw cappedWriter) Write(p []byte) (int, error) {
+	c := cw.cap
+	c.mu.Lock()
+	keep := int64(len(p))
+	if c.limit > 0 && c.total+keep > c.limit {
+		keep = c.limit - c.total
+		if keep < 0 {
+			keep = 0
+		}
+	}
+	c.total += int64(len(p))
+	exceeded := keep < int64(len(p)) && !c.truncated
+	if exceeded {
+		c.truncated = true
+	}
+	// Write while holding the lock so the notice comes after the kept output
+	var err error
+	if keep > 0 {
+		_, err = cw.w.Write(p[:keep])
+	}
+	if exceeded {
+		fmt.Fprintf(c.notice, "\n[output truncated: limit of %d bytes exceeded]\n", c.limit)
+	}
+	c.mu.Unlock()
+
+	if exceeded && c.onExceed != nil {
+		c.onExceed()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stats returns the bytes produced and whether output was truncated
+func (c *outputCap) stats() (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total, c.truncated
+}
+
+// terminate sends SIGTERM to the process group and SIGKILL if it is still
+// alive after the grace period. Caller must hold the executor lock.
+func (p *runningProcess) terminate() {
+	if p.stopping {
+		return
+	}
+	p.stopping = true
+
+	if p.remote != nil {
+		p.remote.terminate()
+		return
+	}
+	if p.cmd.Process == nil {
+		// Not started yet; the executor checks cancelled before starting it
+		return
+	}
+
+	pgid := p.cmd.Process.Pid
+	syscall.Kill(-pgid, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-p.done:
+		case <-time.After(killGracePeriod):
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}()
+}
+
+// workdirIn returns the directory the current step runs in, given the
+// directory the command runs in
+func (p *runningProcess) workdirIn(dir string) string {
+	if len(p.params.Steps) == 0 {
+		return dir
+	}
+	return stepWorkdir(dir, p.params.Steps[p.step])
+}
+
+// baseDir returns the directory the command runs in: its working copy if
+// isolated, otherwise its workdir
+func (p *runningProcess) baseDir(execution *Execution) string {
+	if p.workingCopy != "" {
+		return p.workingCopy
+	}
+	return execution.Workdir
+}
+
+// closeLogs closes all log files of the process
+func (p *runningProcess) closeLogs() {
+	for _, logFile := range p.logFiles {
+		logFile.Close()
+	}
+}
+
 // Executor manages command execution
 type Executor struct {
-	storage    *Storage
+	mu         sync.RWMutex
+	config     *Config
+	storage    Store
+	notifier   *Notifier
 	executions map[string]*Execution
+	lastRuns   map[string]*Execution // Latest non-rejected execution of each saved command
+	running    map[string]*runningProcess
+	queue      []*queuedRun   // Executions waiting for a free slot, oldest first
+	seq        int64          // Last assigned execution sequence number
+	rev        int64          // Last assigned execution revision
+	changed    chan struct{}  // Closed and replaced whenever an execution changes
+	version    int64          // Bumped on every change to the history, including deletions
+	statusLog  []statusChange // Recent status changes, oldest first, see StatusChanges
+	statusFrom int64          // Revision the status log is complete after
+	loadErr    error          // Error loading stored executions, if any
+	prom       *PromMetrics
+	closing    bool           // Shutdown has begun; nothing new starts
+	wg         sync.WaitGroup // Tracks executions in e.running until they finish
+}
+
+// queuedRun is an execution waiting for a free slot
+type queuedRun struct {
+	execution *Execution
+	proc      *runningProcess
 }
 
 // NewExecutor creates a new executor instance
-func NewExecutor(storage *Storage) *Executor {
-	executions, err := storage.LoadExecutions()
-	if err != nil {
+func NewExecutor(storage Store, config *Config, notifier *Notifier) *Executor {
+	executions, loadErr := storage.LoadExecutions()
+	if loadErr != nil {
 		executions = make(map[string]*Execution)
 	}
 
-	return &Executor{
+	// Never hand out a number already used, even if the counter was lost
+	seq, err := storage.LoadSequence()
+	if err != nil {
+		slog.Error("Failed to load execution sequence", "error", err)
+	}
+	var rev int64
+	for _, exec := range executions {
+		if exec.Seq > seq {
+			seq = exec.Seq
+		}
+		if exec.Rev > rev {
+			rev = exec.Rev
+		}
+	}
+
+	// Queued executions do not survive a restart, and ones still marked
+	// running were cut off when the server stopped without shutting down
+	for _, exec := range executions {
+		switch exec.Status {
+		case "queued":
+			exec.Status = "cancelled"
+			exec.KillReason = "server restarted before the execution started"
+		case "running":
+			exec.Status = "interrupted"
+			exec.KillReason = "server stopped while the execution was running"
+		default:
+			continue
+		}
+		exec.Steps = interruptSteps(exec.Steps)
+		exec.ExitCode = -1
+		exec.EndedAt = time.Now()
+		rev++
+		exec.Rev = rev
+	}
+	for _, exec := range executions {
+		exec.loggedStatus = exec.Status
+	}
+
+	e := &Executor{
+		config:     config,
 		storage:    storage,
+		notifier:   notifier,
 		executions: executions,
+		running:    make(map[string]*runningProcess),
+		seq:        seq,
+		rev:        rev,
+		changed:    make(chan struct{}),
+		loadErr:    loadErr,
+	}
+	e.lastRuns = make(map[string]*Execution)
+	for _, exec := range executions {
+		e.indexLastRunLocked(exec)
+	}
+	e.prom = NewPromMetrics(e.RunningCount, e.QueueDepth)
+	return e
+}
+
+// nextSeqLocked assigns and persists the next execution sequence number.
+// Caller must hold e.mu.
+func (e *Executor) nextSeqLocked() int64 {
+	e.seq++
+	if err := e.storage.SaveSequence(e.seq); err != nil {
+		slog.Error("Failed to save execution sequence", "error", err)
+	}
+	return e.seq
+}
+
+// saveLocked persists all executions, logging failures. Caller must hold e.mu.
+func (e *Executor) saveLocked() {
+	if err := e.storage.SaveExecutions(e.executions); err != nil {
+		slog.Error("Failed to save executions", "error", err)
+	}
+}
+
+// touchLocked gives an execution a new revision and wakes up waiters.
+// Caller must hold e.mu for writing.
+func (e *Executor) touchLocked(execution *Execution) {
+	e.rev++
+	e.version++
+	execution.Rev = e.rev
+	if execution.Status != execution.loggedStatus {
+		execution.loggedStatus = execution.Status
+		e.logStatusLocked(statusChange{Rev: e.rev, ID: execution.ID, Status: execution.Status})
+	}
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// logStatusLocked appends to the status log, dropping the oldest half once it
+// reaches maxStatusLog entries
+func (e *Executor) logStatusLocked(change statusChange) {
+	if len(e.statusLog) >= maxStatusLog {
+		dropped := len(e.statusLog) - maxStatusLog/2
+		e.statusFrom = e.statusLog[dropped-1].Rev
+		e.statusLog = append([]statusChange(nil), e.statusLog[dropped:]...)
+	}
+	e.statusLog = append(e.statusLog, change)
+}
+
+// StatusChanges returns the status changes with a revision after since and
+// up to until, oldest first, each with a snapshot of the execution as it is
+// now. Executions deleted since are left out. It returns false if the log no
+// longer reaches back to since.
+func (e *Executor) StatusChanges(since, until int64) ([]ExecutionEvent, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if since < e.statusFrom {
+		return nil, false
+	}
+	start := sort.Search(len(e.statusLog), func(i int) bool {
+		return e.statusLog[i].Rev > since
+	})
+	events := make([]ExecutionEvent, 0)
+	snapshots := make(map[string]*Execution)
+	for _, change := range e.statusLog[start:] {
+		if change.Rev > until {
+			break
+		}
+		snapshot, ok := snapshots[change.ID]
+		if !ok {
+			execution, exists := e.executions[change.ID]
+			if !exists {
+				continue
+			}
+			copied := *execution
+			snapshot = &copied
+			snapshots[change.ID] = snapshot
+		}
+		events = append(events, ExecutionEvent{Type: "execution", Status: change.Status, Execution: snapshot})
+	}
+	return events, true
+}
+
+// Changes returns the current revision cursor and a channel that is closed
+// on the next change to any execution
+func (e *Executor) Changes() (int64, <-chan struct{}) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.rev, e.changed
+}
+
+// Version returns a counter that changes whenever the history does, unlike
+// the revision cursor also when executions are deleted
+func (e *Executor) Version() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.version
+}
+
+// ExecuteParams describes a command to run
+type ExecuteParams struct {
+	Workdir     string
+	Command     string
+	Shell       string            // Shell to run Command in, "" for sh or directExec
+	Args        []string          // Positional parameters or program arguments
+	Env         map[string]string // Passed to the process, redacted in the record
+	Tags        []string
+	Steps       []Step // Run one after the other instead of Command
+	CommandID   string // Saved command, if any
+	CommandName string
+	Params      map[string]string // Parameter values already substituted into Command and Workdir
+	Labels      map[string]string // Passed to the process as DEPLOYAR_LABEL_<NAME>
+	Username    string
+	RerunOf     string        // Execution this one re-runs, if any
+	SoftTimeout time.Duration // Flag the execution as slow after this long
+	HardTimeout time.Duration // Kill the execution after this long
+	Stdin       string        // Piped to the command's standard input
+	StdinFile   string        // File piped to standard input instead, relative to the workdir
+	Host        *RemoteHost   // Run over SSH instead of locally, if set
+
+	TimeoutOverridden bool // HardTimeout was given with the execute request
+	SingleInstance    bool // Refuse to run while CommandID is running or queued
+
+	IsolateWorkdir bool // Run in a temporary copy of the workdir
+	SyncBack       bool // Copy the working copy back over the workdir on success
+
+	MaxRetries int           // Re-run a failed execution up to this many times
+	RetryDelay time.Duration // Wait between attempts
+}
+
+// Execute runs a command and records the execution. If MaxConcurrent
+// executions are already running the execution is queued and starts when a
+// slot frees up. The returned record is a snapshot.
+func (e *Executor) Execute(params ExecuteParams) (*Execution, error) {
+	execution := newExecution(params)
+	proc, err := e.newProcess(execution, params, 1)
+	if err != nil {
+		return nil, err
 	}
+	execution.LogFile = proc.logPath
+
+	// Save initial execution state, taking a slot or joining the queue
+	e.mu.Lock()
+	if e.closing {
+		e.mu.Unlock()
+		proc.closeLogs()
+		removeLog(proc.logPath)
+		return nil, ErrShuttingDown
+	}
+	if params.SingleInstance && e.commandActiveLocked(params.CommandID) {
+		e.mu.Unlock()
+		proc.closeLogs()
+		removeLog(proc.logPath)
+		return nil, ErrCommandRunning
+	}
+	execution.Seq = e.nextSeqLocked()
+	e.executions[execution.ID] = execution
+	e.indexLastRunLocked(execution)
+	e.evictOverCapLocked()
+	return e.launch(execution, proc), nil
 }
 
-// Execute runs a command and records the execution
-func (e *Executor) Execute(workdir, command, commandID, commandName, username string) (*Execution, error) {
+// newExecution creates the record of an execution of params
+func newExecution(params ExecuteParams) *Execution {
 	execution := &Execution{
 		ID:         uuid.New().String(),
-		CommandID:  commandID,
-		Name:       commandName,
-		Workdir:    workdir,
-		Command:    command,
+		CommandID:  params.CommandID,
+		Name:       params.CommandName,
+		Workdir:    params.Workdir,
+		Command:    params.Command,
+		Shell:      params.Shell,
+		Args:       params.Args,
+		Env:        redactEnv(params.Env),
+		Params:     params.Params,
+		Labels:     params.Labels,
+		Tags:       params.Tags,
+		Steps:      newStepResults(params.Steps),
+		StdinFile:  params.StdinFile,
+		StdinBytes: len(params.Stdin),
+		Host:       params.remoteHost(),
+		RerunOf:    params.RerunOf,
 		Status:     "running",
-		ExecutedBy: username,
+		ExecutedBy: params.Username,
 		StartedAt:  time.Now(),
 	}
+	execution.setTimeout(params)
+	if params.MaxRetries > 0 {
+		execution.Attempt = 1
+		execution.MaxAttempts = params.MaxRetries + 1
+	}
+	return execution
+}
+
+// remoteHost returns the remote host params run on as user@address, or ""
+// for local runs
+func (params ExecuteParams) remoteHost() string {
+	if params.Host == nil {
+		return ""
+	}
+	return params.Host.String()
+}
+
+// setTimeout records the hard timeout params run with
+func (execution *Execution) setTimeout(params ExecuteParams) {
+	execution.TimeoutSeconds = int(params.HardTimeout / time.Second)
+	execution.TimeoutOverridden = params.TimeoutOverridden
+}
+
+// launch takes a slot for an execution in the history and starts it, or
+// queues it if all slots are taken, returning a snapshot. Caller must hold
+// e.mu, which launch releases.
+func (e *Executor) launch(execution *Execution, proc *runningProcess) *Execution {
+	if e.slotsFullLocked() {
+		execution.Status = "queued"
+		queuedAt := execution.StartedAt
+		execution.QueuedAt = &queuedAt
+		e.queue = append(e.queue, &queuedRun{execution: execution, proc: proc})
+		e.touchLocked(execution)
+		e.saveLocked()
+		slog.Info("Execution queued", "execution_id", execution.ID, "command_id", execution.CommandID, "user", execution.ExecutedBy)
+		snapshot := *execution
+		e.mu.Unlock()
+		return &snapshot
+	}
+	execution.Status = "running"
+	e.running[execution.ID] = proc
+	e.wg.Add(1)
+	e.touchLocked(execution)
+	e.saveLocked()
+	e.mu.Unlock()
+
+	e.start(execution, proc)
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	snapshot := *execution
+	return &snapshot
+}
+
+// newProcess creates the log files and command for an attempt of an
+// execution. Output is streamed to the attempt's log files.
+func (e *Executor) newProcess(execution *Execution, params ExecuteParams, attempt int) (*runningProcess, error) {
+	secrets := secretValues(params.Env)
+	proc := &runningProcess{done: make(chan struct{}), params: params, attempt: attempt}
+	for _, stream := range append([]string{""}, logStreams...) {
+		logFile, err := createLog(e.config.DataDir, attemptLogName(execution.ID, attempt), stream)
+		if err != nil {
+			proc.closeLogs()
+			removeLog(proc.logPath)
+			return nil, fmt.Errorf("failed to create log file: %w", err)
+		}
+		proc.logFiles = append(proc.logFiles, logFile)
+
+		writer := &logWriter{w: logFile, secrets: secrets}
+		switch stream {
+		case "":
+			proc.logPath = logFile.Name()
+			proc.log = writer
+		case "stdout":
+			proc.stdout = writer
+		case "stderr":
+			proc.stderr = writer
+		}
+	}
+
+	proc.output = &outputCap{limit: e.config.MaxOutputBytes, notice: proc.log}
+	if e.config.KillOnOutputLimit {
+		proc.output.onExceed = func() { e.killForOutput(execution, proc) }
+	}
+	proc.cmd = e.processCommand(execution, proc, execution.Workdir)
+	return proc, nil
+}
+
+// processCommand builds the OS command of proc, or of its current step for
+// multi-step commands, to run in dir
+func (e *Executor) processCommand(execution *Execution, proc *runningProcess, dir string) *exec.Cmd {
+	params := proc.params
+	command := execution.Command
+	if len(params.Steps) > 0 {
+		command = params.Steps[proc.step].Command
+	}
+
+	cmd := shellCommand(command, params.Shell, params.Args)
+	cmd.Dir = proc.workdirIn(dir)
+	cmd.Stdout = proc.output.writer(io.MultiWriter(proc.log, proc.stdout))
+	cmd.Stderr = proc.output.writer(io.MultiWriter(proc.log, proc.stderr))
+	if stdin := processStdin(cmd, params); stdin != nil {
+		cmd.Stdin = stdin
+		proc.stdin = stdin
+	}
+	if len(params.Env) > 0 || len(params.Labels) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range params.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+		for key, value := range params.Labels {
+			cmd.Env = append(cmd.Env, labelEnvName(key)+"="+value)
+		}
+	}
+	// Run in its own process group so cancellation reaches child processes
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	proc.remote = nil
+	if params.Host != nil {
+		proc.remote = newRemoteProcess(params.Host, command, params.Shell, params.Args, params.Env, params.Labels)
+	}
+	return cmd
+}
+
+// slotsFullLocked reports whether the concurrency limit is reached. Caller
+// must hold e.mu.
+func (e *Executor) slotsFullLocked() bool {
+	return e.config.MaxConcurrent > 0 && len(e.running) >= e.config.MaxConcurrent
+}
+
+// start launches an execution that already holds a slot in e.running.
+// Isolated runs get their working copy here, so a queued run copies the
+// workdir as it is when the run starts.
+func (e *Executor) start(execution *Execution, proc *runningProcess) {
+	params := proc.params
+	var workingCopy string
+	var startErr error
+	if params.IsolateWorkdir {
+		if workingCopy, startErr = createWorkingCopy(execution.ID, execution.Workdir); startErr != nil {
+			startErr = fmt.Errorf("failed to create working copy: %w", startErr)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if workingCopy != "" {
+		// Run isolated commands on a throwaway copy of the workdir
+		proc.workingCopy = workingCopy
+		proc.syncBack = params.SyncBack
+		proc.cmd.Dir = proc.workdirIn(workingCopy)
+		execution.IsolatedWorkdir = workingCopy
+		e.touchLocked(execution)
+	}
+	e.prom.Started()
+
+	// The execution may have been cancelled while the working copy was made
+	if startErr == nil && !proc.cancelled {
+		proc.startedAt = time.Now()
+		e.beginStepLocked(execution, proc)
+		startErr = e.startProcessLocked(execution, proc)
+	}
+	if startErr != nil || proc.cancelled {
+		if proc.workingCopy != "" {
+			proc.isolation = finishWorkingCopy(proc.workingCopy, execution.Workdir, false, false)
+		}
+		delete(e.running, execution.ID)
+		e.finishLocked(execution, proc, startErr)
+		e.startQueuedLocked()
+		e.wg.Done()
+		return
+	}
+
+	slog.Info("Execution started", "execution_id", execution.ID, "command_id", execution.CommandID,
+		"user", execution.ExecutedBy, "pid", execution.PID, "host", execution.Host)
+	e.startTimeouts(execution, proc, params.SoftTimeout, params.HardTimeout)
+
+	// Wait for the command in background
+	go e.runCommand(execution, proc)
+}
+
+// startQueuedLocked moves queued executions into free slots, oldest first.
+// Caller must hold e.mu.
+func (e *Executor) startQueuedLocked() {
+	started := false
+	for len(e.queue) > 0 && !e.slotsFullLocked() && !e.closing {
+		run := e.queue[0]
+		e.queue = e.queue[1:]
+
+		run.execution.Status = "running"
+		run.execution.StartedAt = time.Now()
+		e.running[run.execution.ID] = run.proc
+		e.wg.Add(1)
+		e.touchLocked(run.execution)
+		started = true
+		go e.start(run.execution, run.proc)
+	}
+	if started {
+		e.saveLocked()
+	}
+}
+
+// dequeueLocked removes an execution from the queue and returns it, or nil
+// if it is not queued. Caller must hold e.mu.
+func (e *Executor) dequeueLocked(id string) *queuedRun {
+	for i, run := range e.queue {
+		if run.execution.ID == id {
+			e.queue = append(e.queue[:i], e.queue[i+1:]...)
+			return run
+		}
+	}
+	return nil
+}
+
+// RecordRejected stores an execute attempt that was rejected before running,
+// if enabled. Only the newest MaxRejected rejected records are kept.
+func (e *Executor) RecordRejected(params ExecuteParams, reason string) {
+	if !e.config.RecordRejected || e.config.MaxRejected <= 0 {
+		return
+	}
+
+	now := time.Now()
+	execution := &Execution{
+		ID:         uuid.New().String(),
+		CommandID:  params.CommandID,
+		Name:       params.CommandName,
+		Workdir:    params.Workdir,
+		Command:    params.Command,
+		Shell:      params.Shell,
+		Args:       params.Args,
+		Labels:     params.Labels,
+		Tags:       params.Tags,
+		RerunOf:    params.RerunOf,
+		Status:     "rejected",
+		Output:     reason,
+		ExecutedBy: params.Username,
+		StartedAt:  now,
+		EndedAt:    now,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Evict the oldest rejected records to stay within the cap
+	var rejected []*Execution
+	for _, exec := range e.executions {
+		if exec.Status == "rejected" {
+			rejected = append(rejected, exec)
+		}
+	}
+	if excess := len(rejected) - e.config.MaxRejected + 1; excess > 0 {
+		sort.Slice(rejected, func(i, j int) bool {
+			return newerExecution(rejected[j], rejected[i])
+		})
+		for _, exec := range rejected[:excess] {
+			removeExecutionLogs(exec)
+			delete(e.executions, exec.ID)
+		}
+	}
 
-	// Save initial execution state
+	execution.Seq = e.nextSeqLocked()
 	e.executions[execution.ID] = execution
-	e.storage.SaveExecutions(e.executions)
+	e.evictOverCapLocked()
+	e.touchLocked(execution)
+	e.saveLocked()
+	e.prom.Finished(execution)
+}
+
+// runCommand waits for the started command and records its result. The
+// steps of multi-step commands run one after the other. Failed executions
+// are retried up to MaxRetries times.
+func (e *Executor) runCommand(execution *Execution, proc *runningProcess) {
+	err := e.wait(execution, proc)
+	for {
+		for {
+			var started bool
+			if started, err = e.nextStep(execution, proc, err); !started {
+				break
+			}
+			if err == nil {
+				err = e.wait(execution, proc)
+			}
+		}
+		close(proc.done)
+		for _, timer := range proc.timers {
+			timer.Stop()
+		}
 
-	// Execute command in background
-	go e.runCommand(execution)
+		next, startErr := e.nextAttempt(execution, proc, err)
+		if next == nil {
+			break
+		}
+		proc = next
+		if startErr != nil {
+			_, err = e.nextStep(execution, proc, startErr)
+			break
+		}
+		err = e.wait(execution, proc)
+	}
 
-	return execution, nil
+	// Sync back and remove the working copy before taking the lock, since
+	// copying can take a while
+	if proc.workingCopy != "" {
+		e.mu.RLock()
+		succeeded := err == nil && !proc.cancelled && proc.killed == ""
+		e.mu.RUnlock()
+		proc.isolation = finishWorkingCopy(proc.workingCopy, execution.Workdir, proc.syncBack, succeeded)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.wg.Done()
+
+	delete(e.running, execution.ID)
+	e.finishLocked(execution, proc, err)
+	e.startQueuedLocked()
 }
 
-// runCommand executes the actual command
-func (e *Executor) runCommand(execution *Execution) {
-	var stdout, stderr bytes.Buffer
+// nextAttempt records a failed attempt, waits out the retry delay and starts
+// the next attempt in the same working copy. It returns nil if the attempt
+// is not retried or the execution was cancelled meanwhile. A non-nil error
+// means the returned attempt failed to start.
+func (e *Executor) nextAttempt(execution *Execution, proc *runningProcess, err error) (*runningProcess, error) {
+	e.mu.Lock()
+	if err == nil || proc.cancelled || e.closing || proc.attempt > proc.params.MaxRetries {
+		e.mu.Unlock()
+		return nil, nil
+	}
+	e.recordAttemptLocked(execution, proc, err)
+	proc.retryWait = make(chan struct{})
+	wait := proc.retryWait
+	e.touchLocked(execution)
+	e.saveLocked()
+	e.mu.Unlock()
 
-	// Parse command - support shell commands with pipes, etc.
-	cmd := exec.Command("sh", "-c", execution.Command)
-	cmd.Dir = execution.Workdir
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	timer := time.NewTimer(proc.params.RetryDelay)
+	select {
+	case <-timer.C:
+	case <-wait:
+		timer.Stop()
+	}
+
+	next, createErr := e.newProcess(execution, proc.params, proc.attempt+1)
 
-	// Run the command
-	err := cmd.Run()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if createErr != nil {
+		slog.Error("Failed to start attempt", "execution_id", execution.ID, "attempt", proc.attempt+1, "error", createErr)
+		return nil, nil
+	}
+	if proc.cancelled || e.closing {
+		next.closeLogs()
+		removeLog(next.logPath)
+		return nil, nil
+	}
+
+	next.workingCopy = proc.workingCopy
+	next.syncBack = proc.syncBack
+	if next.workingCopy != "" {
+		next.cmd.Dir = next.workdirIn(next.workingCopy)
+	}
+	execution.Attempt = next.attempt
+	execution.LogFile = next.logPath
+	execution.SoftTimeoutExceeded = false
+	execution.Steps = newStepResults(next.params.Steps)
+	e.running[execution.ID] = next
+	next.startedAt = time.Now()
+	e.beginStepLocked(execution, next)
+	e.touchLocked(execution)
+	e.saveLocked()
 
+	if err := e.startProcessLocked(execution, next); err != nil {
+		return next, err
+	}
+	e.startTimeouts(execution, next, next.params.SoftTimeout, next.params.HardTimeout)
+	return next, nil
+}
+
+// exitCode returns the exit status of a process that ran and exited with a
+// non-zero status, locally or on a remote host
+func exitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	var remoteErr *ssh.ExitError
+	if errors.As(err, &remoteErr) {
+		if remoteErr.Signal() != "" {
+			// Killed by a signal, like ExitCode reports for local processes
+			return -1, true
+		}
+		return remoteErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
+// processResult returns the status and exit code of a finished process
+func processResult(proc *runningProcess, err error) (string, int) {
+	exitStatus, isExitErr := exitCode(err)
+	switch {
+	case proc.cancelled || proc.killed != "":
+		exitCode := -1
+		if isExitErr {
+			exitCode = exitStatus
+		}
+		if proc.cancelled {
+			return "cancelled", exitCode
+		}
+		if proc.interrupted {
+			return "interrupted", exitCode
+		}
+		return "failed", exitCode
+	case isExitErr:
+		return "failed", exitStatus
+	case err != nil:
+		return "failed", 1
+	default:
+		return "success", 0
+	}
+}
+
+// recordAttemptLocked closes the logs of a finished attempt and, for
+// executions that can be retried, adds it to the execution's attempts.
+// Caller must hold e.mu.
+func (e *Executor) recordAttemptLocked(execution *Execution, proc *runningProcess, err error) {
+	if proc.recorded {
+		return
+	}
+	proc.recorded = true
+
+	// Report a start failure on the combined log and stderr, unless it was
+	// reported for the step that failed to start
+	_, isExitErr := exitCode(err)
+	reported := len(execution.Steps) > 0 && execution.Steps[proc.step].Error != ""
+	if err != nil && !isExitErr && !reported && !proc.cancelled && proc.killed == "" {
+		errLog := io.MultiWriter(proc.log, proc.stderr)
+		if proc.log.size > 0 {
+			fmt.Fprintln(errLog)
+		}
+		fmt.Fprintf(errLog, "Error: %v\n", err)
+	}
+
+	proc.log.Flush()
+	proc.stdout.Flush()
+	proc.stderr.Flush()
+	proc.closeLogs()
+
+	if execution.MaxAttempts == 0 {
+		return
+	}
+	status, exitCode := processResult(proc, err)
+	attempt := Attempt{
+		Number:    proc.attempt,
+		Status:    status,
+		ExitCode:  exitCode,
+		LogFile:   proc.logPath,
+		LogSize:   proc.log.size,
+		StartedAt: proc.startedAt,
+		EndedAt:   time.Now(),
+	}
+	if !proc.cancelled {
+		attempt.KillReason = proc.killed
+	}
+	if attempt.StartedAt.IsZero() {
+		attempt.StartedAt = attempt.EndedAt
+	}
+	execution.Attempts = append(execution.Attempts, attempt)
+}
+
+// finishLocked updates and saves the final execution state. Caller must hold e.mu.
+func (e *Executor) finishLocked(execution *Execution, proc *runningProcess, err error) {
 	// Update execution record
 	execution.EndedAt = time.Now()
 	execution.Duration = execution.EndedAt.Sub(execution.StartedAt).String()
+	execution.Status, execution.ExitCode = processResult(proc, err)
+	if !proc.cancelled {
+		execution.KillReason = proc.killed
+	}
+	execution.IsolationResult = proc.isolation
+
+	e.endStepLocked(execution, proc, err, true)
+	e.recordAttemptLocked(execution, proc, err)
+	execution.LogSize = proc.log.size
+	execution.OutputBytes, execution.Truncated = proc.output.stats()
+	e.touchLocked(execution)
+
+	// Save final execution state, unless it was removed from history meanwhile
+	if _, ok := e.executions[execution.ID]; ok {
+		e.saveLocked()
+	}
+
+	e.prom.Finished(execution)
+	slog.Info("Execution finished", "execution_id", execution.ID, "command_id", execution.CommandID,
+		"status", execution.Status, "exit_code", execution.ExitCode, "duration", execution.EndedAt.Sub(execution.StartedAt))
+
+	// Evaluate alert rules outside the lock
+	go e.notifier.Notify(*execution)
+}
 
-	// Combine stdout and stderr
-	output := stdout.String()
-	if stderr.Len() > 0 {
-		if len(output) > 0 {
-			output += "\n"
+// CancelExecution stops a running execution. The process group receives
+// SIGTERM and is killed with SIGKILL if it is still alive after the grace period.
+// Queued executions are removed from the queue without running.
+func (e *Executor) CancelExecution(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	execution, ok := e.executions[id]
+	if !ok {
+		return ErrExecutionNotFound
+	}
+	if execution.Status == pendingApprovalStatus {
+		if execution.Approval != nil && execution.Approval.Decision != "" {
+			return ErrExecutionStopping
 		}
-		output += stderr.String()
+		execution.Status = "cancelled"
+		execution.EndedAt = time.Now()
+		e.touchLocked(execution)
+		e.saveLocked()
+		return nil
 	}
-	execution.Output = output
 
-	if err != nil {
-		execution.Status = "failed"
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			execution.ExitCode = exitErr.ExitCode()
-		} else {
-			execution.ExitCode = 1
-			execution.Output += fmt.Sprintf("\nError: %v", err)
+	if run := e.dequeueLocked(id); run != nil {
+		run.proc.cancelled = true
+		e.finishLocked(run.execution, run.proc, nil)
+		return nil
+	}
+
+	proc, ok := e.running[id]
+	if !ok {
+		return ErrExecutionNotRunning
+	}
+	if proc.stopping {
+		return ErrExecutionStopping
+	}
+
+	proc.cancelled = true
+	if proc.retryWait != nil {
+		// Waiting to retry: skip the remaining attempts
+		proc.stopping = true
+		close(proc.retryWait)
+		return nil
+	}
+	proc.terminate()
+
+	return nil
+}
+
+// startTimeouts arms the soft and hard timeouts of a running execution.
+// Caller must hold e.mu.
+func (e *Executor) startTimeouts(execution *Execution, proc *runningProcess, soft, hard time.Duration) {
+	if soft > 0 {
+		proc.timers = append(proc.timers, time.AfterFunc(soft, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			if _, ok := e.running[execution.ID]; !ok {
+				return
+			}
+			execution.SoftTimeoutExceeded = true
+			e.touchLocked(execution)
+			e.saveLocked()
+
+			// Alert rules can match slow executions with the "slow" status
+			slow := *execution
+			slow.Status = "slow"
+			go e.notifier.Notify(slow)
+		}))
+	}
+
+	if hard > 0 {
+		proc.timers = append(proc.timers, time.AfterFunc(hard, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			if _, ok := e.running[execution.ID]; !ok || proc.stopping {
+				return
+			}
+			proc.killed = fmt.Sprintf("hard timeout of %s exceeded", hard)
+			proc.terminate()
+		}))
+	}
+}
+
+// killForOutput kills an execution whose output exceeded the size cap
+func (e *Executor) killForOutput(execution *Execution, proc *runningProcess) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running[execution.ID] != proc || proc.stopping {
+		return
+	}
+	proc.killed = fmt.Sprintf("output limit of %d bytes exceeded", e.config.MaxOutputBytes)
+	proc.terminate()
+}
+
+// Shutdown stops starting executions and waits for running ones to finish.
+// If ctx expires first, the remaining executions are killed and marked
+// interrupted. Queued executions stay queued and are cancelled on the next
+// start.
+func (e *Executor) Shutdown(ctx context.Context) {
+	e.mu.Lock()
+	e.closing = true
+	// Runs waiting to retry give up instead of starting another attempt
+	for _, proc := range e.running {
+		if proc.retryWait != nil && !proc.stopping {
+			proc.stopping = true
+			close(proc.retryWait)
 		}
-	} else {
-		execution.Status = "success"
-		execution.ExitCode = 0
 	}
+	e.mu.Unlock()
 
-	// Save final execution state
-	e.executions[execution.ID] = execution
-	e.storage.SaveExecutions(e.executions)
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for id, proc := range e.running {
+		execution := e.executions[id]
+		proc.interrupted = true
+		if proc.killed == "" {
+			proc.killed = "server shut down before the execution finished"
+		}
+		proc.terminate()
+
+		// The process may outlive the server, so record the outcome now
+		execution.Status = "interrupted"
+		execution.ExitCode = -1
+		execution.KillReason = proc.killed
+		execution.EndedAt = time.Now()
+		execution.Duration = execution.EndedAt.Sub(execution.StartedAt).String()
+		execution.Steps = interruptSteps(execution.Steps)
+		e.touchLocked(execution)
+	}
+	e.saveLocked()
 }
 
 // GetExecution retrieves an execution by ID
 func (e *Executor) GetExecution(id string) (*Execution, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	exec, ok := e.executions[id]
-	return exec, ok
+	if !ok {
+		return nil, false
+	}
+	snapshot := *exec
+	return &snapshot, true
 }
 
-// GetAllExecutions returns all executions sorted by start time (newest first)
+// ReadOutput returns the output of an execution, read from its log files.
+// stream is "" for the combined output, or "stdout"/"stderr". If tail is
+// positive only the last tail lines are returned.
+func (e *Executor) ReadOutput(execution *Execution, stream string, tail int) (string, error) {
+	if execution.LogFile == "" {
+		// Records created before log files store combined output inline
+		if stream != "" {
+			return "", nil
+		}
+		return tailLines(execution.Output, tail), nil
+	}
+	return readLog(streamLogPath(execution.LogFile, stream), tail)
+}
+
+// ReadStepOutput returns the output of a step of an execution's latest
+// attempt, read from the combined log. If tail is positive only the last tail
+// lines are returned.
+func (e *Executor) ReadStepOutput(execution *Execution, step int, tail int) (string, error) {
+	result := execution.Steps[step]
+	size := result.LogSize
+	switch result.Status {
+	case "pending", "skipped":
+		return "", nil
+	case "running":
+		size = -1
+	}
+	output, err := readLogRange(execution.LogFile, result.LogOffset, size)
+	if err != nil {
+		return "", err
+	}
+	return tailLines(output, tail), nil
+}
+
+// ExecutionFilter selects a page of executions. Empty fields match everything.
+type ExecutionFilter struct {
+	Status     string
+	CommandID  string
+	ExecutedBy string
+	Tag        string
+	From       time.Time  // Only executions started at or after this time, if set
+	To         time.Time  // Only executions started at or before this time, if set
+	Since      int64      // Only executions changed after this revision
+	After      *Execution // Only executions older than this position, see decodePageCursor
+	Limit      int
+	Offset     int
+}
+
+// GetAllExecutions returns all executions, newest first
 func (e *Executor) GetAllExecutions() []*Execution {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	execList := make([]*Execution, 0, len(e.executions))
 	for _, exec := range e.executions {
-		execList = append(execList, exec)
+		snapshot := *exec
+		execList = append(execList, &snapshot)
 	}
 
-	// Sort by started time (newest first)
-	for i := 0; i < len(execList)-1; i++ {
-		for j := i + 1; j < len(execList); j++ {
-			if execList[i].StartedAt.Before(execList[j].StartedAt) {
-				execList[i], execList[j] = execList[j], execList[i]
-			}
+	sort.Slice(execList, func(i, j int) bool {
+		return newerExecution(execList[i], execList[j])
+	})
+
+	return execList
+}
+
+// newerExecution reports whether a was started after b. Sequence numbers give
+// a stable order; records from before they existed fall back to start time,
+// and the ID breaks any remaining tie so the order is total.
+func newerExecution(a, b *Execution) bool {
+	if a.Seq != 0 && b.Seq != 0 && a.Seq != b.Seq {
+		return a.Seq > b.Seq
+	}
+	if !a.StartedAt.Equal(b.StartedAt) {
+		return a.StartedAt.After(b.StartedAt)
+	}
+	if a.Seq != b.Seq {
+		return a.Seq > b.Seq
+	}
+	return a.ID > b.ID
+}
+
+// ListExecutions returns the page of executions matching filter, newest
+// first, along with the total number of matches. With filter.After the total
+// only counts the matches after that position.
+func (e *Executor) ListExecutions(filter ExecutionFilter) ([]*Execution, int) {
+	matched := make([]*Execution, 0)
+	for _, exec := range e.GetAllExecutions() {
+		if filter.Status != "" && exec.Status != filter.Status {
+			continue
+		}
+		if filter.CommandID != "" && exec.CommandID != filter.CommandID {
+			continue
+		}
+		if filter.ExecutedBy != "" && exec.ExecutedBy != filter.ExecutedBy {
+			continue
+		}
+		if filter.Tag != "" && !containsString(exec.Tags, filter.Tag) {
+			continue
 		}
+		if !filter.From.IsZero() && exec.StartedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && exec.StartedAt.After(filter.To) {
+			continue
+		}
+		if exec.Rev <= filter.Since {
+			continue
+		}
+		if filter.After != nil && !newerExecution(filter.After, exec) {
+			continue
+		}
+		matched = append(matched, exec)
 	}
 
-	return execList
+	total := len(matched)
+	if filter.Offset >= total {
+		return []*Execution{}, total
+	}
+	end := total
+	if filter.Limit > 0 && filter.Offset+filter.Limit < total {
+		end = filter.Offset + filter.Limit
+	}
+	return matched[filter.Offset:end], total
+}
+
+// RunningCount returns the number of running executions
+func (e *Executor) RunningCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return len(e.running)
+}
+
+// ActiveExecutions returns snapshots of the running executions, oldest
+// first, and of the queued ones in the order they will start. It only looks
+// at the executions holding or waiting for a slot, not the whole history.
+func (e *Executor) ActiveExecutions() (running, queued []*Execution) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	running = make([]*Execution, 0, len(e.running))
+	for id := range e.running {
+		if execution, ok := e.executions[id]; ok {
+			snapshot := *execution
+			running = append(running, &snapshot)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return newerExecution(running[j], running[i])
+	})
+
+	queued = make([]*Execution, 0, len(e.queue))
+	for _, run := range e.queue {
+		snapshot := *run.execution
+		queued = append(queued, &snapshot)
+	}
+	return running, queued
+}
+
+// QueueDepth returns the number of executions waiting for a free slot
+func (e *Executor) QueueDepth() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return len(e.queue)
+}
+
+// IsCommandRunning reports whether an execution of a saved command is running
+// or queued
+func (e *Executor) IsCommandRunning(commandID string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.commandActiveLocked(commandID)
+}
+
+// commandActiveLocked reports whether an execution of a saved command holds
+// or waits for a slot. Caller must hold e.mu.
+func (e *Executor) commandActiveLocked(commandID string) bool {
+	for id := range e.running {
+		if execution, ok := e.executions[id]; ok && execution.CommandID == commandID {
+			return true
+		}
+	}
+	for _, run := range e.queue {
+		if run.execution.CommandID == commandID {
+			return true
+		}
+	}
+	return false
+}
+
+// LastFinished returns the latest successful or failed execution of a saved
+// command. Cancelled and rejected executions are ignored.
+func (e *Executor) LastFinished(commandID string) (*Execution, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var last *Execution
+	for _, exec := range e.executions {
+		if exec.CommandID != commandID || (exec.Status != "success" && exec.Status != "failed") {
+			continue
+		}
+		if last == nil || newerExecution(exec, last) {
+			last = exec
+		}
+	}
+	if last == nil {
+		return nil, false
+	}
+	snapshot := *last
+	return &snapshot, true
+}
+
+// LastRun returns a summary of the latest execution of a saved command,
+// running or finished. Rejected attempts are not counted.
+func (e *Executor) LastRun(commandID string) *ExecutionSummary {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	exec, ok := e.lastRuns[commandID]
+	if !ok {
+		return nil
+	}
+	summary := &ExecutionSummary{
+		ID:        exec.ID,
+		Seq:       exec.Seq,
+		Status:    exec.Status,
+		ExitCode:  exec.ExitCode,
+		StartedAt: exec.StartedAt,
+		Duration:  exec.Duration,
+	}
+	if !exec.EndedAt.IsZero() {
+		endedAt := exec.EndedAt
+		summary.EndedAt = &endedAt
+	}
+	return summary
+}
+
+// indexLastRunLocked records exec as its command's latest run if it is newer.
+// Caller must hold e.mu.
+func (e *Executor) indexLastRunLocked(exec *Execution) {
+	if exec.CommandID == "" || exec.Status == "rejected" {
+		return
+	}
+	if last, ok := e.lastRuns[exec.CommandID]; !ok || newerExecution(exec, last) {
+		e.lastRuns[exec.CommandID] = exec
+	}
+}
+
+// unindexLastRunLocked finds the next latest run of a removed execution's
+// command. Caller must hold e.mu.
+func (e *Executor) unindexLastRunLocked(removed *Execution) {
+	if e.lastRuns[removed.CommandID] != removed {
+		return
+	}
+	delete(e.lastRuns, removed.CommandID)
+	for _, exec := range e.executions {
+		if exec.CommandID == removed.CommandID {
+			e.indexLastRunLocked(exec)
+		}
+	}
 }
 
 // GetRecentExecutions returns the N most recent executions
@@ -133,27 +1488,259 @@ func (e *Executor) GetRecentExecutions(limit int) []*Execution {
 
 // DeleteExecution removes an execution from history
 func (e *Executor) DeleteExecution(id string) bool {
-	if _, ok := e.executions[id]; !ok {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	execution, ok := e.executions[id]
+	if !ok {
 		return false
 	}
-	delete(e.executions, id)
-	e.storage.SaveExecutions(e.executions)
+	e.deleteLocked(execution)
+	e.saveLocked()
 	return true
 }
 
+// DeleteExecutions deletes the executions with the given IDs, saving the
+// history once. It returns the IDs that were deleted and those not found.
+func (e *Executor) DeleteExecutions(ids []string) (deleted, missing []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, id := range ids {
+		execution, ok := e.executions[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		e.deleteLocked(execution)
+		deleted = append(deleted, id)
+	}
+	if len(deleted) > 0 {
+		e.saveLocked()
+	}
+	return deleted, missing
+}
+
+// DeleteMatchingExecutions deletes finished executions with the given status
+// (any if empty) that ended more than olderThan ago (any if zero), returning
+// their IDs. Queued and running executions are never deleted.
+func (e *Executor) DeleteMatchingExecutions(status string, olderThan time.Duration) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	var deleted []string
+	for _, execution := range e.executions {
+		if execution.Status == "queued" || execution.Status == "running" || execution.Status == pendingApprovalStatus {
+			continue
+		}
+		if status != "" && execution.Status != status {
+			continue
+		}
+		ended := execution.EndedAt
+		if ended.IsZero() {
+			ended = execution.StartedAt
+		}
+		if olderThan > 0 && now.Sub(ended) <= olderThan {
+			continue
+		}
+		e.deleteLocked(execution)
+		deleted = append(deleted, execution.ID)
+	}
+	if len(deleted) > 0 {
+		e.saveLocked()
+	}
+	return deleted
+}
+
+// deleteLocked removes an execution from the queue and history and deletes
+// its log files. Caller must hold e.mu and save the history.
+func (e *Executor) deleteLocked(execution *Execution) {
+	if run := e.dequeueLocked(execution.ID); run != nil {
+		run.proc.closeLogs()
+	}
+	removeExecutionLogs(execution)
+	delete(e.executions, execution.ID)
+	e.unindexLastRunLocked(execution)
+	e.version++
+}
+
 // ClearExecutions removes all execution history
 func (e *Executor) ClearExecutions() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, run := range e.queue {
+		run.proc.closeLogs()
+	}
+	e.queue = nil
+	for _, execution := range e.executions {
+		removeExecutionLogs(execution)
+	}
 	e.executions = make(map[string]*Execution)
-	e.storage.SaveExecutions(e.executions)
+	e.lastRuns = make(map[string]*Execution)
+	e.version++
+	e.saveLocked()
+}
+
+// ValidateEnv checks that all environment variable names are valid
+func ValidateEnv(env map[string]string) error {
+	for key := range env {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid environment variable name: %q", key)
+		}
+	}
+	return nil
+}
+
+// ValidateLabels checks that all execution label names are valid and map to
+// distinct environment variables
+func ValidateLabels(labels map[string]string) error {
+	names := make(map[string]string, len(labels))
+	for key := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label name: %q", key)
+		}
+		name := labelEnvName(key)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("labels %q and %q both map to %s", other, key, name)
+		}
+		names[name] = key
+	}
+	return nil
 }
 
-// ValidateCommand checks if a command is valid
-func ValidateCommand(workdir, command string) error {
+// ValidateTags checks the tags of an ad-hoc execution
+func ValidateTags(tags []string, limits FieldLimits) error {
+	if err := limits.checkTags(tags); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("tags cannot be empty")
+		}
+	}
+	return nil
+}
+
+// labelEnvName returns the environment variable a label is passed as, e.g.
+// "release" becomes DEPLOYAR_LABEL_RELEASE and "build.id" DEPLOYAR_LABEL_BUILD_ID
+func labelEnvName(key string) string {
+	name := strings.ToUpper(key)
+	name = strings.NewReplacer(".", "_", "-", "_").Replace(name)
+	return labelEnvPrefix + name
+}
+
+// redactEnv returns a copy of env with every value replaced
+func redactEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for key := range env {
+		redacted[key] = redactedValue
+	}
+	return redacted
+}
+
+// secretValues returns the environment values to mask in command output:
+// every supplied value long enough to mask safely, longest first. Names are
+// not a reliable hint, e.g. DATABASE_URL usually carries a password.
+func secretValues(env map[string]string) []string {
+	var secrets []string
+	for _, value := range env {
+		if len(value) >= minSecretLength {
+			secrets = append(secrets, value)
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return secrets
+}
+
+// redactSecrets masks any occurrence of a secret value in text
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// ValidateCommand checks if a command is valid and within limits, and its
+// workdir falls under one of allowedWorkdirs. An empty list allows any
+// workdir.
+func ValidateCommand(workdir, command string, allowedWorkdirs []string, limits FieldLimits, rules *CommandRules) error {
 	if strings.TrimSpace(command) == "" {
 		return fmt.Errorf("command cannot be empty")
 	}
 	if strings.TrimSpace(workdir) == "" {
 		return fmt.Errorf("workdir cannot be empty")
 	}
+	if err := checkSize("command", command, limits.Command); err != nil {
+		return err
+	}
+	if err := checkSize("workdir", workdir, limits.Workdir); err != nil {
+		return err
+	}
+	if err := rules.Check(command); err != nil {
+		return err
+	}
+	return checkWorkdirAllowed(workdir, allowedWorkdirs)
+}
+
+// ValidateWorkdirExists checks that workdir is an existing directory, so a
+// run that cannot start is rejected instead of recorded as failed. Saved
+// commands are not checked, their workdir may be created later.
+func ValidateWorkdirExists(workdir string) error {
+	info, err := os.Stat(workdir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("workdir does not exist or is not a directory: %s", workdir)
+	}
 	return nil
 }
+
+// checkWorkdirAllowed resolves workdir to an absolute path without symlinks
+// and checks that it is one of the allowed roots or below one
+func checkWorkdirAllowed(workdir string, allowedWorkdirs []string) error {
+	if len(allowedWorkdirs) == 0 {
+		return nil
+	}
+
+	resolved, err := resolvePath(workdir)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %q: %v", ErrWorkdirNotAllowed, workdir, err)
+	}
+	for _, root := range allowedWorkdirs {
+		root, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is outside the allowed directories (%s)", ErrWorkdirNotAllowed, resolved, strings.Join(allowedWorkdirs, ", "))
+}
+
+// resolvePath returns the absolute path with symlinks resolved. Paths that do
+// not exist yet are resolved as far as possible.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		parent, base := filepath.Split(abs)
+		if parent == abs || base == "" {
+			return abs, nil
+		}
+		resolvedParent, err := resolvePath(filepath.Clean(parent))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(resolvedParent, base), nil
+	}
+	return resolved, err
+}
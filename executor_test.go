@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestExecutor returns an executor storing its data in a temporary
+// directory, running at most maxConcurrent executions at once
+func newTestExecutor(t *testing.T, maxConcurrent int) *Executor {
+	t.Helper()
+	dir := t.TempDir()
+	notifier, err := LoadNotifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExecutor(NewJSONStore(dir, AuditRotation{}), &Config{DataDir: dir, MaxConcurrent: maxConcurrent}, notifier)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		e.Shutdown(ctx)
+	})
+	return e
+}
+
+// waitFinished waits until the execution id is neither queued nor running
+func waitFinished(t *testing.T, e *Executor, id string) *Execution {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		execution, ok := e.GetExecution(id)
+		if !ok {
+			t.Fatalf("execution %s disappeared", id)
+		}
+		if execution.Status != "queued" && execution.Status != "running" {
+			return execution
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("execution %s did not finish", id)
+	return nil
+}
+
+func TestExecutorFinalStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		hardTimeout  time.Duration
+		maxRetries   int
+		wantStatus   string
+		wantExitCode int
+		wantAttempt  int    // 0 for executions without retries
+		wantKill     string // Substring of the kill reason
+	}{
+		{name: "success", command: "true", wantStatus: "success"},
+		{name: "failure", command: "exit 3", wantStatus: "failed", wantExitCode: 3},
+		{name: "hard timeout", command: "sleep 5", hardTimeout: 200 * time.Millisecond, wantStatus: "failed", wantExitCode: -1, wantKill: "hard timeout"},
+		{name: "retried until success", command: "test -f marker || { touch marker; exit 1; }", maxRetries: 2, wantStatus: "success", wantAttempt: 2},
+		{name: "retries exhausted", command: "exit 2", maxRetries: 2, wantStatus: "failed", wantExitCode: 2, wantAttempt: 3},
+		{name: "timeout is retried", command: "sleep 5", hardTimeout: 100 * time.Millisecond, maxRetries: 1, wantStatus: "failed", wantExitCode: -1, wantAttempt: 2, wantKill: "hard timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExecutor(t, 0)
+			started, err := e.Execute(ExecuteParams{
+				Workdir:     t.TempDir(),
+				Command:     tt.command,
+				Username:    "alice",
+				HardTimeout: tt.hardTimeout,
+				MaxRetries:  tt.maxRetries,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			execution := waitFinished(t, e, started.ID)
+			if execution.Status != tt.wantStatus || execution.ExitCode != tt.wantExitCode {
+				t.Errorf("finished as %s with exit code %d, want %s with %d", execution.Status, execution.ExitCode, tt.wantStatus, tt.wantExitCode)
+			}
+			if execution.Attempt != tt.wantAttempt {
+				t.Errorf("attempt = %d, want %d", execution.Attempt, tt.wantAttempt)
+			}
+			if len(execution.Attempts) != tt.wantAttempt {
+				t.Errorf("%d attempts recorded, want %d", len(execution.Attempts), tt.wantAttempt)
+			}
+			if !strings.Contains(execution.KillReason, tt.wantKill) || (tt.wantKill == "" && execution.KillReason != "") {
+				t.Errorf("kill reason = %q, want %q", execution.KillReason, tt.wantKill)
+			}
+		})
+	}
+}
+
+func TestExecutorQueue(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	workdir := t.TempDir()
+
+	first, err := e.Execute(ExecuteParams{Workdir: workdir, Command: "sleep 0.3", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := e.Execute(ExecuteParams{Workdir: workdir, Command: "true", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Status != "running" {
+		t.Errorf("first execution is %s, want running", first.Status)
+	}
+	if second.Status != "queued" || second.QueuedAt == nil {
+		t.Errorf("second execution is %s, want queued", second.Status)
+	}
+	if depth := e.QueueDepth(); depth != 1 {
+		t.Errorf("queue depth = %d, want 1", depth)
+	}
+
+	firstDone := waitFinished(t, e, first.ID)
+	secondDone := waitFinished(t, e, second.ID)
+	if firstDone.Status != "success" || secondDone.Status != "success" {
+		t.Fatalf("statuses = %s, %s, want success", firstDone.Status, secondDone.Status)
+	}
+	if secondDone.StartedAt.Before(firstDone.EndedAt) {
+		t.Error("queued execution started before the slot was free")
+	}
+	if depth := e.QueueDepth(); depth != 0 {
+		t.Errorf("queue depth = %d after finishing, want 0", depth)
+	}
+}
+
+func TestExecutorCancel(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, e *Executor) string // Returns the execution to cancel
+		wantErr    error
+		wantStatus string
+	}{
+		{
+			name: "running",
+			setup: func(t *testing.T, e *Executor) string {
+				return mustExecute(t, e, ExecuteParams{Command: "sleep 5"}).ID
+			},
+			wantStatus: "cancelled",
+		},
+		{
+			name: "queued",
+			setup: func(t *testing.T, e *Executor) string {
+				mustExecute(t, e, ExecuteParams{Command: "sleep 0.5"})
+				return mustExecute(t, e, ExecuteParams{Command: "true"}).ID
+			},
+			wantStatus: "cancelled",
+		},
+		{
+			name: "waiting to retry",
+			setup: func(t *testing.T, e *Executor) string {
+				execution := mustExecute(t, e, ExecuteParams{Command: "exit 1", MaxRetries: 3, RetryDelay: time.Minute})
+				// Let the first attempt fail
+				deadline := time.Now().Add(5 * time.Second)
+				for time.Now().Before(deadline) {
+					if current, _ := e.GetExecution(execution.ID); len(current.Attempts) > 0 {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				return execution.ID
+			},
+			wantStatus: "cancelled",
+		},
+		{
+			name: "finished",
+			setup: func(t *testing.T, e *Executor) string {
+				execution := mustExecute(t, e, ExecuteParams{Command: "true"})
+				waitFinished(t, e, execution.ID)
+				return execution.ID
+			},
+			wantErr:    ErrExecutionNotRunning,
+			wantStatus: "success",
+		},
+		{
+			name:    "unknown",
+			setup:   func(t *testing.T, e *Executor) string { return "missing" },
+			wantErr: ErrExecutionNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExecutor(t, 1)
+			id := tt.setup(t, e)
+
+			if err := e.CancelExecution(id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CancelExecution() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			if execution := waitFinished(t, e, id); execution.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", execution.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// mustExecute starts params in a temporary workdir as alice
+func mustExecute(t *testing.T, e *Executor, params ExecuteParams) *Execution {
+	t.Helper()
+	params.Workdir = t.TempDir()
+	params.Username = "alice"
+	execution, err := e.Execute(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return execution
+}
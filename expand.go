@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// serverWorkdirVars are the variables of the server's own environment
+// available to workdir expansion, besides the command's environment
+var serverWorkdirVars = []string{"HOME", "USER"}
+
+// hasWorkdirVariables reports whether workdir has anything to expand
+func hasWorkdirVariables(workdir string) bool {
+	return strings.HasPrefix(workdir, "~") || strings.Contains(workdir, "$")
+}
+
+// workdirResolvedLater reports whether the workdir of cmd is only known when
+// it runs, after parameter substitution or variable expansion
+func (c *Command) workdirResolvedLater() bool {
+	return strings.Contains(c.Workdir, "{{") || (c.ExpandWorkdir && hasWorkdirVariables(c.Workdir))
+}
+
+// expandWorkdir expands a leading ~ to the home directory of the server's
+// user, and $VAR and ${VAR} to variables of env or, failing that, to HOME
+// and USER of the server. Undefined variables are an error rather than
+// expanding to nothing, and secret-looking variables are refused since the
+// workdir is stored in the execution record.
+func expandWorkdir(workdir string, env map[string]string) (string, error) {
+	if workdir == "~" || strings.HasPrefix(workdir, "~/") {
+		workdir = "${HOME}" + workdir[1:]
+	} else if strings.HasPrefix(workdir, "~") {
+		return "", fmt.Errorf("workdir: only ~ and ~/ are expanded, not %q", strings.SplitN(workdir, "/", 2)[0])
+	}
+
+	var expandErr error
+	expanded := os.Expand(workdir, func(name string) string {
+		if expandErr != nil {
+			return ""
+		}
+		if secretKeyPattern.MatchString(name) {
+			expandErr = fmt.Errorf("workdir: secret variable %s cannot be expanded", name)
+			return ""
+		}
+		if value, ok := env[name]; ok {
+			return value
+		}
+		if value := serverWorkdirVar(name); value != "" {
+			return value
+		}
+		expandErr = fmt.Errorf("workdir: variable %s is not defined", name)
+		return ""
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// serverWorkdirVar returns a variable of the server's environment available
+// to workdir expansion, or "" if it is not available or not set
+func serverWorkdirVar(name string) string {
+	if !containsString(serverWorkdirVars, name) {
+		return ""
+	}
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	// Services often run without HOME or USER set
+	current, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	if name == "HOME" {
+		return current.HomeDir
+	}
+	return current.Username
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes, so
+// large exports reach the client while they are being written
+const exportFlushRows = 500
+
+// exportColumns are the columns of the CSV execution export
+var exportColumns = []string{"id", "name", "command", "status", "exit_code", "executed_by", "started_at", "duration"}
+
+// ExportExecutionsHandler handles GET /api/executions/export?format=csv,
+// downloading the executions matching the list filters (status,
+// command_id, executed_by, tag, from, to) newest first
+func (app *App) ExportExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "csv" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "format must be csv"})
+		return
+	}
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	executions, _ := app.executor.ListExecutions(ExecutionFilter{
+		Status:     query.Get("status"),
+		CommandID:  query.Get("command_id"),
+		ExecutedBy: query.Get("executed_by"),
+		Tag:        query.Get("tag"),
+		From:       from,
+		To:         to,
+	})
+
+	filename := "deployar-executions-" + time.Now().UTC().Format("20060102-150405") + ".csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	flusher, _ := w.(http.Flusher)
+	out := csv.NewWriter(w)
+	out.Write(exportColumns)
+	for i, exec := range executions {
+		if err := out.Write(exportRow(exec)); err != nil {
+			// The client went away
+			return
+		}
+		if (i+1)%exportFlushRows == 0 {
+			out.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	out.Flush()
+}
+
+// exportRow returns the CSV columns of an execution. The duration is in
+// seconds and empty until the execution finished.
+func exportRow(exec *Execution) []string {
+	duration := ""
+	if !exec.EndedAt.IsZero() {
+		duration = strconv.FormatFloat(exec.EndedAt.Sub(exec.StartedAt).Seconds(), 'f', 3, 64)
+	}
+	return []string{
+		exec.ID,
+		spreadsheetSafe(exec.Name),
+		spreadsheetSafe(exec.Command),
+		exec.Status,
+		strconv.Itoa(exec.ExitCode),
+		spreadsheetSafe(exec.ExecutedBy),
+		exec.StartedAt.UTC().Format(time.RFC3339),
+		duration,
+	}
+}
+
+// spreadsheetSafe keeps a user-controlled cell from being read as a formula
+// when the CSV is opened in a spreadsheet
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// isFavorite reports whether username pinned the command id
+func (app *App) isFavorite(username, id string) bool {
+	app.usersMu.RLock()
+	defer app.usersMu.RUnlock()
+	user, exists := app.users[username]
+	return exists && containsString(user.Favorites, id)
+}
+
+// setFavorite pins or unpins the command id for username, saving the users
+// if that changed anything. It returns false if the user does not exist.
+func (app *App) setFavorite(username, id string, pinned bool) (bool, error) {
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+
+	user, exists := app.users[username]
+	if !exists {
+		return false, nil
+	}
+	if containsString(user.Favorites, id) == pinned {
+		return true, nil
+	}
+
+	previous := user.Favorites
+	favorites := make([]string, 0, len(previous)+1)
+	for _, favorite := range previous {
+		if favorite != id {
+			favorites = append(favorites, favorite)
+		}
+	}
+	if pinned {
+		favorites = append(favorites, id)
+	}
+	user.Favorites = favorites
+	app.commandsChanged()
+	if err := app.storage.SaveUsers(app.users); err != nil {
+		user.Favorites = previous
+		return true, err
+	}
+	return true, nil
+}
+
+// FavoriteCommandHandler handles POST /api/commands/:id/favorite, pinning a
+// command for the requesting user
+func (app *App) FavoriteCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	cmd, ok := app.commands[mux.Vars(r)["id"]]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+	username := currentUsername(r)
+	exists, err := app.setFavorite(username, cmd.ID, true)
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save favorite"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, app.commandResponse(cmd, username))
+}
+
+// UnfavoriteCommandHandler handles DELETE /api/commands/:id/favorite. Pins of
+// commands that were since purged can still be removed.
+func (app *App) UnfavoriteCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	id := mux.Vars(r)["id"]
+	username := currentUsername(r)
+	if !app.userExists(username) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	cmd, ok := app.commands[id]
+	if !ok && !app.isFavorite(username, id) {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	exists, err := app.setFavorite(username, id, false)
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save favorite"})
+		return
+	}
+
+	if !ok {
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Favorite removed"})
+		return
+	}
+	respondJSON(w, http.StatusOK, app.commandResponse(cmd, username))
+}
@@ -1,89 +1,284 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// defaultExecutionsLimit is the page size when ?limit= is not given
+	defaultExecutionsLimit = 50
+	// defaultListLimit is the page size of the commands and users lists
+	// when only an offset is given
+	defaultListLimit = 100
+	// maxExecutionsWait caps how long a long-poll of the executions list waits
+	maxExecutionsWait = 60 * time.Second
+	// maxRetries caps how often a failed execution of a command is retried
+	maxRetries = 10
+)
+
 // App holds application dependencies
 type App struct {
-	storage  *Storage
-	executor *Executor
-	commands map[string]*Command
-	users    map[string]*User
+	config    *Config
+	startedAt time.Time
+	storage   Store
+	executor  *Executor
+	mu        sync.RWMutex // Guards commands and presets, which the scheduler also reads
+	commands  map[string]*Command
+	usersMu   sync.RWMutex // Guards users, which every authenticated request reads
+	users     map[string]*User
+	presets   map[string]*EnvPreset
+	sessions  *SessionStore
+	tokens    *TokenStore
+
+	loginLimiter *LoginLimiter
+	execLimiter  *ExecutionLimiter
+	reqLimiter   *RequestLimiter
+	idempotency  *IdempotencyStore
+	scheduler    *Scheduler
+	janitor      *Janitor
+	verifier     *RequestVerifier // Nil unless request signing is enabled
+	wsClosing    chan struct{}    // Closed on shutdown to end WebSocket connections
+	wsConns      sync.WaitGroup   // Running WebSocket handlers
+	loadErr      error            // First error loading stored data at startup
+
+	commandsVersion atomic.Int64 // Bumped on every change to the commands list, see commandsChanged
 }
 
 // NewApp creates a new application instance
-func NewApp() *App {
-	storage := NewStorage()
-	executor := NewExecutor(storage)
+func NewApp(config *Config, storage Store, notifier *Notifier) *App {
+	executor := NewExecutor(storage, config, notifier)
+	var loadErr error
+	if executor.loadErr != nil {
+		loadErr = fmt.Errorf("failed to load executions: %w", executor.loadErr)
+	}
 
 	commands, err := storage.LoadCommands()
 	if err != nil {
 		commands = make(map[string]*Command)
+		loadErr = errors.Join(loadErr, fmt.Errorf("failed to load commands: %w", err))
 	}
 
 	users, err := storage.LoadUsers()
 	if err != nil {
 		users = make(map[string]*User)
+		loadErr = errors.Join(loadErr, fmt.Errorf("failed to load users: %w", err))
+	} else if changed, err := hashPlainPasswords(users); err != nil {
+		loadErr = errors.Join(loadErr, fmt.Errorf("failed to hash passwords: %w", err))
+	} else if changed {
+		if err := storage.SaveUsers(users); err != nil {
+			loadErr = errors.Join(loadErr, fmt.Errorf("failed to save hashed passwords: %w", err))
+		} else {
+			slog.Info("Hashed passwords stored in plain text")
+		}
+	}
+	if err == nil {
+		// Earlier versions made every user an admin when DEPLOYAR_ADMIN_USERS was empty
+		if admin := promoteFirstAdmin(users, config.AdminUsers); admin != nil {
+			if err := storage.SaveUsers(users); err != nil {
+				loadErr = errors.Join(loadErr, fmt.Errorf("failed to save admin user: %w", err))
+			} else {
+				slog.Warn("No admin configured, made the first user an admin", "user", admin.Username)
+			}
+		}
+	}
+
+	presets, err := storage.LoadPresets()
+	if err != nil {
+		presets = make(map[string]*EnvPreset)
+		loadErr = errors.Join(loadErr, fmt.Errorf("failed to load environment presets: %w", err))
+	}
+
+	tokens, err := storage.LoadTokens()
+	if err != nil {
+		tokens = make(map[string]*APIToken)
+		loadErr = errors.Join(loadErr, fmt.Errorf("failed to load API tokens: %w", err))
+	}
+
+	app := &App{
+		config:    config,
+		startedAt: time.Now(),
+		storage:   storage,
+		executor:  executor,
+		commands:  commands,
+		users:     users,
+		presets:   presets,
+		sessions:  NewSessionStore(config.SessionTTL),
+		tokens:    NewTokenStore(storage, tokens),
+		loadErr:   loadErr,
+
+		loginLimiter: NewLoginLimiter(config.LoginMaxAttempts, config.LoginWindow, config.LoginLockout, config.ClientIPHeader),
+		execLimiter:  NewExecutionLimiter(config.ExecutionRateLimit, config.ExecutionRateWindow),
+		reqLimiter:   NewRequestLimiter(config.ExecuteRequestRate, config.ExecuteRequestBurst),
+		idempotency:  NewIdempotencyStore(config.IdempotencyTTL),
+		wsClosing:    make(chan struct{}),
 	}
 
-	return &App{
-		storage:  storage,
-		executor: executor,
-		commands: commands,
-		users:    users,
+	if config.RequestSigning {
+		app.verifier = NewRequestVerifier(config.RequestSigningMaxSkew)
 	}
+
+	// Reload schedules of saved commands
+	app.scheduler = NewScheduler(app.runScheduledCommand)
+	for _, cmd := range commands {
+		if err := app.scheduler.Set(cmd.ID, cmd.activeSchedule()); err != nil {
+			slog.Warn("Invalid command schedule", "command", cmd.Name, "schedule", cmd.Schedule, "error", err)
+		}
+	}
+	app.scheduler.Start()
+
+	app.janitor = NewJanitor(executor, config)
+	app.janitor.Start()
+
+	return app
 }
 
 // ExecuteHandler handles POST /api/execute
 func (app *App) ExecuteHandler(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey, ok := app.beginIdempotentExecute(w, r)
+	if !ok {
+		return
+	}
+	defer app.idempotency.Release(idempotencyKey)
+	if !app.throttleExecuteRequest(w, r) {
+		return
+	}
+
 	var req ExecuteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	if err := ValidateCommand(req.Workdir, req.Command); err != nil {
+	username := currentUsername(r)
+
+	params := ExecuteParams{
+		Workdir:  req.Workdir,
+		Command:  req.Command,
+		Shell:    req.Shell,
+		Args:     req.Args,
+		Labels:   req.Labels,
+		Tags:     req.Tags,
+		Steps:    req.Steps,
+		Username: username,
+
+		Stdin:     req.Stdin,
+		StdinFile: req.StdinFile,
+
+		IsolateWorkdir: req.IsolateWorkdir,
+		SyncBack:       req.SyncBack,
+	}
+	if len(req.Steps) > 0 {
+		if req.Command != "" {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "command and steps cannot be combined"})
+			return
+		}
+		params.Command = stepsCommand(req.Steps)
+	}
+
+	if err := ValidateCommand(req.Workdir, params.Command, app.config.AllowedWorkdirs, app.config.Limits, app.config.CommandRules); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := ValidateSteps(req.Workdir, req.Shell, req.Args, req.Steps, app.config.AllowedWorkdirs); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := ValidateStdin(req.Workdir, req.Stdin, req.StdinFile, app.config.AllowedWorkdirs); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := ValidateWorkdirExists(req.Workdir); err != nil {
+		app.executor.RecordRejected(params, err.Error())
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
+	if err := ValidateStepWorkdirs(req.Workdir, req.Steps); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if len(req.Steps) == 0 {
+		if err := ValidateShell(req.Shell, req.Command, req.Args); err != nil {
+			app.executor.RecordRejected(params, err.Error())
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	if err := ValidateEnv(req.Env); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := ValidateLabels(req.Labels); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := ValidateTags(req.Tags, app.config.Limits); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := app.overrideTimeout(&params, req.TimeoutOverride); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	app.mu.RLock()
+	env, err := app.resolveEnvLocked(req.EnvPreset, req.Env)
+	app.mu.RUnlock()
+	if err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	params.Env = env
+	if !app.allowExecution(w, params) {
+		return
+	}
 
-	// Get username from auth header
-	username, _, _ := parseBasicAuth(r.Header.Get("Authorization"))
-
-	execution, err := app.executor.Execute(req.Workdir, req.Command, "", "", username)
+	execution, err := app.executor.Execute(params)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
+	app.idempotency.Complete(idempotencyKey, execution.ID)
 
-	respondJSON(w, http.StatusOK, ExecuteResponse{
-		ExecutionID: execution.ID,
-		Status:      execution.Status,
-		Message:     "Command execution started",
-	})
+	respondJSON(w, http.StatusOK, app.executeResponse(execution))
 }
 
 // CreateCommandHandler handles POST /api/commands
 func (app *App) CreateCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	var cmd Command
-	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &cmd) {
 		return
 	}
 
 	// Validate
-	if cmd.Name == "" {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Command name is required"})
-		return
-	}
-	if err := ValidateCommand(cmd.Workdir, cmd.Command); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	errs := app.commandInputErrors(&cmd)
+	errs.Add("env_preset", app.validatePresetRefLocked(cmd.EnvPreset))
+	if err := errs.Err(); err != nil {
+		respondValidationError(w, err)
 		return
 	}
 
@@ -91,29 +286,116 @@ func (app *App) CreateCommandHandler(w http.ResponseWriter, r *http.Request) {
 	cmd.ID = uuid.New().String()
 	cmd.CreatedAt = time.Now()
 	cmd.UpdatedAt = time.Now()
+	cmd.ArchivedAt = nil
+	cmd.Revisions = nil
 
 	// Save
 	app.commands[cmd.ID] = &cmd
+	app.commandsChanged()
 	if err := app.storage.SaveCommands(app.commands); err != nil {
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save command"})
 		return
 	}
+	app.scheduler.Set(cmd.ID, cmd.Schedule)
 
-	respondJSON(w, http.StatusCreated, cmd)
+	respondJSON(w, http.StatusCreated, SaveCommandResponse{Command: &cmd, Warnings: commandWarnings(&cmd)})
+}
+
+// ValidateCommandHandler handles POST /api/commands/validate
+//
+// Accepts the same body as CreateCommandHandler and runs the same checks,
+// including the workdir allowlist and command rules, without saving
+// anything. Invalid commands are reported with 200 and valid false.
+func (app *App) ValidateCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var cmd Command
+	if !decodeJSON(w, r, &cmd) {
+		return
+	}
+
+	errs := app.commandInputErrors(&cmd)
+	errs.Add("env_preset", app.validatePresetRefLocked(cmd.EnvPreset))
+
+	response := ValidateCommandResponse{
+		Valid:    len(errs) == 0,
+		Fields:   []FieldError{},
+		Warnings: commandWarnings(&cmd),
+	}
+	if err := errs.Err(); err != nil {
+		response.Error = err.Error()
+		response.Fields = errs
+	}
+	respondJSON(w, http.StatusOK, response)
 }
 
 // ListCommandsHandler handles GET /api/commands
+//
+// Supports ?q= to search name, description, command and tags, and ?tag= for
+// an exact tag. Results are sorted by relevance, then name. An If-None-Match
+// with the ETag of an unchanged list is answered with 304.
 func (app *App) ListCommandsHandler(w http.ResponseWriter, r *http.Request) {
-	commands := make([]*Command, 0, len(app.commands))
-	for _, cmd := range app.commands {
-		commands = append(commands, cmd)
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	limit, offset, paged, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	username := currentUsername(r)
+	etag := listETag(username, r.URL.RawQuery, app.commandsVersion.Load(), app.executor.Version())
+	if checkNotModified(w, r, etag) {
+		return
 	}
 
-	respondJSON(w, http.StatusOK, commands)
+	commands := searchCommands(app.commands, CommandQuery{
+		Text: r.URL.Query().Get("q"),
+		Tag:  r.URL.Query().Get("tag"),
+
+		IncludeArchived: r.URL.Query().Get("include_archived") == "true",
+	})
+
+	favoritesOnly := r.URL.Query().Get("favorites") == "true"
+	responses := make([]CommandResponse, 0, len(commands))
+	for _, cmd := range commands {
+		response := app.commandResponse(cmd, username)
+		if favoritesOnly && !response.IsFavorite {
+			continue
+		}
+		responses = append(responses, response)
+	}
+
+	// Without paging parameters the whole list is returned as before
+	if !paged {
+		respondJSON(w, http.StatusOK, responses)
+		return
+	}
+	start, end := pageBounds(len(responses), limit, offset)
+	respondJSON(w, http.StatusOK, CommandListResponse{
+		Commands: responses[start:end],
+		Total:    len(responses),
+		Limit:    limit,
+		Offset:   offset,
+	})
+}
+
+// commandResponse adds the latest run to a saved command, and whether
+// username pinned it
+func (app *App) commandResponse(cmd *Command, username string) CommandResponse {
+	return CommandResponse{
+		Command:       cmd,
+		LastExecution: app.executor.LastRun(cmd.ID),
+		IsFavorite:    app.isFavorite(username, cmd.ID),
+	}
 }
 
 // GetCommandHandler handles GET /api/commands/:id
 func (app *App) GetCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 
@@ -123,30 +405,170 @@ func (app *App) GetCommandHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, cmd)
+	respondJSON(w, http.StatusOK, app.commandResponse(cmd, currentUsername(r)))
 }
 
-// DeleteCommandHandler handles DELETE /api/commands/:id
+// DeleteCommandHandler handles DELETE /api/commands/:id. The command is
+// archived so it can be restored; admins can remove it for good with
+// ?purge=true.
 func (app *App) DeleteCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if _, ok := app.commands[id]; !ok {
+	cmd, ok := app.commands[id]
+	if !ok {
 		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
 		return
 	}
 
+	purge := r.URL.Query().Get("purge") == "true"
+	if purge && !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required to purge commands"})
+		return
+	}
+	if !purge && cmd.ArchivedAt != nil {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Command is already archived"})
+		return
+	}
+
+	// Refuse to delete commands that are still in use unless forced. Forcing
+	// drops the references along with the command.
+	refs := commandReferencesLocked(cmd)
+	if len(refs) > 0 && r.URL.Query().Get("force") != "true" {
+		respondJSON(w, http.StatusConflict, CommandInUseResponse{
+			Error:      "Command is in use, pass force=true to delete it anyway",
+			References: refs,
+		})
+		return
+	}
+
+	if !purge {
+		now := time.Now()
+		schedule := cmd.Schedule
+		cmd.ArchivedAt = &now
+		if len(refs) > 0 {
+			// The schedule is dropped, so restoring the command does not
+			// bring it back
+			cmd.Schedule = ""
+		}
+		app.commandsChanged()
+		if err := app.storage.SaveCommands(app.commands); err != nil {
+			cmd.ArchivedAt = nil
+			cmd.Schedule = schedule
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to archive command"})
+			return
+		}
+		app.scheduler.Remove(id)
+
+		respondJSON(w, http.StatusOK, map[string]string{"message": "Command archived"})
+		return
+	}
+
 	delete(app.commands, id)
+	app.commandsChanged()
 	if err := app.storage.SaveCommands(app.commands); err != nil {
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete command"})
 		return
 	}
+	app.scheduler.Remove(id)
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Command deleted successfully"})
 }
 
+// RestoreCommandHandler handles POST /api/commands/:id/restore
+func (app *App) RestoreCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	cmd, ok := app.commands[mux.Vars(r)["id"]]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+	if cmd.ArchivedAt == nil {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Command is not archived"})
+		return
+	}
+
+	archivedAt := cmd.ArchivedAt
+	cmd.ArchivedAt = nil
+	app.commandsChanged()
+	if err := app.storage.SaveCommands(app.commands); err != nil {
+		cmd.ArchivedAt = archivedAt
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to restore command"})
+		return
+	}
+	app.scheduler.Set(cmd.ID, cmd.activeSchedule())
+
+	respondJSON(w, http.StatusOK, app.commandResponse(cmd, currentUsername(r)))
+}
+
+// CloneCommandHandler handles POST /api/commands/:id/clone, saving a copy of
+// a command under a new ID. The copy starts without execution history and
+// without a schedule, so cloning never doubles scheduled runs.
+func (app *App) CloneCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	cmd, ok := app.commands[mux.Vars(r)["id"]]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	clone := *cmd
+	clone.ID = uuid.New().String()
+	clone.Name = cmd.Name + " (copy)"
+	clone.CreatedAt = time.Now()
+	clone.UpdatedAt = clone.CreatedAt
+	clone.ArchivedAt = nil
+	clone.Revisions = nil
+	clone.Schedule = ""
+	clone.Args = append([]string(nil), cmd.Args...)
+	clone.Steps = append([]Step(nil), cmd.Steps...)
+	clone.Parameters = append([]Parameter(nil), cmd.Parameters...)
+	clone.Tags = append([]string(nil), cmd.Tags...)
+	if cmd.Env != nil {
+		clone.Env = make(map[string]string, len(cmd.Env))
+		for key, value := range cmd.Env {
+			clone.Env[key] = value
+		}
+	}
+	if cmd.Host != nil {
+		host := *cmd.Host
+		clone.Host = &host
+	}
+
+	app.commands[clone.ID] = &clone
+	app.commandsChanged()
+	if err := app.storage.SaveCommands(app.commands); err != nil {
+		delete(app.commands, clone.ID)
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save command"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, app.commandResponse(&clone, currentUsername(r)))
+}
+
+// commandReferencesLocked lists what would break if cmd were deleted. The
+// schedule of an archived command is already paused and is not listed. Caller
+// must hold app.mu.
+func commandReferencesLocked(cmd *Command) []CommandReference {
+	var refs []CommandReference
+	if schedule := cmd.activeSchedule(); schedule != "" {
+		refs = append(refs, CommandReference{Type: "schedule", CommandID: cmd.ID, Name: cmd.Name, Detail: schedule})
+	}
+	return refs
+}
+
 // UpdateCommandHandler handles PUT /api/commands/:id
 func (app *App) UpdateCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 
@@ -157,69 +579,472 @@ func (app *App) UpdateCommandHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var cmd Command
-	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &cmd) {
 		return
 	}
 
 	// Validate
-	if cmd.Name == "" {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Command name is required"})
-		return
-	}
-	if err := ValidateCommand(cmd.Workdir, cmd.Command); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	errs := app.commandInputErrors(&cmd)
+	errs.Add("env_preset", app.validatePresetRefLocked(cmd.EnvPreset))
+	if err := errs.Err(); err != nil {
+		respondValidationError(w, err)
 		return
 	}
 
 	// Update fields
+	old := *existing
 	existing.Name = cmd.Name
 	existing.Description = cmd.Description
 	existing.Workdir = cmd.Workdir
 	existing.Command = cmd.Command
+	existing.Shell = cmd.Shell
+	existing.Args = cmd.Args
+	existing.Env = cmd.Env
+	existing.EnvPreset = cmd.EnvPreset
+	existing.SoftTimeoutSeconds = cmd.SoftTimeoutSeconds
+	existing.HardTimeoutSeconds = cmd.HardTimeoutSeconds
+	existing.Schedule = cmd.Schedule
+	existing.Steps = cmd.Steps
+	existing.Stdin = cmd.Stdin
+	existing.StdinFile = cmd.StdinFile
+	existing.Host = cmd.Host
+	existing.Parameters = cmd.Parameters
+	existing.IsolateWorkdir = cmd.IsolateWorkdir
+	existing.SyncBack = cmd.SyncBack
+	existing.MaxRetries = cmd.MaxRetries
+	existing.RetryDelaySeconds = cmd.RetryDelaySeconds
+	existing.FailureCooldownSeconds = cmd.FailureCooldownSeconds
+	existing.RequireReauth = cmd.RequireReauth
+	existing.RequireApproval = cmd.RequireApproval
+	existing.SingleInstance = cmd.SingleInstance
+	existing.ExpandWorkdir = cmd.ExpandWorkdir
 	existing.Tags = cmd.Tags
 	existing.UpdatedAt = time.Now()
+	recordRevision(&old, existing, currentUsername(r), app.config.CommandRevisions)
 
 	// Save
+	app.commandsChanged()
 	if err := app.storage.SaveCommands(app.commands); err != nil {
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update command"})
 		return
 	}
+	app.scheduler.Set(existing.ID, existing.activeSchedule())
 
-	respondJSON(w, http.StatusOK, existing)
+	respondJSON(w, http.StatusOK, SaveCommandResponse{Command: existing, Warnings: commandWarnings(existing)})
+}
+
+// PreviewUpdateCommandHandler handles POST /api/commands/:id/preview-update
+//
+// Accepts the same body as UpdateCommandHandler and returns the field-level
+// changes it would make, without saving anything.
+func (app *App) PreviewUpdateCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	existing, ok := app.commands[id]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	var cmd Command
+	if !decodeJSON(w, r, &cmd) {
+		return
+	}
+
+	// Validate
+	errs := app.commandInputErrors(&cmd)
+	errs.Add("env_preset", app.validatePresetRefLocked(cmd.EnvPreset))
+	if err := errs.Err(); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+
+	changes := diffCommand(existing, &cmd)
+	respondJSON(w, http.StatusOK, CommandDiffResponse{
+		Changed: len(changes) > 0,
+		Changes: changes,
+	})
 }
 
 // ExecuteCommandHandler handles POST /api/commands/:id/execute
 func (app *App) ExecuteCommandHandler(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey, ok := app.beginIdempotentExecute(w, r)
+	if !ok {
+		return
+	}
+	defer app.idempotency.Release(idempotencyKey)
+	if !app.throttleExecuteRequest(w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	app.mu.RLock()
 	cmd, ok := app.commands[id]
 	if !ok {
+		app.mu.RUnlock()
 		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
 		return
 	}
+	if cmd.ArchivedAt != nil {
+		app.mu.RUnlock()
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Command is archived, restore it to run it"})
+		return
+	}
+	snapshot := *cmd
+	env, err := app.resolveEnvLocked(cmd.EnvPreset, cmd.Env)
+	app.mu.RUnlock()
 
-	// Get username from auth header
-	username, _, _ := parseBasicAuth(r.Header.Get("Authorization"))
+	params := savedCommandParams(&snapshot, env, currentUsername(r))
+	if err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
 
-	execution, err := app.executor.Execute(cmd.Workdir, cmd.Command, cmd.ID, cmd.Name, username)
+	var req ExecuteCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondInvalidBody(w, err)
+		return
+	}
+	if snapshot.RequireReauth && !app.verifyReauth(w, r, req.ReauthPassword) {
+		return
+	}
+	// Checked once the request is known to be well-formed and authorised, so
+	// only real attempts are recorded as rejected
+	if remaining := app.failureCooldown(&snapshot); remaining > 0 {
+		message := fmt.Sprintf("Command failed recently, try again in %s", remaining.Round(time.Second))
+		app.executor.RecordRejected(params, message)
+		w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: message})
+		return
+	}
+	params.Labels = req.Labels
+	err = resolveExecution(&params, &snapshot, req.Params)
+	if err == nil {
+		err = ValidateLabels(req.Labels)
+	}
+	if err == nil {
+		err = app.overrideTimeout(&params, req.TimeoutOverride)
+	}
+	if err == nil {
+		err = ValidateCommand(params.Workdir, params.Command, app.config.AllowedWorkdirs, app.config.Limits, app.config.CommandRules)
+	}
+	if err == nil {
+		err = ValidateSteps(params.Workdir, localShell(params.Shell, params.Host), params.Args, params.Steps, app.config.AllowedWorkdirs)
+	}
+	if err == nil {
+		err = ValidateStdin(params.Workdir, params.Stdin, params.StdinFile, app.config.AllowedWorkdirs)
+	}
+	if err == nil {
+		err = validateLocalWorkdirs(params)
+	}
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	respondJSON(w, http.StatusOK, ExecuteResponse{
-		ExecutionID: execution.ID,
-		Status:      execution.Status,
-		Message:     "Command execution started",
-	})
+	if !app.allowExecution(w, params) {
+		return
+	}
+
+	execution, err := app.executeSaved(params, &snapshot)
+	if err != nil {
+		respondExecuteError(w, err)
+		return
+	}
+	app.idempotency.Complete(idempotencyKey, execution.ID)
+
+	respondJSON(w, http.StatusOK, app.executeResponse(execution))
+}
+
+// verifyReauth checks the password re-entered to confirm a sensitive
+// execution, responding with 401 and returning false if it is wrong. Failures
+// count towards the login lockout so the check cannot be used to guess
+// passwords.
+func (app *App) verifyReauth(w http.ResponseWriter, r *http.Request, password string) bool {
+	username := currentUsername(r)
+	keys := app.loginLimiter.Keys(r, username)
+	if retryAfter, locked := app.loginLimiter.Locked(keys...); locked {
+		respondLockedOut(w, retryAfter)
+		return false
+	}
+
+	app.usersMu.RLock()
+	user, exists := app.users[username]
+	var hash string
+	if exists {
+		hash = user.Password
+	}
+	app.usersMu.RUnlock()
+	if password == "" || !exists || !checkPassword(hash, password) {
+		if password != "" {
+			app.loginLimiter.Fail(keys...)
+		}
+		w.Header().Set(reauthHeader, "required")
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "This command requires re-entering your password (reauth_password)"})
+		return false
+	}
+	return true
+}
+
+// throttleExecuteRequest enforces the per-user execute request rate,
+// responding with 429 and returning false if the user is sending requests
+// too fast. Unlike the execution rate limit it counts every request,
+// including ones that fail validation, and applies to admins too.
+func (app *App) throttleExecuteRequest(w http.ResponseWriter, r *http.Request) bool {
+	wait, ok := app.reqLimiter.Allow(currentUsername(r))
+	if ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: fmt.Sprintf("Too many execute requests, try again in %s", wait.Round(time.Millisecond))})
+	return false
+}
+
+// allowExecution enforces the per-user execution rate limit, responding with
+// 429 and returning false if the user is over it. Admins are exempt.
+func (app *App) allowExecution(w http.ResponseWriter, params ExecuteParams) bool {
+	if app.isAdmin(params.Username) {
+		return true
+	}
+	wait, ok := app.execLimiter.Allow(params.Username)
+	if ok {
+		return true
+	}
+	message := fmt.Sprintf("Execution rate limit exceeded, try again in %s", wait.Round(time.Second))
+	app.executor.RecordRejected(params, message)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: message})
+	return false
+}
+
+// failureCooldown returns how long a command must wait before it can run
+// again because its last run failed, or 0 if it can run now
+func (app *App) failureCooldown(cmd *Command) time.Duration {
+	if cmd.FailureCooldownSeconds <= 0 {
+		return 0
+	}
+	last, ok := app.executor.LastFinished(cmd.ID)
+	if !ok || last.Status != "failed" {
+		return 0
+	}
+	return time.Until(last.EndedAt.Add(time.Duration(cmd.FailureCooldownSeconds) * time.Second))
+}
+
+// overrideTimeout replaces the hard timeout of params with the
+// timeout_override of an execute request, if one was given
+func (app *App) overrideTimeout(params *ExecuteParams, seconds int) error {
+	if seconds == 0 {
+		return nil
+	}
+	if seconds < 0 {
+		return errors.New("timeout_override must be a positive number of seconds")
+	}
+	// Compared in seconds, as a huge value would overflow a Duration
+	if max := app.config.MaxTimeoutOverride; max > 0 && int64(seconds) > int64(max/time.Second) {
+		return fmt.Errorf("timeout_override cannot exceed %s", max)
+	}
+	params.HardTimeout = time.Duration(seconds) * time.Second
+	params.TimeoutOverridden = true
+	return nil
+}
+
+// respondExecuteError writes the response for an execution that could not
+// be started
+func respondExecuteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCommandRunning) {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Command is already running"})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+}
+
+// savedCommandParams builds the parameters to execute a saved command with
+// its resolved environment
+func savedCommandParams(cmd *Command, env map[string]string, username string) ExecuteParams {
+	return ExecuteParams{
+		Workdir:     cmd.Workdir,
+		Command:     cmd.script(),
+		Shell:       cmd.Shell,
+		Args:        cmd.Args,
+		Env:         env,
+		Tags:        cmd.Tags,
+		Steps:       cmd.Steps,
+		Stdin:       cmd.Stdin,
+		StdinFile:   cmd.StdinFile,
+		Host:        cmd.Host,
+		CommandID:   cmd.ID,
+		CommandName: cmd.Name,
+		Username:    username,
+		SoftTimeout: time.Duration(cmd.SoftTimeoutSeconds) * time.Second,
+		HardTimeout: time.Duration(cmd.HardTimeoutSeconds) * time.Second,
+
+		IsolateWorkdir: cmd.IsolateWorkdir,
+		SyncBack:       cmd.SyncBack,
+		SingleInstance: cmd.SingleInstance,
+
+		MaxRetries: cmd.MaxRetries,
+		RetryDelay: time.Duration(cmd.RetryDelaySeconds) * time.Second,
+	}
+}
+
+// executeMessage describes the state of a just submitted execution
+func executeMessage(execution *Execution) string {
+	if execution.Status == "queued" {
+		return "Command execution queued"
+	}
+	if execution.Status == pendingApprovalStatus {
+		return "Command execution waiting for approval"
+	}
+	return "Command execution started"
 }
 
 // ListExecutionsHandler handles GET /api/executions
+//
+// Supports ?limit=, ?offset=, ?status=, ?command_id= and ?executed_by=.
+// Instead of an offset, ?cursor= continues after the next_cursor of a
+// previous page, which stays stable while new executions are added.
+// With ?since=<cursor> only executions changed after the cursor are returned,
+// and ?wait=<seconds> holds the request until there is one or the wait ends.
+// An If-None-Match with the ETag of an unchanged list is answered with 304.
 func (app *App) ListExecutionsHandler(w http.ResponseWriter, r *http.Request) {
-	executions := app.executor.GetAllExecutions()
-	respondJSON(w, http.StatusOK, executions)
+	query := r.URL.Query()
+
+	limit, err := parseIntParam(query.Get("limit"), defaultExecutionsLimit)
+	if err != nil || limit < 1 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit must be a positive integer"})
+		return
+	}
+	// The page size is the server's choice unless the client asked for one
+	// within the cap; then a short page is reported as truncated
+	serverLimited := query.Get("limit") == ""
+	if maxLimit := app.config.MaxExecutionsLimit; maxLimit > 0 && limit > maxLimit {
+		limit = maxLimit
+		serverLimited = true
+	}
+
+	offset, err := parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "offset must be a non-negative integer"})
+		return
+	}
+
+	since, err := parseInt64Param(query.Get("since"), 0)
+	if err != nil || since < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "since must be a non-negative integer"})
+		return
+	}
+
+	waitSeconds, err := parseIntParam(query.Get("wait"), 0)
+	if err != nil || waitSeconds < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "wait must be a non-negative integer"})
+		return
+	}
+	wait := time.Duration(waitSeconds) * time.Second
+	if wait > maxExecutionsWait {
+		wait = maxExecutionsWait
+	}
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	var after *Execution
+	if value := query.Get("cursor"); value != "" {
+		if query.Get("offset") != "" {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "cursor and offset cannot be combined"})
+			return
+		}
+		if after, err = decodePageCursor(value); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	filter := ExecutionFilter{
+		Status:     query.Get("status"),
+		CommandID:  query.Get("command_id"),
+		ExecutedBy: query.Get("executed_by"),
+		Tag:        query.Get("tag"),
+		From:       from,
+		To:         to,
+		Since:      since,
+		After:      after,
+		Limit:      limit,
+		Offset:     offset,
+	}
+
+	// Take the cursor before listing so a change in between is never missed
+	version := app.executor.Version()
+	cursor, changed := app.executor.Changes()
+	executions, total := app.executor.ListExecutions(filter)
+
+	if total == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+	waitLoop:
+		for total == 0 {
+			select {
+			case <-changed:
+				version = app.executor.Version()
+				cursor, changed = app.executor.Changes()
+				executions, total = app.executor.ListExecutions(filter)
+			case <-timer.C:
+				break waitLoop
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	if checkNotModified(w, r, listETag(currentUsername(r), r.URL.RawQuery, version)) {
+		return
+	}
+
+	more := offset+len(executions) < total
+	response := ExecutionListResponse{
+		Executions: executions,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+		QueueDepth: app.executor.QueueDepth(),
+		Cursor:     cursor,
+		Truncated:  serverLimited && more,
+	}
+	if more && len(executions) > 0 {
+		response.NextCursor = encodePageCursor(executions[len(executions)-1])
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// executeResponse describes a new execution, with links to follow it
+func (app *App) executeResponse(execution *Execution) ExecuteResponse {
+	executionURL := app.config.BasePath + "/api/executions/" + execution.ID
+	return ExecuteResponse{
+		ExecutionID:  execution.ID,
+		Seq:          execution.Seq,
+		Status:       execution.Status,
+		Message:      executeMessage(execution),
+		ExecutionURL: executionURL,
+		OutputURL:    executionURL + "/output",
+		FollowURL:    fmt.Sprintf("%s/api/executions?since=%d&wait=%d", app.config.BasePath, execution.Rev, int(maxExecutionsWait.Seconds())),
+	}
+}
+
+// ActiveExecutionsHandler handles GET /api/executions/running, listing the
+// executions that are running or queued
+func (app *App) ActiveExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	running, queued := app.executor.ActiveExecutions()
+	respondJSON(w, http.StatusOK, ActiveExecutionsResponse{Running: running, Queued: queued})
 }
 
 // GetExecutionHandler handles GET /api/executions/:id
@@ -233,9 +1058,180 @@ func (app *App) GetExecutionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	encoding := r.URL.Query().Get("output_encoding")
+	if encoding != "" && encoding != "raw" && encoding != "base64" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "output_encoding must be 'raw' or 'base64'"})
+		return
+	}
+
+	tail, err := parseIntParam(r.URL.Query().Get("tail"), 0)
+	if err != nil || tail < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "tail must be a non-negative integer"})
+		return
+	}
+
+	if !selectAttempt(w, r, execution) {
+		return
+	}
+
+	for stream, dest := range map[string]*string{
+		"":       &execution.Output,
+		"stdout": &execution.Stdout,
+		"stderr": &execution.Stderr,
+	} {
+		output, err := app.executor.ReadOutput(execution, stream, tail)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read execution output"})
+			return
+		}
+		if encoding == "base64" {
+			output = base64.StdEncoding.EncodeToString([]byte(output))
+		}
+		*dest = output
+	}
+	if encoding == "base64" {
+		execution.OutputEncoding = encoding
+	}
+
 	respondJSON(w, http.StatusOK, execution)
 }
 
+// SearchExecutionsHandler handles GET /api/executions/search, finding
+// executions whose name, command or output contain ?q=, newest first
+func (app *App) SearchExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "q is required"})
+		return
+	}
+
+	limit, err := parseIntParam(query.Get("limit"), defaultExecutionsLimit)
+	if err != nil || limit < 1 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit must be a positive integer"})
+		return
+	}
+	if maxLimit := app.config.MaxExecutionsLimit; maxLimit > 0 && limit > maxLimit {
+		limit = maxLimit
+	}
+
+	executions, _ := app.executor.ListExecutions(ExecutionFilter{Status: query.Get("status")})
+	results, truncated, err := searchExecutions(executions, text, limit)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to search execution output"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ExecutionSearchResponse{
+		Results:   results,
+		Limit:     limit,
+		Truncated: truncated,
+	})
+}
+
+// GetExecutionOutputHandler handles GET /api/executions/:id/output,
+// returning the output as plain text. ?stream=stdout|stderr selects a single
+// stream; tail and attempt work as for GET /api/executions/:id. ?download=true
+// serves the output as a file attachment and ?format=raw strips ANSI escapes.
+func (app *App) GetExecutionOutputHandler(w http.ResponseWriter, r *http.Request) {
+	execution, ok := app.executor.GetExecution(mux.Vars(r)["id"])
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+	if stream != "" && stream != "stdout" && stream != "stderr" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "stream must be 'stdout' or 'stderr'"})
+		return
+	}
+
+	tail, err := parseIntParam(r.URL.Query().Get("tail"), 0)
+	if err != nil || tail < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "tail must be a non-negative integer"})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "raw" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "format must be 'raw'"})
+		return
+	}
+
+	// Downloads are meant to be complete logs, so unfinished executions are
+	// only downloaded when a partial log is asked for
+	download := r.URL.Query().Get("download") == "true"
+	if download && (execution.Status == "queued" || execution.Status == "running") && r.URL.Query().Get("partial") != "true" {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is still running; pass partial=true to download the output so far"})
+		return
+	}
+
+	var output string
+	if value := r.URL.Query().Get("step"); value != "" {
+		step, err := strconv.Atoi(value)
+		if err != nil || step < 1 {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "step must be a positive integer"})
+			return
+		}
+		if stream != "" || r.URL.Query().Get("attempt") != "" {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "step cannot be combined with stream or attempt"})
+			return
+		}
+		if step > len(execution.Steps) {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Step not found"})
+			return
+		}
+		output, err = app.executor.ReadStepOutput(execution, step-1, tail)
+	} else {
+		if !selectAttempt(w, r, execution) {
+			return
+		}
+		output, err = app.executor.ReadOutput(execution, stream, tail)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to read execution output"})
+		return
+	}
+
+	if format == "raw" {
+		output = stripANSI(output)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Execution-Status", execution.Status)
+	if download {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=execution-%s.log", execution.ID))
+	}
+	io.WriteString(w, output)
+}
+
+// selectAttempt points execution at the log of the attempt given by
+// ?attempt=N, to show the output of an earlier attempt instead of the latest
+// one. It responds with an error and returns false if the attempt is invalid.
+func selectAttempt(w http.ResponseWriter, r *http.Request, execution *Execution) bool {
+	value := r.URL.Query().Get("attempt")
+	if value == "" {
+		return true
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "attempt must be a positive integer"})
+		return false
+	}
+	if number == execution.Attempt {
+		return true
+	}
+	for _, attempt := range execution.Attempts {
+		if attempt.Number == number {
+			execution.LogFile = attempt.LogFile
+			return true
+		}
+	}
+	respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Attempt not found"})
+	return false
+}
+
 // DeleteExecutionHandler handles DELETE /api/executions/:id
 func (app *App) DeleteExecutionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -249,125 +1245,252 @@ func (app *App) DeleteExecutionHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Execution deleted successfully"})
 }
 
+// CancelExecutionHandler handles POST /api/executions/:id/cancel
+func (app *App) CancelExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := app.executor.CancelExecution(id); err != nil {
+		switch {
+		case errors.Is(err, ErrExecutionNotFound):
+			respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		case errors.Is(err, ErrExecutionNotRunning):
+			respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution has already finished"})
+		case errors.Is(err, ErrExecutionStopping):
+			respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is already being stopped"})
+		default:
+			respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Execution cancellation requested"})
+}
+
 // ClearExecutionsHandler handles POST /api/executions/clear
 func (app *App) ClearExecutionsHandler(w http.ResponseWriter, r *http.Request) {
 	app.executor.ClearExecutions()
 	respondJSON(w, http.StatusOK, map[string]string{"message": "All executions cleared"})
 }
 
+// DeleteExecutionsHandler handles POST /api/executions/delete, deleting the
+// executions with the given IDs or those matching a status and age filter
+func (app *App) DeleteExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req DeleteExecutionsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	filtered := req.Status != "" || req.OlderThan != ""
+	if len(req.IDs) > 0 && filtered {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ids cannot be combined with status or older_than"})
+		return
+	}
+	if len(req.IDs) == 0 && !filtered {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ids, status or older_than is required"})
+		return
+	}
+
+	response := DeleteExecutionsResponse{DeletedIDs: []string{}, Failed: []DeleteExecutionError{}}
+	if len(req.IDs) > 0 {
+		deleted, missing := app.executor.DeleteExecutions(req.IDs)
+		response.DeletedIDs = append(response.DeletedIDs, deleted...)
+		for _, id := range missing {
+			response.Failed = append(response.Failed, DeleteExecutionError{ID: id, Error: "Execution not found"})
+		}
+	} else {
+		var olderThan time.Duration
+		if req.OlderThan != "" {
+			var err error
+			if olderThan, err = time.ParseDuration(req.OlderThan); err != nil || olderThan <= 0 {
+				respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "older_than must be a positive duration such as 720h"})
+				return
+			}
+		}
+		response.DeletedIDs = append(response.DeletedIDs, app.executor.DeleteMatchingExecutions(req.Status, olderThan)...)
+	}
+	response.Deleted = len(response.DeletedIDs)
+
+	respondJSON(w, http.StatusOK, response)
+}
+
 // CheckSetupHandler handles GET /api/auth/setup
 func (app *App) CheckSetupHandler(w http.ResponseWriter, r *http.Request) {
-	needsSetup := len(app.users) == 0
+	needsSetup := !app.hasUsers()
 	respondJSON(w, http.StatusOK, map[string]bool{"needs_setup": needsSetup})
 }
 
 // SetupHandler handles POST /api/auth/setup
 func (app *App) SetupHandler(w http.ResponseWriter, r *http.Request) {
 	// Only allow setup if no users exist
-	if len(app.users) > 0 {
+	if app.hasUsers() {
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Setup already completed"})
 		return
 	}
 
 	var req SetupRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate
-	if err := validateUsername(req.Username); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	var errs ValidationErrors
+	errs.Add("username", validateUsername(req.Username, app.config.UsernameMinLength))
+	errs.Add("password", validatePassword(req.Password, app.config.PasswordPolicy))
+	if err := errs.Err(); err != nil {
+		respondValidationError(w, err)
 		return
 	}
-	if err := validatePassword(req.Password); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
 		return
 	}
 
-	// Create root user
+	// Create root user, who administers the server
 	user := &User{
 		Username:  req.Username,
-		Password:  req.Password,
+		Password:  hash,
+		Admin:     true,
 		CreatedAt: time.Now(),
 	}
 
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+	// Checked again under the lock, since two setup requests may race
+	if len(app.users) > 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Setup already completed"})
+		return
+	}
 	app.users[user.Username] = user
 	if err := app.storage.SaveUsers(app.users); err != nil {
+		delete(app.users, user.Username)
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, UserResponse{
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt,
-	})
+	respondJSON(w, http.StatusCreated, newUserResponse(user))
 }
 
 // LoginHandler handles POST /api/auth/login
 func (app *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
+	keys := app.loginLimiter.Keys(r, req.Username)
+	if retryAfter, locked := app.loginLimiter.Locked(keys...); locked {
+		respondLockedOut(w, retryAfter)
+		return
+	}
+
+	// Hashing is slow, so the password is checked without holding the lock
+	app.usersMu.RLock()
 	user, exists := app.users[req.Username]
-	if !exists || user.Password != req.Password {
+	var hash string
+	if exists {
+		hash = user.Password
+	}
+	app.usersMu.RUnlock()
+	if !exists || !checkPassword(hash, req.Password) {
+		app.loginLimiter.Fail(keys...)
 		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
 
-	respondJSON(w, http.StatusOK, UserResponse{
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt,
+	// Held for writing, since logging in with a recovery code consumes it
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+	if user, exists = app.users[req.Username]; !exists {
+		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
+		return
+	}
+	if user.TOTPEnabled {
+		if req.OTP == "" {
+			respondJSON(w, http.StatusUnauthorized, TwoFactorRequiredResponse{Error: "Two-factor code required", TwoFactorRequired: true})
+			return
+		}
+		ok, usedRecovery := checkSecondFactor(user, req.OTP)
+		if !ok {
+			app.loginLimiter.Fail(keys...)
+			respondJSON(w, http.StatusUnauthorized, TwoFactorRequiredResponse{Error: "Invalid two-factor code", TwoFactorRequired: true})
+			return
+		}
+		if usedRecovery {
+			if err := app.storage.SaveUsers(app.users); err != nil {
+				respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
+				return
+			}
+		}
+	}
+	app.loginLimiter.Reset(app.loginLimiter.UserKey(r, req.Username))
+
+	session, err := app.sessions.Create(user.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to create session"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, LoginResponse{
+		UserResponse: newUserResponse(user),
+		Token:        session.Token,
+		ExpiresAt:    session.ExpiresAt,
 	})
 }
 
 // LogoutHandler handles POST /api/auth/logout
 func (app *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Logout is handled client-side by clearing cookies
+	if token, ok := parseBearerToken(r.Header.Get("Authorization")); ok {
+		app.sessions.Revoke(token)
+	}
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
 }
 
 // GetCurrentUserHandler handles GET /api/auth/me
 func (app *App) GetCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
-	username, _, ok := parseBasicAuth(r.Header.Get("Authorization"))
-	if !ok {
-		respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
-		return
-	}
-
-	user, exists := app.users[username]
+	app.usersMu.RLock()
+	defer app.usersMu.RUnlock()
+	user, exists := app.users[currentUsername(r)]
 	if !exists {
 		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
-	respondJSON(w, http.StatusOK, UserResponse{
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt,
-	})
+	respondJSON(w, http.StatusOK, newUserResponse(user))
 }
 
 // CreateUserHandler handles POST /api/users
 func (app *App) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
 	var req CreateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate
-	if err := validateUsername(req.Username); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	var errs ValidationErrors
+	errs.Add("username", validateUsername(req.Username, app.config.UsernameMinLength))
+	errs.Add("password", validatePassword(req.Password, app.config.PasswordPolicy))
+	if err := errs.Err(); err != nil {
+		respondValidationError(w, err)
 		return
 	}
-	if err := validatePassword(req.Password); err != nil {
-		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
 		return
 	}
 
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+
 	// Check if user already exists
 	if _, exists := app.users[req.Username]; exists {
 		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "User already exists"})
@@ -377,63 +1500,474 @@ func (app *App) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Create user
 	user := &User{
 		Username:  req.Username,
-		Password:  req.Password,
+		Password:  hash,
 		CreatedAt: time.Now(),
 	}
 
 	app.users[user.Username] = user
 	if err := app.storage.SaveUsers(app.users); err != nil {
+		delete(app.users, user.Username)
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, UserResponse{
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt,
-	})
+	respondJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+// ImportUsersHandler handles POST /api/users/import
+//
+// The body is an array of users. With ?mode=error (default) the import is
+// all-or-nothing: any invalid or existing username aborts it. With ?mode=skip
+// existing usernames are skipped and the remaining valid users are created.
+func (app *App) ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "error"
+	}
+	if mode != "error" && mode != "skip" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "mode must be 'error' or 'skip'"})
+		return
+	}
+
+	var reqs []ImportUserRequest
+	if !decodeJSON(w, r, &reqs) {
+		return
+	}
+	if len(reqs) == 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "No users to import"})
+		return
+	}
+
+	// Validate every item before creating anything. Hashing is slow, so the
+	// passwords are hashed before taking the users lock.
+	resp := ImportUsersResponse{Results: make([]ImportUserResult, 0, len(reqs))}
+	users := make([]*User, len(reqs))
+	for i, req := range reqs {
+		result := ImportUserResult{Username: req.Username, Status: "created", Role: req.Role}
+
+		admin, roleErr := parseUserRole(req.Role)
+		if err := validateUsername(req.Username, app.config.UsernameMinLength); err != nil {
+			result.Status, result.Error = "error", err.Error()
+		} else if err := validatePassword(req.Password, app.config.PasswordPolicy); err != nil {
+			result.Status, result.Error = "error", err.Error()
+		} else if roleErr != nil {
+			result.Status, result.Error = "error", roleErr.Error()
+		} else {
+			hash, err := hashPassword(req.Password)
+			if err != nil {
+				respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to hash password"})
+				return
+			}
+			users[i] = &User{
+				Username:  req.Username,
+				Password:  hash,
+				Admin:     admin,
+				CreatedAt: time.Now(),
+			}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+
+	seen := make(map[string]bool, len(reqs))
+	var toCreate []*User
+	for i := range resp.Results {
+		result := &resp.Results[i]
+		if result.Status == "created" {
+			if _, exists := app.users[result.Username]; exists || seen[result.Username] {
+				if mode == "skip" {
+					result.Status = "skipped"
+				} else {
+					result.Status, result.Error = "error", "User already exists"
+				}
+			}
+		}
+
+		switch result.Status {
+		case "created":
+			seen[result.Username] = true
+			toCreate = append(toCreate, users[i])
+			resp.Created++
+		case "skipped":
+			resp.Skipped++
+		case "error":
+			resp.Failed++
+		}
+	}
+
+	if mode == "error" && resp.Failed > 0 {
+		// Nothing is created when any item fails
+		for i := range resp.Results {
+			if resp.Results[i].Status == "created" {
+				resp.Results[i].Status = "skipped"
+			}
+		}
+		resp.Skipped += resp.Created
+		resp.Created = 0
+		respondJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	for _, user := range toCreate {
+		app.users[user.Username] = user
+	}
+	if err := app.storage.SaveUsers(app.users); err != nil {
+		for _, user := range toCreate {
+			delete(app.users, user.Username)
+		}
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save users"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// parseUserRole maps the role of an imported user onto the admin flag
+func parseUserRole(role string) (admin bool, err error) {
+	switch role {
+	case "", "user":
+		return false, nil
+	case "admin":
+		return true, nil
+	}
+	return false, errors.New("role must be 'admin' or 'user'")
 }
 
 // ListUsersHandler handles GET /api/users
 func (app *App) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, paged, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	app.usersMu.RLock()
 	users := make([]UserResponse, 0, len(app.users))
 	for _, user := range app.users {
-		users = append(users, UserResponse{
-			Username:  user.Username,
-			CreatedAt: user.CreatedAt,
-		})
+		users = append(users, newUserResponse(user))
 	}
+	app.usersMu.RUnlock()
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].Username < users[j].Username
+	})
 
-	respondJSON(w, http.StatusOK, users)
+	// Without paging parameters the whole list is returned as before
+	if !paged {
+		respondJSON(w, http.StatusOK, users)
+		return
+	}
+	start, end := pageBounds(len(users), limit, offset)
+	respondJSON(w, http.StatusOK, UserListResponse{
+		Users:  users[start:end],
+		Total:  len(users),
+		Limit:  limit,
+		Offset: offset,
+	})
 }
 
 // DeleteUserHandler handles DELETE /api/users/:username
 func (app *App) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
 	vars := mux.Vars(r)
 	username := vars["username"]
 
-	if _, exists := app.users[username]; !exists {
+	app.usersMu.Lock()
+	user, exists := app.users[username]
+	if !exists {
+		app.usersMu.Unlock()
 		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
 	// Prevent deleting the last user
 	if len(app.users) == 1 {
+		app.usersMu.Unlock()
 		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Cannot delete the last user"})
 		return
 	}
 
 	delete(app.users, username)
-	if err := app.storage.SaveUsers(app.users); err != nil {
+	err := app.storage.SaveUsers(app.users)
+	if err != nil {
+		app.users[username] = user
+	}
+	app.usersMu.Unlock()
+	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete user"})
 		return
 	}
+	app.sessions.RevokeUser(username)
+	if err := app.tokens.RevokeUser(username); err != nil {
+		slog.Error("Failed to revoke API tokens", "user", username, "error", err)
+	}
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 }
 
+// commandWarnings lists problems with a command that do not prevent saving
+// it, such as a workdir that does not exist yet
+func commandWarnings(cmd *Command) []string {
+	warnings := []string{}
+	// Templated and expanded workdirs are only known when the command runs,
+	// and those of remote commands only exist on their host
+	if !cmd.workdirResolvedLater() && cmd.Host == nil {
+		if err := ValidateWorkdirExists(cmd.Workdir); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	return warnings
+}
+
+// validateCommandInput checks the user-editable fields of a command,
+// returning ValidationErrors listing every invalid field
+func (app *App) validateCommandInput(cmd *Command) error {
+	return app.commandInputErrors(cmd).Err()
+}
+
+// commandInputErrors collects the problems with the user-editable fields of
+// a command
+func (app *App) commandInputErrors(cmd *Command) ValidationErrors {
+	var errs ValidationErrors
+	limits := app.config.Limits
+	if cmd.Name == "" {
+		errs.Add("name", errors.New("Command name is required"))
+	}
+	errs.Add("name", checkLength("name", cmd.Name, limits.Name))
+	errs.Add("description", checkLength("description", cmd.Description, limits.Description))
+	errs.Add("tags", limits.checkTags(cmd.Tags))
+
+	// Templated and expanded workdirs are checked once they are resolved
+	allowedWorkdirs := app.config.AllowedWorkdirs
+	if cmd.workdirResolvedLater() {
+		allowedWorkdirs = nil
+	}
+	if strings.TrimSpace(cmd.Workdir) == "" {
+		errs.Add("workdir", errors.New("workdir cannot be empty"))
+	} else if err := checkSize("workdir", cmd.Workdir, limits.Workdir); err != nil {
+		errs.Add("workdir", err)
+	} else {
+		errs.Add("workdir", checkWorkdirAllowed(cmd.Workdir, allowedWorkdirs))
+	}
+	errs.Add("command", checkSize("command", cmd.script(), limits.Command))
+	errs.Add("command", app.config.CommandRules.Check(cmd.script()))
+	errs.Add("stdin_file", ValidateStdin(cmd.Workdir, cmd.Stdin, cmd.StdinFile, allowedWorkdirs))
+
+	if len(cmd.Steps) > 0 {
+		if cmd.Command != "" {
+			errs.Add("steps", errors.New("command and steps cannot be combined"))
+		}
+		errs.Add("steps", ValidateSteps(cmd.Workdir, localShell(cmd.Shell, cmd.Host), cmd.Args, cmd.Steps, allowedWorkdirs))
+	} else {
+		if strings.TrimSpace(cmd.Command) == "" {
+			errs.Add("command", errors.New("command cannot be empty"))
+		}
+		if len(cmd.Args) > maxArgs {
+			errs.Add("args", fmt.Errorf("args cannot have more than %d entries", maxArgs))
+		}
+		if strings.TrimSpace(cmd.Command) != "" {
+			errs.Add("shell", ValidateShell(localShell(cmd.Shell, cmd.Host), cmd.Command, nil))
+		}
+	}
+
+	if cmd.SoftTimeoutSeconds < 0 {
+		errs.Add("soft_timeout_seconds", errors.New("Timeouts cannot be negative"))
+	}
+	if cmd.HardTimeoutSeconds < 0 {
+		errs.Add("hard_timeout_seconds", errors.New("Timeouts cannot be negative"))
+	}
+	if cmd.SoftTimeoutSeconds > 0 && cmd.HardTimeoutSeconds > 0 && cmd.SoftTimeoutSeconds >= cmd.HardTimeoutSeconds {
+		errs.Add("soft_timeout_seconds", errors.New("Soft timeout must be shorter than hard timeout"))
+	}
+	if cmd.MaxRetries < 0 || cmd.MaxRetries > maxRetries {
+		errs.Add("max_retries", fmt.Errorf("max_retries must be between 0 and %d", maxRetries))
+	}
+	if cmd.RetryDelaySeconds < 0 {
+		errs.Add("retry_delay_seconds", errors.New("retry_delay_seconds cannot be negative"))
+	}
+	if cmd.FailureCooldownSeconds < 0 {
+		errs.Add("failure_cooldown_seconds", errors.New("failure_cooldown_seconds cannot be negative"))
+	}
+	errs.Add("host", validateRemoteHost(cmd.Host))
+	if cmd.Host != nil && cmd.IsolateWorkdir {
+		errs.Add("isolate_workdir", errors.New("isolate_workdir cannot be combined with a remote host"))
+	}
+	if cmd.Host != nil && cmd.StdinFile != "" {
+		errs.Add("stdin_file", errors.New("stdin_file cannot be combined with a remote host"))
+	}
+	if cmd.Host != nil && cmd.ExpandWorkdir {
+		errs.Add("expand_workdir", errors.New("expand_workdir cannot be combined with a remote host"))
+	}
+	errs.Add("schedule", validateSchedule(cmd.Schedule))
+	errs.Add("parameters", validateParameters(cmd))
+	errs.Add("env", ValidateEnv(cmd.Env))
+	return errs
+}
+
+// ValidationErrors collects the invalid fields of a request so they can all
+// be reported at once
+type ValidationErrors []FieldError
+
+// Add records err against field, doing nothing if err is nil
+func (v *ValidationErrors) Add(field string, err error) {
+	if err != nil {
+		*v = append(*v, FieldError{Field: field, Message: err.Error(), err: err})
+	}
+}
+
+// Err returns v as an error, or nil if no field is invalid
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, field := range v {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(v))
+	for i, field := range v {
+		errs[i] = field.err
+	}
+	return errs
+}
+
+// respondValidationError writes a validation error, listing the invalid
+// fields if err is ValidationErrors
+func respondValidationError(w http.ResponseWriter, err error) {
+	var fields ValidationErrors
+	if errors.As(err, &fields) {
+		respondJSON(w, validationStatus(err), ValidationErrorResponse{Error: err.Error(), Fields: fields})
+		return
+	}
+	respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+}
+
+// validationStatus returns the HTTP status for a validation error
+func validationStatus(err error) int {
+	if errors.Is(err, ErrWorkdirNotAllowed) || errors.Is(err, ErrCommandNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// parseTimeRange reads the ?from= and ?to= RFC 3339 timestamps of a request,
+// either of which may be omitted. On invalid values it writes a 400 response
+// and returns false.
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	query := r.URL.Query()
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "from must be an RFC 3339 timestamp"})
+			return from, to, false
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "to must be an RFC 3339 timestamp"})
+			return from, to, false
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "to must not be before from"})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// parsePage reads the ?limit= and ?offset= of a list request. paged reports
+// whether either was given. On invalid values it writes a 400 response and
+// returns false.
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, paged, ok bool) {
+	query := r.URL.Query()
+	paged = query.Get("limit") != "" || query.Get("offset") != ""
+
+	limit, err := parseIntParam(query.Get("limit"), defaultListLimit)
+	if err != nil || limit < 1 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit must be a positive integer"})
+		return 0, 0, false, false
+	}
+	offset, err = parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "offset must be a non-negative integer"})
+		return 0, 0, false, false
+	}
+	return limit, offset, paged, true
+}
+
+// pageBounds returns the bounds of the page at offset of a list of total
+// items
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset >= total {
+		return total, total
+	}
+	end := total
+	if offset+limit < total {
+		end = offset + limit
+	}
+	return offset, end
+}
+
+// parseIntParam parses an integer query parameter, returning fallback if empty
+func parseIntParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseInt64Param parses an optional 64-bit integer query parameter
+func parseInt64Param(value string, fallback int64) (int64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// respondLockedOut rejects a login attempt from a locked out client
+func respondLockedOut(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "Too many failed login attempts, try again later"})
+}
+
 // respondJSON writes a JSON response
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// decodeJSON decodes the request body into v. On failure it writes a 400
+// response, distinguishing an empty body from malformed JSON, and returns
+// false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if err == io.EOF {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Request body is required"})
+		} else {
+			respondInvalidBody(w, err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// version is the build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// HealthResponse is the body of GET /healthz
+type HealthResponse struct {
+	Status            string  `json:"status"`
+	Version           string  `json:"version"`
+	UptimeSeconds     float64 `json:"uptime_seconds"`
+	RunningExecutions int     `json:"running_executions"`
+}
+
+// ReadinessResponse is the body of GET /readyz
+type ReadinessResponse struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthHandler handles GET /healthz (liveness)
+func (app *App) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, HealthResponse{
+		Status:            "ok",
+		Version:           version,
+		UptimeSeconds:     time.Since(app.startedAt).Seconds(),
+		RunningExecutions: app.executor.RunningCount(),
+	})
+}
+
+// ReadinessHandler handles GET /readyz. It reports 503 if stored data failed
+// to load at startup.
+func (app *App) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.loadErr != nil {
+		respondJSON(w, http.StatusServiceUnavailable, ReadinessResponse{Error: app.loadErr.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, ReadinessResponse{Ready: true})
+}
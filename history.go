@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CommandRevision is a previous version of a saved command, recorded when
+// an edit changed it
+type CommandRevision struct {
+	Command   string    `json:"command"` // Command, or the commands of its steps one per line
+	Workdir   string    `json:"workdir"`
+	Changes   []string  `json:"changes"` // Fields the edit changed
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// CommandHistoryResponse is the body of GET /api/commands/:id/history
+type CommandHistoryResponse struct {
+	CommandID string            `json:"command_id"`
+	Revisions []CommandRevision `json:"revisions"` // Newest first
+}
+
+// recordRevision adds old as a revision of updated if the edit changed any
+// field, keeping the limit newest revisions. Zero or less keeps none.
+func recordRevision(old, updated *Command, username string, limit int) {
+	changes := diffCommand(old, updated)
+	if len(changes) == 0 {
+		return
+	}
+	if limit <= 0 {
+		updated.Revisions = nil
+		return
+	}
+
+	revision := CommandRevision{
+		Command:   old.script(),
+		Workdir:   old.Workdir,
+		ChangedBy: username,
+		ChangedAt: time.Now(),
+	}
+	for _, change := range changes {
+		revision.Changes = append(revision.Changes, change.Field)
+	}
+	// Copy rather than append, the old version may share the slice
+	revisions := append([]CommandRevision(nil), old.Revisions...)
+	revisions = append(revisions, revision)
+	if len(revisions) > limit {
+		revisions = revisions[len(revisions)-limit:]
+	}
+	updated.Revisions = revisions
+}
+
+// CommandHistoryHandler handles GET /api/commands/:id/history, listing the
+// previous versions of a command newest first
+func (app *App) CommandHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	cmd, ok := app.commands[mux.Vars(r)["id"]]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	revisions := make([]CommandRevision, len(cmd.Revisions))
+	for i, revision := range cmd.Revisions {
+		revisions[len(revisions)-1-i] = revision
+	}
+	respondJSON(w, http.StatusOK, CommandHistoryResponse{CommandID: cmd.ID, Revisions: revisions})
+}
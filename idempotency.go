@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader lets clients retry execute requests without starting the
+// execution twice
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength caps the length of an Idempotency-Key
+const maxIdempotencyKeyLength = 255
+
+// idempotencyEntry is the outcome of a request made with an Idempotency-Key
+type idempotencyEntry struct {
+	route       string // Method and path the key was first used for
+	executionID string // Empty while the first request is in progress
+	expiresAt   time.Time
+}
+
+// IdempotencyStore remembers in memory which execution each Idempotency-Key
+// started, for the TTL after it was first used
+type IdempotencyStore struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	keys map[string]*idempotencyEntry
+}
+
+// NewIdempotencyStore creates an idempotency store with the given TTL
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:  ttl,
+		keys: make(map[string]*idempotencyEntry),
+	}
+}
+
+// Begin claims key for a request to route. If the key was already used it
+// returns its entry instead, with claimed false.
+func (s *IdempotencyStore) Begin(key, route string) (idempotencyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if entry, ok := s.keys[key]; ok {
+		return *entry, false
+	}
+	s.keys[key] = &idempotencyEntry{route: route, expiresAt: time.Now().Add(s.ttl)}
+	return idempotencyEntry{}, true
+}
+
+// Complete records the execution started by the request that claimed key
+func (s *IdempotencyStore) Complete(key, executionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.keys[key]; ok {
+		entry.executionID = executionID
+	}
+}
+
+// Release frees key if its request did not start an execution, so the
+// request can be retried
+func (s *IdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.keys[key]; ok && entry.executionID == "" {
+		delete(s.keys, key)
+	}
+}
+
+// Forget removes key regardless of its outcome
+func (s *IdempotencyStore) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+}
+
+// pruneLocked removes expired keys. Caller must hold s.mu.
+func (s *IdempotencyStore) pruneLocked() {
+	now := time.Now()
+	for key, entry := range s.keys {
+		if now.After(entry.expiresAt) {
+			delete(s.keys, key)
+		}
+	}
+}
+
+// beginIdempotentExecute handles the Idempotency-Key of an execute request.
+// If the key already started an execution, that execution is returned and ok
+// is false. Otherwise the returned key, scoped to the user, must be passed to
+// Complete once the execution started, and to Release in any case. key is ""
+// if the request has no Idempotency-Key.
+func (app *App) beginIdempotentExecute(w http.ResponseWriter, r *http.Request) (key string, ok bool) {
+	header := r.Header.Get(idempotencyHeader)
+	if header == "" {
+		return "", true
+	}
+	if len(header) > maxIdempotencyKeyLength {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Idempotency-Key is too long"})
+		return "", false
+	}
+
+	key = currentUsername(r) + "\x00" + header
+	route := r.Method + " " + r.URL.Path
+	for {
+		entry, claimed := app.idempotency.Begin(key, route)
+		if claimed {
+			return key, true
+		}
+		if entry.route != route {
+			respondJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: "Idempotency-Key was already used for a different request"})
+			return "", false
+		}
+		if entry.executionID == "" {
+			respondJSON(w, http.StatusConflict, ErrorResponse{Error: "A request with this Idempotency-Key is in progress"})
+			return "", false
+		}
+
+		execution, exists := app.executor.GetExecution(entry.executionID)
+		if !exists {
+			// The execution was deleted since, so the key no longer
+			// protects anything
+			app.idempotency.Forget(key)
+			continue
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		respondJSON(w, http.StatusOK, app.executeResponse(execution))
+		return "", false
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Outcomes of an isolated execution's working copy
+const (
+	isolationDiscarded = "discarded"
+	isolationSynced    = "synced"
+)
+
+// createWorkingCopy copies workdir to a new temporary directory
+func createWorkingCopy(executionID, workdir string) (string, error) {
+	tempDir, err := os.MkdirTemp("", "deployar-"+executionID+"-")
+	if err != nil {
+		return "", err
+	}
+	if err := copyTree(workdir, tempDir); err != nil {
+		os.RemoveAll(tempDir)
+		return "", err
+	}
+	return tempDir, nil
+}
+
+// finishWorkingCopy copies the working copy back over workdir if syncBack is
+// set and the run succeeded, then removes it. It returns the outcome to
+// record on the execution.
+func finishWorkingCopy(tempDir, workdir string, syncBack, succeeded bool) string {
+	outcome := isolationDiscarded
+	if syncBack && succeeded {
+		outcome = isolationSynced
+		if err := copyTree(tempDir, workdir); err != nil {
+			outcome = fmt.Sprintf("sync failed: %v", err)
+		}
+	}
+	if err := os.RemoveAll(tempDir); err != nil {
+		outcome += fmt.Sprintf("; cleanup failed: %v", err)
+	}
+	return outcome
+}
+
+// copyTree copies the directory tree at src into dst, preserving file modes
+// and symlinks. Existing files in dst are overwritten; other special files
+// are skipped.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// copyFile copies a regular file, replacing dst
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginAttempts tracks recent failures for one key
+type loginAttempts struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter locks out keys (source IPs, username/IP pairs) after too many
+// failed logins within a sliding window
+type LoginLimiter struct {
+	mu          sync.Mutex
+	maxAttempts int
+	window      time.Duration
+	lockout     time.Duration
+	ipHeader    string
+	attempts    map[string]*loginAttempts
+}
+
+// NewLoginLimiter creates a limiter. maxAttempts <= 0 disables it. If ipHeader
+// is set the client IP is read from that header, which must be set by a
+// trusted reverse proxy.
+func NewLoginLimiter(maxAttempts int, window, lockout time.Duration, ipHeader string) *LoginLimiter {
+	return &LoginLimiter{
+		maxAttempts: maxAttempts,
+		window:      window,
+		lockout:     lockout,
+		ipHeader:    ipHeader,
+		attempts:    make(map[string]*loginAttempts),
+	}
+}
+
+// Keys returns the limiter keys for a login attempt. Keying usernames by
+// source IP means guessing someone's password from elsewhere cannot lock the
+// real user out.
+func (l *LoginLimiter) Keys(r *http.Request, username string) []string {
+	return []string{"ip:" + l.clientIP(r), l.UserKey(r, username)}
+}
+
+// UserKey returns the username/IP key of a login attempt. Only this key is
+// reset after a successful login, so logging into one account does not clear
+// the failures an IP racked up guessing others.
+func (l *LoginLimiter) UserKey(r *http.Request, username string) string {
+	return "user:" + username + "@" + l.clientIP(r)
+}
+
+// clientIP returns the IP of the client that sent a request. Behind a proxy
+// the last X-Forwarded-For entry is the one the trusted proxy appended.
+func (l *LoginLimiter) clientIP(r *http.Request) string {
+	if l.ipHeader != "" {
+		if value := r.Header.Get(l.ipHeader); value != "" {
+			entries := strings.Split(value, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Locked reports whether any key is locked out and for how long
+func (l *LoginLimiter) Locked(keys ...string) (time.Duration, bool) {
+	if l.maxAttempts <= 0 {
+		return 0, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		if entry, ok := l.attempts[key]; ok && now.Before(entry.lockedUntil) {
+			if wait := entry.lockedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	return retryAfter, retryAfter > 0
+}
+
+// Fail records a failed attempt for every key, locking out keys that reach
+// the limit within the window
+func (l *LoginLimiter) Fail(keys ...string) {
+	if l.maxAttempts <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		entry, ok := l.attempts[key]
+		if !ok {
+			entry = &loginAttempts{}
+			l.attempts[key] = entry
+		}
+
+		// Drop failures that fell out of the window
+		recent := entry.failures[:0]
+		for _, t := range entry.failures {
+			if now.Sub(t) < l.window {
+				recent = append(recent, t)
+			}
+		}
+		entry.failures = append(recent, now)
+
+		if len(entry.failures) >= l.maxAttempts {
+			entry.lockedUntil = now.Add(l.lockout)
+			entry.failures = nil
+		}
+	}
+
+	l.pruneLocked(now)
+}
+
+// Reset clears the failures of keys after a successful login
+func (l *LoginLimiter) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.attempts, key)
+	}
+}
+
+// pruneLocked drops entries with no recent failures and no active lockout.
+// Caller must hold l.mu.
+func (l *LoginLimiter) pruneLocked(now time.Time) {
+	for key, entry := range l.attempts {
+		if now.After(entry.lockedUntil) && (len(entry.failures) == 0 || now.Sub(entry.failures[len(entry.failures)-1]) >= l.window) {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// ExecutionLimiter caps how many executions each user may start within a
+// sliding window
+type ExecutionLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	starts map[string][]time.Time
+}
+
+// NewExecutionLimiter creates a limiter. limit <= 0 disables it.
+func NewExecutionLimiter(limit int, window time.Duration) *ExecutionLimiter {
+	return &ExecutionLimiter{
+		limit:  limit,
+		window: window,
+		starts: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an execution by username if it is within the limit.
+// Otherwise it returns how long until the user may execute again.
+func (l *ExecutionLimiter) Allow(username string) (time.Duration, bool) {
+	if l.limit <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	recent := l.starts[username][:0]
+	for _, t := range l.starts[username] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.starts[username] = recent
+		return recent[0].Add(l.window).Sub(now), false
+	}
+	l.starts[username] = append(recent, now)
+	l.pruneLocked(now)
+	return 0, true
+}
+
+// pruneLocked drops users with no executions in the window. Caller must hold
+// l.mu.
+func (l *ExecutionLimiter) pruneLocked(now time.Time) {
+	for username, starts := range l.starts {
+		if len(starts) == 0 || now.Sub(starts[len(starts)-1]) >= l.window {
+			delete(l.starts, username)
+		}
+	}
+}
+
+// RequestLimiter throttles the rate of requests of each user with a token
+// bucket: requests spend a token, and tokens refill at a steady rate up to a
+// burst size
+type RequestLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens a user has left as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRequestLimiter creates a limiter allowing rate requests per second with
+// bursts of up to burst requests. rate <= 0 disables it.
+func NewRequestLimiter(rate float64, burst int) *RequestLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RequestLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow spends a token of username if one is left. Otherwise it returns how
+// long until the next token is available.
+func (l *RequestLimiter) Allow(username string) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[username]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[username] = bucket
+	}
+	bucket.tokens = l.tokensLocked(bucket, now)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	l.pruneLocked(now)
+	return 0, true
+}
+
+// tokensLocked returns the tokens in bucket at now. Caller must hold l.mu.
+func (l *RequestLimiter) tokensLocked(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+}
+
+// pruneLocked drops full buckets, which behave like new ones. Caller must
+// hold l.mu.
+func (l *RequestLimiter) pruneLocked(now time.Time) {
+	for username, bucket := range l.buckets {
+		if l.tokensLocked(bucket, now) >= l.burst {
+			delete(l.buckets, username)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiterLockout(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		failures    int
+		reset       bool // Reset the username/IP key after the failures
+		wantLocked  bool
+	}{
+		{name: "below limit", maxAttempts: 3, failures: 2},
+		{name: "at limit", maxAttempts: 3, failures: 3, wantLocked: true},
+		{name: "over limit", maxAttempts: 3, failures: 5, wantLocked: true},
+		{name: "disabled", maxAttempts: 0, failures: 10},
+		{name: "reset keeps IP key", maxAttempts: 3, failures: 3, reset: true, wantLocked: true},
+		{name: "reset below limit", maxAttempts: 3, failures: 2, reset: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLoginLimiter(tt.maxAttempts, time.Hour, time.Hour, "")
+			r := httptest.NewRequest("POST", "/api/auth/login", nil)
+			keys := l.Keys(r, "alice")
+			for i := 0; i < tt.failures; i++ {
+				l.Fail(keys...)
+			}
+			if tt.reset {
+				l.Reset(l.UserKey(r, "alice"))
+			}
+
+			retryAfter, locked := l.Locked(keys...)
+			if locked != tt.wantLocked {
+				t.Fatalf("Locked() = %v, want %v", locked, tt.wantLocked)
+			}
+			if locked && (retryAfter <= 0 || retryAfter > time.Hour) {
+				t.Errorf("retryAfter = %s, want within the lockout", retryAfter)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterWindow(t *testing.T) {
+	l := NewLoginLimiter(2, time.Minute, time.Hour, "")
+	r := httptest.NewRequest("POST", "/api/auth/login", nil)
+	keys := l.Keys(r, "alice")
+
+	l.Fail(keys...)
+	// Age the first failure out of the window
+	for _, key := range keys {
+		l.attempts[key].failures[0] = time.Now().Add(-2 * time.Minute)
+	}
+	l.Fail(keys...)
+	if _, locked := l.Locked(keys...); locked {
+		t.Fatal("failures outside the window must not count")
+	}
+
+	l.Fail(keys...)
+	if _, locked := l.Locked(keys...); !locked {
+		t.Fatal("two failures within the window must lock")
+	}
+
+	// An expired lockout no longer applies
+	for _, key := range keys {
+		l.attempts[key].lockedUntil = time.Now().Add(-time.Second)
+	}
+	if _, locked := l.Locked(keys...); locked {
+		t.Fatal("expired lockout still applies")
+	}
+}
+
+func TestLoginLimiterClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipHeader   string
+		header     string
+		remoteAddr string
+		want       string
+	}{
+		{name: "remote address", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "remote address without port", remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+		{name: "header ignored unless configured", header: "203.0.113.9", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "real ip header", ipHeader: "X-Real-IP", header: "203.0.113.9", remoteAddr: "192.0.2.1:1234", want: "203.0.113.9"},
+		{name: "last forwarded entry", ipHeader: "X-Forwarded-For", header: "198.51.100.7, 203.0.113.9", remoteAddr: "192.0.2.1:1234", want: "203.0.113.9"},
+		{name: "missing header", ipHeader: "X-Forwarded-For", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLoginLimiter(5, time.Minute, time.Minute, tt.ipHeader)
+			r := httptest.NewRequest("POST", "/api/auth/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				name := tt.ipHeader
+				if name == "" {
+					name = "X-Forwarded-For"
+				}
+				r.Header.Set(name, tt.header)
+			}
+			if got := l.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutionLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		calls       int
+		wantAllowed int
+	}{
+		{name: "within limit", limit: 3, calls: 3, wantAllowed: 3},
+		{name: "over limit", limit: 3, calls: 5, wantAllowed: 3},
+		{name: "disabled", limit: 0, calls: 5, wantAllowed: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewExecutionLimiter(tt.limit, time.Hour)
+			allowed := 0
+			for i := 0; i < tt.calls; i++ {
+				wait, ok := l.Allow("alice")
+				if ok {
+					allowed++
+				} else if wait <= 0 || wait > time.Hour {
+					t.Errorf("wait = %s, want within the window", wait)
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d executions, want %d", allowed, tt.wantAllowed)
+			}
+			// Other users have their own budget
+			if _, ok := l.Allow("bob"); !ok {
+				t.Error("another user was limited")
+			}
+		})
+	}
+}
+
+func TestExecutionLimiterWindow(t *testing.T) {
+	l := NewExecutionLimiter(1, time.Minute)
+	if _, ok := l.Allow("alice"); !ok {
+		t.Fatal("first execution was limited")
+	}
+	if _, ok := l.Allow("alice"); ok {
+		t.Fatal("second execution within the window was allowed")
+	}
+
+	l.starts["alice"][0] = time.Now().Add(-2 * time.Minute)
+	if _, ok := l.Allow("alice"); !ok {
+		t.Fatal("execution after the window was limited")
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		burst       int
+		calls       int
+		wantAllowed int
+	}{
+		{name: "within burst", rate: 1, burst: 3, calls: 3, wantAllowed: 3},
+		{name: "over burst", rate: 1, burst: 3, calls: 6, wantAllowed: 3},
+		{name: "burst of at least one", rate: 1, burst: 0, calls: 3, wantAllowed: 1},
+		{name: "disabled", rate: 0, burst: 1, calls: 5, wantAllowed: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRequestLimiter(tt.rate, tt.burst)
+			allowed := 0
+			for i := 0; i < tt.calls; i++ {
+				wait, ok := l.Allow("alice")
+				if ok {
+					allowed++
+				} else if wait <= 0 || wait > time.Second {
+					t.Errorf("wait = %s, want up to one token interval", wait)
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestRequestLimiterRefill(t *testing.T) {
+	l := NewRequestLimiter(2, 2)
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Allow("alice"); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	if _, ok := l.Allow("alice"); ok {
+		t.Fatal("request over the burst was allowed")
+	}
+
+	// Half a second refills one token at two per second
+	l.buckets["alice"].updated = time.Now().Add(-500 * time.Millisecond)
+	if _, ok := l.Allow("alice"); !ok {
+		t.Fatal("refilled token was not available")
+	}
+	if _, ok := l.Allow("alice"); ok {
+		t.Fatal("more tokens than refilled were available")
+	}
+}
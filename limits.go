@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+const (
+	// maxUsernameLength and maxPasswordLength cap user credentials
+	maxUsernameLength = 64
+	maxPasswordLength = 1024
+)
+
+// FieldLimits caps the size of fields that are stored and reloaded, so one
+// oversized request cannot bloat the stored data. Zero or less disables a
+// limit.
+type FieldLimits struct {
+	Name        int `json:"name"`        // Characters in a command name
+	Description int `json:"description"` // Characters in a command description
+	Command     int `json:"command"`     // Bytes of a command, or of all its steps together
+	Workdir     int `json:"workdir"`     // Bytes of a workdir
+	Tags        int `json:"tags"`        // Number of tags
+	Tag         int `json:"tag"`         // Characters in one tag
+}
+
+// checkLength reports whether value is longer than max characters
+func checkLength(field, value string, max int) error {
+	if max > 0 && utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%s cannot be longer than %d characters", field, max)
+	}
+	return nil
+}
+
+// checkSize reports whether value is larger than max bytes
+func checkSize(field, value string, max int) error {
+	if max > 0 && len(value) > max {
+		return fmt.Errorf("%s cannot be larger than %d bytes", field, max)
+	}
+	return nil
+}
+
+// checkTags checks the number and length of tags
+func (l FieldLimits) checkTags(tags []string) error {
+	if l.Tags > 0 && len(tags) > l.Tags {
+		return fmt.Errorf("at most %d tags are allowed", l.Tags)
+	}
+	for _, tag := range tags {
+		if err := checkLength("tags", tag, l.Tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitBodyMiddleware caps the size of request bodies. Reading past the cap
+// fails, which the JSON decoding of handlers reports as 413.
+func (app *App) limitBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if max := app.config.MaxRequestBytes; max > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondInvalidBody responds to a request body that could not be decoded
+func respondInvalidBody(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Error: fmt.Sprintf("Request body cannot be larger than %d bytes", tooLarge.Limit)})
+		return
+	}
+	respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+}
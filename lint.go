@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// lintTimeout bounds how long shellcheck may take
+const lintTimeout = 10 * time.Second
+
+// LintFinding is a single shellcheck comment
+type LintFinding struct {
+	Line      int    `json:"line"`
+	EndLine   int    `json:"end_line"`
+	Column    int    `json:"column"`
+	EndColumn int    `json:"end_column"`
+	Level     string `json:"level"` // error, warning, info or style
+	Code      int    `json:"code"`  // SC number, see https://www.shellcheck.net/wiki/
+	Message   string `json:"message"`
+}
+
+// LintRequest represents a request to lint a command that is not saved
+type LintRequest struct {
+	Command string `json:"command"`
+}
+
+// LintResponse represents the result of linting a command
+type LintResponse struct {
+	Available bool          `json:"available"`         // Whether shellcheck is installed
+	Message   string        `json:"message,omitempty"` // Set when the linter is unavailable
+	Findings  []LintFinding `json:"findings"`
+}
+
+// errLinterUnavailable is returned when shellcheck is not installed
+var errLinterUnavailable = errors.New("linter unavailable: shellcheck is not installed")
+
+// lintCommand runs shellcheck on a command, checked as a POSIX sh script
+// since commands run with sh -c
+func lintCommand(ctx context.Context, command string) ([]LintFinding, error) {
+	path, err := exec.LookPath("shellcheck")
+	if err != nil {
+		return nil, errLinterUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lintTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--format=json1", "--shell=sh", "-")
+	cmd.Stdin = strings.NewReader(command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// shellcheck exits with 1 when it has findings
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return nil, fmt.Errorf("shellcheck failed: %v %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	var result struct {
+		Comments []struct {
+			Line      int    `json:"line"`
+			EndLine   int    `json:"endLine"`
+			Column    int    `json:"column"`
+			EndColumn int    `json:"endColumn"`
+			Level     string `json:"level"`
+			Code      int    `json:"code"`
+			Message   string `json:"message"`
+		} `json:"comments"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse shellcheck output: %v", err)
+	}
+
+	findings := make([]LintFinding, 0, len(result.Comments))
+	for _, c := range result.Comments {
+		findings = append(findings, LintFinding(c))
+	}
+	return findings, nil
+}
+
+// respondLint writes the lint result for command
+func respondLint(w http.ResponseWriter, r *http.Request, command string) {
+	findings, err := lintCommand(r.Context(), command)
+	if err == errLinterUnavailable {
+		respondJSON(w, http.StatusOK, LintResponse{Message: err.Error(), Findings: []LintFinding{}})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusOK, LintResponse{Available: true, Findings: findings})
+}
+
+// LintHandler handles POST /api/lint
+func (app *App) LintHandler(w http.ResponseWriter, r *http.Request) {
+	var req LintRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "command is required"})
+		return
+	}
+
+	respondLint(w, r, req.Command)
+}
+
+// LintCommandHandler handles POST /api/commands/:id/lint. Parameter
+// placeholders are filled with their defaults before linting.
+func (app *App) LintCommandHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	saved, ok := app.commands[mux.Vars(r)["id"]]
+	var cmd Command
+	if ok {
+		cmd = *saved
+	}
+	app.mu.RUnlock()
+
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	values := make(map[string]string, len(cmd.Parameters))
+	for _, param := range cmd.Parameters {
+		values[param.Name] = param.Default
+	}
+	command, err := renderTemplate(cmd.script(), values)
+	if err != nil {
+		command = cmd.script()
+	}
+
+	respondLint(w, r, command)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestLogKey holds the *requestLog of a request in its context
+const requestLogKey contextKey = "requestLog"
+
+// redactedHeaders are replaced in logged request headers, as they carry
+// credentials
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// newLogger creates the server logger writing to w. level is debug, info,
+// warn or error; format is text or json.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, use debug, info, warn or error", level)
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, use text or json", format)
+	}
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLog collects what is logged about a request while it is handled
+type requestLog struct {
+	user string
+}
+
+// setRequestUser records the authenticated user of r for the request log
+func setRequestUser(r *http.Request, username string) {
+	if entry, ok := r.Context().Value(requestLogKey).(*requestLog); ok {
+		entry.user = username
+	}
+}
+
+// requestLogMiddleware logs every request with its status, duration and
+// authenticated user. At debug level the request headers are included, with
+// credentials redacted.
+func requestLogMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			entry := &requestLog{}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestLogKey, entry)))
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("user", entry.user),
+			}
+			if logger.Enabled(r.Context(), slog.LevelDebug) {
+				attrs = append(attrs, slog.Any("headers", redactHeaders(r.Header)))
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "Request", attrs...)
+		})
+	}
+}
+
+// redactHeaders returns a copy of header with credentials replaced
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[REDACTED]")
+		}
+	}
+	return redacted
+}
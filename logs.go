@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// logsDir holds execution output log files
+const logsDir = "logs"
+
+// tailChunkSize is how much of a log file is read at a time, from the end,
+// when only its last lines are needed
+const tailChunkSize = 64 * 1024
+
+// logStreams are the per-stream log files kept next to the combined log
+var logStreams = []string{"stdout", "stderr"}
+
+// createLog creates an output log file for an execution in the logs
+// directory under dataDir. stream is "" for the combined log, or
+// "stdout"/"stderr" for a single stream.
+func createLog(dataDir, executionID, stream string) (*os.File, error) {
+	dir := filepath.Join(dataDir, logsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(streamLogPath(filepath.Join(dir, executionID+".log"), stream))
+}
+
+// attemptLogName returns the base name of an attempt's log files. The first
+// attempt uses the execution ID, so executions without retries keep their
+// usual log names.
+func attemptLogName(executionID string, attempt int) string {
+	if attempt <= 1 {
+		return executionID
+	}
+	return fmt.Sprintf("%s.attempt-%d", executionID, attempt)
+}
+
+// streamLogPath returns the path of a stream's log file given the combined log path
+func streamLogPath(logFile, stream string) string {
+	if stream == "" {
+		return logFile
+	}
+	return strings.TrimSuffix(logFile, ".log") + "." + stream + ".log"
+}
+
+// readLog reads an execution log file. If tail is positive only the last
+// tail lines are returned, reading just the end of the file.
+func readLog(path string, tail int) (string, error) {
+	if tail <= 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Read chunks backwards until the buffer holds more than tail line breaks,
+	// which guarantees it starts at or before the first wanted line
+	var data []byte
+	newlines := 0
+	offset := info.Size()
+	for offset > 0 && newlines <= tail {
+		size := int64(tailChunkSize)
+		if size > offset {
+			size = offset
+		}
+		offset -= size
+
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return "", err
+		}
+		newlines += bytes.Count(chunk, []byte{'\n'})
+		data = append(chunk, data...)
+	}
+	return tailLines(string(data), tail), nil
+}
+
+// readLogRange reads size bytes of a log file starting at offset, or up to
+// the end of the file if size is negative
+func readLogRange(path string, offset, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	var r io.Reader = f
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// removeLog deletes an execution's combined and per-stream log files
+func removeLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	for _, stream := range append([]string{""}, logStreams...) {
+		if err := os.Remove(streamLogPath(path, stream)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeExecutionLogs deletes the log files of an execution and all of its
+// attempts
+func removeExecutionLogs(execution *Execution) {
+	removeLog(execution.LogFile)
+	for _, attempt := range execution.Attempts {
+		if attempt.LogFile != execution.LogFile {
+			removeLog(attempt.LogFile)
+		}
+	}
+}
+
+// tailLines returns the last n lines of text, or all of it if n <= 0
+func tailLines(text string, n int) string {
+	if n <= 0 {
+		return text
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) <= n {
+		return text
+	}
+	tail := strings.Join(lines[len(lines)-n:], "\n")
+	if strings.HasSuffix(text, "\n") {
+		tail += "\n"
+	}
+	return tail
+}
+
+// ansiEscape matches ANSI escape sequences such as colors and cursor movement
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+// stripANSI removes ANSI escape sequences from text
+func stripANSI(text string) string {
+	return ansiEscape.ReplaceAllString(text, "")
+}
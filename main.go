@@ -1,109 +1,306 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	// Create application
-	app := NewApp()
+	// Load configuration and create application
+	config := LoadConfig()
+	flag.StringVar(&config.DataDir, "data-dir", config.DataDir, "directory for data files and execution logs (env DEPLOYAR_DATA_DIR)")
+	flag.Parse()
+	logger, err := newLogger(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v\n", err)
+	}
+	slog.SetDefault(logger)
+	if err := config.validateTLS(); err != nil {
+		fatal("Invalid TLS configuration", "error", err)
+	}
+	// Held for the lifetime of the process, and taken before the store is
+	// opened so a second server never touches the data
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		fatal("Failed to create data directory", "error", err)
+	}
+	lock, err := lockDataDir(config.DataDir)
+	if err != nil {
+		fatal("Refusing to start", "error", err)
+	}
+	defer lock.Close()
+	if config.Store == "sqlite" {
+		dbLock, err := lockSQLite(config.sqlitePath())
+		if err != nil {
+			fatal("Refusing to start", "error", err)
+		}
+		defer dbLock.Close()
+	}
+	storage, err := NewStore(config)
+	if err != nil {
+		fatal("Failed to open store", "error", err)
+	}
+	notifier, err := LoadNotifier(config.AlertRulesFile)
+	if err != nil {
+		fatal("Failed to load alert rules", "error", err)
+	}
+	if !config.CommandRulesDisabled {
+		if config.CommandRules, err = LoadCommandRules(config.CommandRulesFile); err != nil {
+			fatal("Failed to load command rules", "error", err)
+		}
+	}
+	app := NewApp(config, storage, notifier)
 
-	// Setup router
-	router := mux.NewRouter()
+	// Starting with data that failed to load would overwrite it on the next save
+	var fileErr *StoreFileError
+	if errors.As(app.loadErr, &fileErr) {
+		fatal("Refusing to start, stored data cannot be loaded safely", "error", app.loadErr)
+	}
+
+	// Setup router, optionally mounted under BASE_PATH (e.g. /deployar)
+	basePath := config.BasePath
+	root := mux.NewRouter()
+	router := root
+	if basePath != "" {
+		root.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+		router = root.PathPrefix(basePath).Subrouter()
+	}
+
+	router.Use(app.limitBodyMiddleware)
+
+	// Prometheus metrics, gated by DEPLOYAR_METRICS_TOKEN if set
+	router.Handle("/metrics", app.PrometheusHandler()).Methods("GET")
+
+	// Health probes (no auth)
+	router.HandleFunc("/healthz", app.HealthHandler).Methods("GET")
+	router.HandleFunc("/readyz", app.ReadinessHandler).Methods("GET")
 
 	// Public auth routes (no middleware)
 	router.HandleFunc("/api/auth/setup", app.CheckSetupHandler).Methods("GET")
-	router.HandleFunc("/api/auth/setup", app.SetupHandler).Methods("POST")
-	router.HandleFunc("/api/auth/login", app.LoginHandler).Methods("POST")
+	router.Handle("/api/auth/setup", app.auditMiddleware(http.HandlerFunc(app.SetupHandler))).Methods("POST")
+	router.Handle("/api/auth/login", app.auditMiddleware(http.HandlerFunc(app.LoginHandler))).Methods("POST")
 
 	// API routes (protected with auth middleware)
 	api := router.PathPrefix("/api").Subrouter()
 	api.Use(app.AuthMiddleware)
+	api.Use(app.auditMiddleware)
+
+	// Metrics, public or protected depending on DEPLOYAR_METRICS_PUBLIC
+	if config.MetricsPublic {
+		router.HandleFunc("/api/metrics.json", app.MetricsJSONHandler).Methods("GET")
+	} else {
+		api.HandleFunc("/metrics.json", app.MetricsJSONHandler).Methods("GET")
+	}
+
+	// Audit log
+	api.HandleFunc("/audit", app.ListAuditHandler).Methods("GET")
+
+	// Runtime settings and host environment (admin only)
+	api.HandleFunc("/config", app.ConfigHandler).Methods("GET")
+	api.HandleFunc("/system/info", app.SystemInfoHandler).Methods("GET")
+
+	// Model schema
+	api.HandleFunc("/schema", app.SchemaHandler).Methods("GET")
 
 	// Auth endpoints (protected)
 	api.HandleFunc("/auth/logout", app.LogoutHandler).Methods("POST")
 	api.HandleFunc("/auth/me", app.GetCurrentUserHandler).Methods("GET")
+	api.HandleFunc("/auth/2fa/enroll", app.EnrollTwoFactorHandler).Methods("POST")
+	api.HandleFunc("/auth/2fa/verify", app.VerifyTwoFactorHandler).Methods("POST")
+	api.HandleFunc("/auth/tokens", app.CreateTokenHandler).Methods("POST")
+	api.HandleFunc("/auth/tokens", app.ListTokensHandler).Methods("GET")
+	api.HandleFunc("/auth/tokens/{id}", app.RevokeTokenHandler).Methods("DELETE")
 
 	// User management endpoints (protected)
 	api.HandleFunc("/users", app.ListUsersHandler).Methods("GET")
 	api.HandleFunc("/users", app.CreateUserHandler).Methods("POST")
+	api.HandleFunc("/users/import", app.ImportUsersHandler).Methods("POST")
 	api.HandleFunc("/users/{username}", app.DeleteUserHandler).Methods("DELETE")
+	api.HandleFunc("/users/{username}/2fa", app.ResetTwoFactorHandler).Methods("DELETE")
+
+	// Environment presets
+	api.HandleFunc("/env-presets", app.ListPresetsHandler).Methods("GET")
+	api.HandleFunc("/env-presets", app.CreatePresetHandler).Methods("POST")
+	api.HandleFunc("/env-presets/{name}", app.GetPresetHandler).Methods("GET")
+	api.HandleFunc("/env-presets/{name}", app.UpdatePresetHandler).Methods("PUT")
+	api.HandleFunc("/env-presets/{name}", app.DeletePresetHandler).Methods("DELETE")
 
 	// Execute commands
 	api.HandleFunc("/execute", app.ExecuteHandler).Methods("POST")
+	api.HandleFunc("/lint", app.LintHandler).Methods("POST")
 
 	// Command management
 	api.HandleFunc("/commands", app.CreateCommandHandler).Methods("POST")
 	api.HandleFunc("/commands", app.ListCommandsHandler).Methods("GET")
+	api.HandleFunc("/commands/export", app.ExportCommandsHandler).Methods("GET")
+	api.HandleFunc("/commands/import", app.ImportCommandsHandler).Methods("POST")
+	api.HandleFunc("/commands/validate", app.ValidateCommandHandler).Methods("POST")
 	api.HandleFunc("/commands/{id}", app.GetCommandHandler).Methods("GET")
 	api.HandleFunc("/commands/{id}", app.UpdateCommandHandler).Methods("PUT")
 	api.HandleFunc("/commands/{id}", app.DeleteCommandHandler).Methods("DELETE")
+	api.HandleFunc("/commands/{id}/restore", app.RestoreCommandHandler).Methods("POST")
+	api.HandleFunc("/commands/{id}/clone", app.CloneCommandHandler).Methods("POST")
+	api.HandleFunc("/commands/{id}/favorite", app.FavoriteCommandHandler).Methods("POST")
+	api.HandleFunc("/commands/{id}/favorite", app.UnfavoriteCommandHandler).Methods("DELETE")
+	api.HandleFunc("/commands/{id}/preview-update", app.PreviewUpdateCommandHandler).Methods("POST")
+	api.HandleFunc("/commands/{id}/history", app.CommandHistoryHandler).Methods("GET")
 	api.HandleFunc("/commands/{id}/execute", app.ExecuteCommandHandler).Methods("POST")
+	api.HandleFunc("/commands/{id}/schedule", app.GetScheduleHandler).Methods("GET")
+	api.HandleFunc("/commands/{id}/schedule", app.UpdateScheduleHandler).Methods("PUT")
+	api.HandleFunc("/commands/{id}/lint", app.LintCommandHandler).Methods("POST")
 
 	// Execution history
 	api.HandleFunc("/executions", app.ListExecutionsHandler).Methods("GET")
+	api.HandleFunc("/executions/summary", app.ExecutionSummaryHandler).Methods("GET")
+	api.HandleFunc("/stats", app.StatsHandler).Methods("GET")
+	api.HandleFunc("/executions/search", app.SearchExecutionsHandler).Methods("GET")
+	api.HandleFunc("/executions/export", app.ExportExecutionsHandler).Methods("GET")
+	api.HandleFunc("/executions/running", app.ActiveExecutionsHandler).Methods("GET")
 	api.HandleFunc("/executions/{id}", app.GetExecutionHandler).Methods("GET")
+	api.HandleFunc("/executions/{id}/output", app.GetExecutionOutputHandler).Methods("GET")
 	api.HandleFunc("/executions/{id}", app.DeleteExecutionHandler).Methods("DELETE")
+	api.HandleFunc("/executions/{id}", app.UpdateExecutionHandler).Methods("PATCH")
 	api.HandleFunc("/executions/clear", app.ClearExecutionsHandler).Methods("POST")
+	api.HandleFunc("/executions/delete", app.DeleteExecutionsHandler).Methods("POST")
+	api.HandleFunc("/executions/{id}/cancel", app.CancelExecutionHandler).Methods("POST")
+	api.HandleFunc("/executions/{id}/rerun", app.RerunExecutionHandler).Methods("POST")
+	api.HandleFunc("/executions/{id}/approve", app.ApproveExecutionHandler).Methods("POST")
+	api.HandleFunc("/executions/{id}/reject", app.RejectExecutionHandler).Methods("POST")
+	api.HandleFunc("/executions/{id}/annotations", app.ListAnnotationsHandler).Methods("GET")
+	api.HandleFunc("/executions/{id}/annotations", app.AddAnnotationHandler).Methods("POST")
 
-	// Serve static files
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static")))
+	// Live execution updates
+	api.HandleFunc("/ws/executions", app.ExecutionsWebSocketHandler).Methods("GET")
 
-	// Add CORS middleware
-	router.Use(corsMiddleware)
+	// Serve static files, falling back to index.html for client-side routes
+	router.PathPrefix("/").Handler(http.StripPrefix(basePath, staticHandler(config.StaticDir)))
+
+	// Add CORS, request logging and compression middleware
+	root.Use(requestLogMiddleware(logger))
+	root.Use(corsMiddleware(config))
+	root.Use(compressMiddleware(config.Compression, config.CompressMinBytes))
 
 	// Start server
-	port := "3029"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
-	}
+	port := config.Port
 
 	server := &http.Server{
 		Addr:    ":" + port,
-		Handler: router,
+		Handler: root,
 	}
 
-	// Graceful shutdown
+	// Graceful shutdown: stop accepting requests and scheduled runs, then
+	// give running executions until the deadline to finish
+	shutdownDone := make(chan struct{})
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 		<-sigint
 
-		log.Println("\nShutting down server...")
-		if err := server.Close(); err != nil {
-			log.Printf("Server shutdown error: %v\n", err)
+		slog.Info("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Server shutdown error", "error", err)
 		}
+		app.closeWebSockets()
+		app.scheduler.Stop()
+		app.janitor.Stop()
+		if running := app.executor.RunningCount(); running > 0 {
+			slog.Info("Waiting for running executions", "running", running)
+		}
+		app.executor.Shutdown(ctx)
+		close(shutdownDone)
 	}()
 
 	// Start listening
-	fmt.Printf("🚀 Deployar server started on http://localhost:%s\n", port)
-	fmt.Println("📁 Data stored in: commands.json, executions.json")
-	fmt.Println("Press Ctrl+C to stop")
+	scheme := "http"
+	if config.tlsEnabled() {
+		scheme = "https"
+	}
+	dataPath := config.DataDir
+	if config.Store == "sqlite" {
+		dataPath = config.sqlitePath()
+	}
+	slog.Info("Deployar server started", "url", fmt.Sprintf("%s://localhost:%s%s/", scheme, port, basePath), "store", config.Store, "data", dataPath)
+	if len(config.AllowedWorkdirs) > 0 {
+		slog.Info("Workdirs are restricted", "allowed_workdirs", config.AllowedWorkdirs)
+	} else {
+		slog.Warn("Workdirs are unrestricted (set DEPLOYAR_ALLOWED_WORKDIRS to limit them)")
+	}
+	if len(config.AdminUsers) == 0 {
+		slog.Warn("DEPLOYAR_ADMIN_USERS is not set; only the setup user and users imported as admins have admin access")
+	}
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v\n", err)
+	if err := listen(server, config); err != nil && err != http.ErrServerClosed {
+		fatal("Server error", "error", err)
 	}
+	<-shutdownDone
 }
 
-// corsMiddleware adds CORS headers
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// corsMiddleware adds CORS headers for requests from allowed origins. An
+// allowed origin is echoed back, or "*" is sent if the allowlist contains
+// "*". Other origins get no CORS headers, so browsers block them, and their
+// preflights are refused. Preflight responses may be cached by the browser
+// for CORSMaxAge.
+func corsMiddleware(config *Config) mux.MiddlewareFunc {
+	methods := strings.Join(config.CORSMethods, ", ")
+	headers := strings.Join(config.CORSHeaders, ", ")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+			allowed := ""
+			if origin != "" {
+				w.Header().Add("Vary", "Origin")
+				allowed = allowedOrigin(config.CORSOrigins, origin)
+			}
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Expose-Headers", "ETag")
+			} else if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			if r.Method == "OPTIONS" {
+				// Only preflights are cacheable, not plain OPTIONS requests
+				if preflight && config.CORSMaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it is not allowed
+func allowedOrigin(allowlist []string, origin string) string {
+	for _, allowed := range allowlist {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DurationSummary summarizes durations of completed (successful or failed)
+// executions in seconds
+type DurationSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+}
+
+// MetricsSnapshot is a point-in-time view of execution counters
+type MetricsSnapshot struct {
+	UptimeSeconds      float64         `json:"uptime_seconds"`
+	ExecutionsTotal    int             `json:"executions_total"`
+	ExecutionsByStatus map[string]int  `json:"executions_by_status"`
+	Running            int             `json:"running"`
+	Queued             int             `json:"queued"`
+	Durations          DurationSummary `json:"durations"`
+	CommandsTotal      int             `json:"commands_total"`
+	UsersTotal         int             `json:"users_total"`
+}
+
+// collectMetrics builds a metrics snapshot from the current state
+func (app *App) collectMetrics() MetricsSnapshot {
+	executions := app.executor.GetAllExecutions()
+
+	app.mu.RLock()
+	commandsTotal := 0
+	for _, cmd := range app.commands {
+		if cmd.ArchivedAt == nil {
+			commandsTotal++
+		}
+	}
+	app.mu.RUnlock()
+	app.usersMu.RLock()
+	usersTotal := len(app.users)
+	app.usersMu.RUnlock()
+
+	snapshot := MetricsSnapshot{
+		UptimeSeconds:      time.Since(app.startedAt).Seconds(),
+		ExecutionsTotal:    len(executions),
+		ExecutionsByStatus: make(map[string]int),
+		CommandsTotal:      commandsTotal,
+		UsersTotal:         usersTotal,
+	}
+
+	var durations []float64
+	for _, exec := range executions {
+		snapshot.ExecutionsByStatus[exec.Status]++
+		if exec.Status == "running" {
+			snapshot.Running++
+			continue
+		}
+		if exec.Status == "queued" {
+			snapshot.Queued++
+			continue
+		}
+		// Rejected runs never started and cancelled or interrupted runs were
+		// cut short, so none says anything about how long commands take
+		if exec.Status == "rejected" || exec.Status == "cancelled" || exec.Status == "interrupted" {
+			continue
+		}
+		if !exec.EndedAt.IsZero() {
+			durations = append(durations, exec.EndedAt.Sub(exec.StartedAt).Seconds())
+		}
+	}
+	snapshot.Durations = summarizeDurations(durations)
+
+	return snapshot
+}
+
+// summarizeDurations computes count, min, max, average and percentiles
+func summarizeDurations(durations []float64) DurationSummary {
+	if len(durations) == 0 {
+		return DurationSummary{}
+	}
+	sort.Float64s(durations)
+
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+
+	return DurationSummary{
+		Count: len(durations),
+		Min:   durations[0],
+		Max:   durations[len(durations)-1],
+		Avg:   total / float64(len(durations)),
+		P50:   percentile(durations, 0.50),
+		P95:   percentile(durations, 0.95),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	index := int(p*float64(len(sorted))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+// MetricsJSONHandler handles GET /api/metrics.json. Unless DEPLOYAR_METRICS_PUBLIC
+// is set, only admins may read it.
+func (app *App) MetricsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.MetricsPublic && !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+	respondJSON(w, http.StatusOK, app.collectMetrics())
+}
@@ -4,43 +4,294 @@ import "time"
 
 // Command represents a saved command template
 type Command struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Workdir     string    `json:"workdir"`
-	Command     string    `json:"command"`
-	Tags        []string  `json:"tags"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                 string            `json:"id" schema:"readonly"`
+	Name               string            `json:"name" schema:"required"`
+	Description        string            `json:"description"`
+	Workdir            string            `json:"workdir" schema:"required"`
+	Command            string            `json:"command"`         // Required unless the command has steps
+	Shell              string            `json:"shell,omitempty"` // Shell to run the command in (default sh), or "none" to run it as a program
+	Args               []string          `json:"args,omitempty"`  // Positional parameters in a shell, or the program's arguments
+	Env                map[string]string `json:"env,omitempty"`
+	EnvPreset          string            `json:"env_preset,omitempty"`           // Name of an environment preset merged under Env
+	SoftTimeoutSeconds int               `json:"soft_timeout_seconds,omitempty"` // Flag as slow after this many seconds
+	HardTimeoutSeconds int               `json:"hard_timeout_seconds,omitempty"` // Kill after this many seconds
+	Schedule           string            `json:"schedule,omitempty"`             // Cron expression to run the command on
+	Steps              []Step            `json:"steps,omitempty"`                // Run one after the other instead of Command
+	Stdin              string            `json:"stdin,omitempty"`                // Piped to the command's standard input
+	StdinFile          string            `json:"stdin_file,omitempty"`           // File piped to standard input instead, relative to the workdir
+	Parameters         []Parameter       `json:"parameters,omitempty"`           // Placeholders substituted into Command and Workdir
+	IsolateWorkdir     bool              `json:"isolate_workdir,omitempty"`      // Run in a temporary copy of the workdir
+	SyncBack           bool              `json:"sync_back,omitempty"`            // Copy the working copy back over the workdir on success
+	MaxRetries         int               `json:"max_retries,omitempty"`          // Re-run a failed execution up to this many times
+	RetryDelaySeconds  int               `json:"retry_delay_seconds,omitempty"`  // Wait between attempts
+
+	Host *RemoteHost `json:"host,omitempty"` // Run over SSH on this host instead of locally
+
+	FailureCooldownSeconds int        `json:"failure_cooldown_seconds,omitempty"` // Reject new runs for this long after a failed run
+	RequireReauth          bool       `json:"require_reauth,omitempty"`           // Executing requires the user's password again
+	RequireApproval        bool       `json:"require_approval,omitempty"`         // Runs wait until another admin approves them
+	SingleInstance         bool       `json:"single_instance,omitempty"`          // Refuse new runs while one is running or queued
+	ExpandWorkdir          bool       `json:"expand_workdir,omitempty"`           // Expand ~, $VAR and ${VAR} in the workdir when run
+	Tags                   []string   `json:"tags"`
+	CreatedAt              time.Time  `json:"created_at" schema:"readonly"`
+	UpdatedAt              time.Time  `json:"updated_at" schema:"readonly"`
+	ArchivedAt             *time.Time `json:"archived_at,omitempty" schema:"readonly"` // Set when deleted; archived commands can be restored
+
+	Revisions []CommandRevision `json:"revisions,omitempty" schema:"readonly"` // Previous versions, oldest first
+}
+
+// script returns the command, or the commands of its steps one per line
+func (c *Command) script() string {
+	if len(c.Steps) > 0 {
+		return stepsCommand(c.Steps)
+	}
+	return c.Command
+}
+
+// activeSchedule returns the schedule the command runs on, which is none
+// while it is archived
+func (c *Command) activeSchedule() string {
+	if c.ArchivedAt != nil {
+		return ""
+	}
+	return c.Schedule
+}
+
+// CommandResponse is a saved command with a summary of its latest run
+type CommandResponse struct {
+	*Command
+	LastExecution *ExecutionSummary `json:"last_execution"` // Null if the command never ran
+	IsFavorite    bool              `json:"is_favorite"`    // Pinned by the requesting user
+}
+
+// SaveCommandResponse is a created or updated command with warnings about
+// problems that did not prevent saving it
+type SaveCommandResponse struct {
+	*Command
+	Warnings []string `json:"warnings"`
+}
+
+// ValidateCommandResponse is the result of checking a command without
+// saving it. Fields lists the problems that would prevent saving it, as
+// creating it would report them.
+type ValidateCommandResponse struct {
+	Valid    bool         `json:"valid"`
+	Error    string       `json:"error,omitempty"` // Sums up Fields
+	Fields   []FieldError `json:"fields"`
+	Warnings []string     `json:"warnings"` // Problems that would not prevent saving
+}
+
+// CommandListResponse represents a page of commands, returned when the list
+// is requested with limit or offset
+type CommandListResponse struct {
+	Commands []CommandResponse `json:"commands"`
+	Total    int               `json:"total"`
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+}
+
+// ExecutionSummary briefly describes an execution
+type ExecutionSummary struct {
+	ID        string     `json:"id"`
+	Seq       int64      `json:"seq"`
+	Status    string     `json:"status"`
+	ExitCode  int        `json:"exit_code"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"` // Unset while running
+	Duration  string     `json:"duration,omitempty"`
+}
+
+// Parameter is a {{name}} placeholder in a saved command's command or workdir
+type Parameter struct {
+	Name     string `json:"name" schema:"required"`
+	Default  string `json:"default,omitempty"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// ExecuteCommandRequest is the optional body of a saved command execution
+type ExecuteCommandRequest struct {
+	Params          map[string]string `json:"params"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	ReauthPassword  string            `json:"reauth_password,omitempty"`  // Required for commands with require_reauth
+	TimeoutOverride int               `json:"timeout_override,omitempty"` // Hard timeout in seconds for this run, instead of the command's
+}
+
+// FieldChange describes a single changed field of a command
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// CommandDiffResponse represents the changes an update would make
+type CommandDiffResponse struct {
+	Changed bool          `json:"changed"`
+	Changes []FieldChange `json:"changes"`
 }
 
 // Execution represents a command execution record
 type Execution struct {
-	ID         string    `json:"id"`
-	CommandID  string    `json:"command_id,omitempty"` // Optional: link to saved command
-	Name       string    `json:"name"`                 // Command name (if from saved command)
-	Workdir    string    `json:"workdir"`
-	Command    string    `json:"command"`
-	Status     string    `json:"status"` // running, success, failed
-	Output     string    `json:"output"`
+	ID                  string            `json:"id"`
+	Seq                 int64             `json:"seq"`                  // Monotonic sequence number, unique across restarts
+	Rev                 int64             `json:"rev"`                  // Revision, bumped on every change
+	CommandID           string            `json:"command_id,omitempty"` // Optional: link to saved command
+	Name                string            `json:"name"`                 // Command name (if from saved command)
+	Workdir             string            `json:"workdir"`
+	Command             string            `json:"command"`
+	Shell               string            `json:"shell,omitempty"` // Empty for the default shell
+	Args                []string          `json:"args,omitempty"`
+	Env                 map[string]string `json:"env,omitempty"`              // Variable names only, values are redacted
+	Params              map[string]string `json:"params,omitempty"`           // Parameter values substituted into the command
+	Labels              map[string]string `json:"labels,omitempty"`           // Passed to the command as DEPLOYAR_LABEL_<NAME>
+	Tags                []string          `json:"tags,omitempty"`             // Copied from the saved command, or given for ad-hoc runs
+	StdinFile           string            `json:"stdin_file,omitempty"`       // File piped to standard input
+	StdinBytes          int               `json:"stdin_bytes,omitempty"`      // Size of the standard input given inline, which is not stored
+	Host                string            `json:"host,omitempty"`             // user@address of the remote host the command ran on
+	Status              string            `json:"status"`                     // pending_approval, queued, running, success, failed, cancelled, interrupted, rejected, declined
+	Output              string            `json:"output"`                     // Combined stdout and stderr, filled when fetching a single execution
+	Stdout              string            `json:"stdout"`                     // Filled when fetching a single execution
+	Stderr              string            `json:"stderr"`                     // Filled when fetching a single execution
+	OutputEncoding      string            `json:"output_encoding,omitempty"`  // "base64" when the output fields are base64 encoded
+	LogFile             string            `json:"log_file,omitempty"`         // Path of the output log file
+	LogSize             int64             `json:"log_size"`                   // Size of the output log in bytes
+	OutputBytes         int64             `json:"output_bytes"`               // Output produced by the command, including any discarded
+	Truncated           bool              `json:"truncated,omitempty"`        // Output exceeded the size cap and was cut off
+	IsolatedWorkdir     string            `json:"isolated_workdir,omitempty"` // Temporary working copy the command ran in
+	IsolationResult     string            `json:"isolation_result,omitempty"` // What happened to the working copy: synced, discarded or an error
+	ExitCode            int               `json:"exit_code"`
+	TimeoutSeconds      int               `json:"timeout_seconds,omitempty"`    // Hard timeout the execution runs with
+	TimeoutOverridden   bool              `json:"timeout_overridden,omitempty"` // TimeoutSeconds was given with the execute request
+	SoftTimeoutExceeded bool              `json:"soft_timeout_exceeded,omitempty"`
+	PID                 int               `json:"pid,omitempty"`          // OS process ID of the current or last process
+	Usage               *ResourceUsage    `json:"usage,omitempty"`        // Resources used by the processes that exited so far
+	KillReason          string            `json:"kill_reason,omitempty"`  // Why the executor killed the process
+	Attempt             int               `json:"attempt,omitempty"`      // Current attempt, for commands with retries
+	MaxAttempts         int               `json:"max_attempts,omitempty"` // 1 + MaxRetries of the command
+	Attempts            []Attempt         `json:"attempts,omitempty"`     // Finished attempts, oldest first
+	Annotations         []Annotation      `json:"annotations,omitempty"`  // Comments added over time, oldest first
+	Note                string            `json:"note,omitempty"`         // Free text set with PATCH, e.g. the incident it fixed
+	NoteUpdatedBy       string            `json:"note_updated_by,omitempty"`
+	NoteUpdatedAt       *time.Time        `json:"note_updated_at,omitempty"`
+	Steps               []StepResult      `json:"steps,omitempty"`     // Step results of the latest attempt of a multi-step command
+	RerunOf             string            `json:"rerun_of,omitempty"`  // Execution this one re-runs
+	Approval            *Approval         `json:"approval,omitempty"`  // Requester and decision, for commands that require approval
+	ExecutedBy          string            `json:"executed_by"`         // Username of executor
+	QueuedAt            *time.Time        `json:"queued_at,omitempty"` // When the execution had to wait for a free slot
+	StartedAt           time.Time         `json:"started_at"`
+	EndedAt             time.Time         `json:"ended_at,omitempty"`
+	Duration            string            `json:"duration,omitempty"`
+
+	loggedStatus string // Status last recorded in the executor's status log
+}
+
+// ResourceUsage is the CPU time and memory used by the processes of an
+// execution, summed over its steps and attempts
+type ResourceUsage struct {
+	MaxRSSKB         int64   `json:"max_rss_kb"` // Peak resident memory of the largest process
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+}
+
+// Attempt is one run of an execution that is retried on failure
+type Attempt struct {
+	Number     int       `json:"number"`
+	Status     string    `json:"status"` // success, failed or cancelled
 	ExitCode   int       `json:"exit_code"`
-	ExecutedBy string    `json:"executed_by"` // Username of executor
+	KillReason string    `json:"kill_reason,omitempty"`
+	LogFile    string    `json:"log_file"` // Output of this attempt, see GET /api/executions/{id}?attempt=
+	LogSize    int64     `json:"log_size"`
 	StartedAt  time.Time `json:"started_at"`
-	EndedAt    time.Time `json:"ended_at,omitempty"`
-	Duration   string    `json:"duration,omitempty"`
+	EndedAt    time.Time `json:"ended_at"`
+}
+
+// ExecutionListResponse represents a page of executions
+type ExecutionListResponse struct {
+	Executions []*Execution `json:"executions"`
+	Total      int          `json:"total"`
+	Limit      int          `json:"limit"`
+	Offset     int          `json:"offset"`
+	QueueDepth int          `json:"queue_depth"`           // Executions waiting for a free slot
+	Cursor     int64        `json:"cursor"`                // Pass as ?since= to get only later changes
+	Truncated  bool         `json:"truncated"`             // More matches exist; page with limit and offset
+	NextCursor string       `json:"next_cursor,omitempty"` // Pass as ?cursor= to get the following page
+}
+
+// ActiveExecutionsResponse is the body of GET /api/executions/running
+type ActiveExecutionsResponse struct {
+	Running []*Execution `json:"running"` // Oldest first
+	Queued  []*Execution `json:"queued"`  // In the order they will start
+}
+
+// DeleteExecutionsRequest selects executions to delete, either by ID or by
+// status and age
+type DeleteExecutionsRequest struct {
+	IDs       []string `json:"ids,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	OlderThan string   `json:"older_than,omitempty"` // Duration since the execution ended, e.g. "720h"
+}
+
+// DeleteExecutionsResponse reports the outcome of a bulk delete
+type DeleteExecutionsResponse struct {
+	Deleted    int                    `json:"deleted"`
+	DeletedIDs []string               `json:"deleted_ids"`
+	Failed     []DeleteExecutionError `json:"failed"`
+}
+
+// DeleteExecutionError is an execution a bulk delete could not remove
+type DeleteExecutionError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
 }
 
 // ExecuteRequest represents a request to execute a command
 type ExecuteRequest struct {
-	Workdir string `json:"workdir"`
-	Command string `json:"command"`
+	Workdir         string            `json:"workdir"`
+	Command         string            `json:"command"`
+	Shell           string            `json:"shell,omitempty"`
+	Args            []string          `json:"args,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	EnvPreset       string            `json:"env_preset,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`  // Group ad-hoc runs with saved commands' runs
+	Steps           []Step            `json:"steps,omitempty"` // Run one after the other instead of Command
+	Stdin           string            `json:"stdin,omitempty"`
+	StdinFile       string            `json:"stdin_file,omitempty"` // Relative to the workdir
+	IsolateWorkdir  bool              `json:"isolate_workdir,omitempty"`
+	SyncBack        bool              `json:"sync_back,omitempty"`
+	TimeoutOverride int               `json:"timeout_override,omitempty"` // Hard timeout in seconds for this run
 }
 
 // ExecuteResponse represents the response from executing a command
 type ExecuteResponse struct {
-	ExecutionID string `json:"execution_id"`
-	Status      string `json:"status"`
-	Message     string `json:"message"`
+	ExecutionID  string `json:"execution_id"`
+	Seq          int64  `json:"seq"`
+	Status       string `json:"status"`
+	Message      string `json:"message"`
+	ExecutionURL string `json:"execution_url"` // Execution details
+	OutputURL    string `json:"output_url"`    // Plain text output
+	FollowURL    string `json:"follow_url"`    // Long-polls for the next change to executions
+}
+
+// CommandReference is something that depends on a saved command
+type CommandReference struct {
+	Type      string `json:"type"` // schedule
+	CommandID string `json:"command_id"`
+	Name      string `json:"name"`
+	Detail    string `json:"detail,omitempty"` // e.g. the cron expression
+}
+
+// CommandInUseResponse is returned when deleting a command that is still referenced
+type CommandInUseResponse struct {
+	Error      string             `json:"error"`
+	References []CommandReference `json:"references"`
+}
+
+// EnvPreset is a named bundle of environment variables
+type EnvPreset struct {
+	Name      string            `json:"name"`
+	Env       map[string]string `json:"env"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
 }
 
 // ErrorResponse represents an error response
@@ -48,11 +299,41 @@ type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// FieldError describes a problem with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	err     error
+}
+
+// ValidationErrorResponse represents a request with invalid fields. Error
+// sums up all of them for clients that only read that.
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
 // User represents a user account
 type User struct {
 	Username  string    `json:"username"`
-	Password  string    `json:"password"` // Plain text for simplicity (NOT production ready)
+	Password  string    `json:"password"` // bcrypt hash, see hashPassword
 	CreatedAt time.Time `json:"created_at"`
+	Admin     bool      `json:"admin,omitempty"` // Admin without being listed in DEPLOYAR_ADMIN_USERS
+
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // Pending until TOTPEnabled is set
+	TOTPEnabled   bool     `json:"totp_enabled,omitempty"`   // Login requires a second factor
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // SHA-256 hashes of unused recovery codes
+
+	Favorites []string `json:"favorites,omitempty"` // IDs of commands pinned by the user
+}
+
+// UserListResponse represents a page of users, returned when the list is
+// requested with limit or offset
+type UserListResponse struct {
+	Users  []UserResponse `json:"users"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
 }
 
 // SetupRequest represents initial setup request
@@ -65,6 +346,14 @@ type SetupRequest struct {
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OTP      string `json:"otp,omitempty"` // TOTP or recovery code when 2FA is enabled
+}
+
+// LoginResponse represents a successful login with its session token
+type LoginResponse struct {
+	UserResponse
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // CreateUserRequest represents request to create new user
@@ -73,8 +362,42 @@ type CreateUserRequest struct {
 	Password string `json:"password"`
 }
 
+// ImportUserRequest is one user of a bulk import. Role is reserved: users
+// have no roles yet, so setting it is rejected.
+type ImportUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"` // admin or user (default)
+}
+
+// ImportUserResult reports the outcome of a single user in a bulk import
+type ImportUserResult struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`         // created, skipped, error
+	Role     string `json:"role,omitempty"` // Role the user was created with
+	Error    string `json:"error,omitempty"`
+}
+
+// ImportUsersResponse represents the result of a bulk user import
+type ImportUsersResponse struct {
+	Created int                `json:"created"`
+	Skipped int                `json:"skipped"`
+	Failed  int                `json:"failed"`
+	Results []ImportUserResult `json:"results"`
+}
+
 // UserResponse represents user data without password
 type UserResponse struct {
-	Username  string    `json:"username"`
-	CreatedAt time.Time `json:"created_at"`
+	Username         string    `json:"username"`
+	CreatedAt        time.Time `json:"created_at"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+}
+
+// newUserResponse builds the public view of a user
+func newUserResponse(user *User) UserResponse {
+	return UserResponse{
+		Username:         user.Username,
+		CreatedAt:        user.CreatedAt,
+		TwoFactorEnabled: user.TOTPEnabled,
+	}
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AlertChannel is a destination for alerts
+type AlertChannel struct {
+	Type string `json:"type"` // log, webhook
+	URL  string `json:"url,omitempty"`
+}
+
+// AlertRule routes finished executions to a channel based on tags and status
+type AlertRule struct {
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`     // Execution must have all of these tags; empty matches any
+	Statuses []string `json:"statuses"` // Empty matches any final status; "slow" matches soft timeouts
+	Channel  string   `json:"channel"`
+}
+
+// AlertConfig is the content of the alert rules file
+type AlertConfig struct {
+	Channels map[string]AlertChannel `json:"channels"`
+	Rules    []AlertRule             `json:"rules"`
+}
+
+// AlertPayload is the JSON body sent to webhook channels
+type AlertPayload struct {
+	Rule      string    `json:"rule"`
+	Execution Execution `json:"execution"`
+}
+
+// Notifier evaluates alert rules for finished executions
+type Notifier struct {
+	config AlertConfig
+	client *http.Client
+}
+
+// LoadNotifier reads alert rules from a JSON file. An empty path disables alerting.
+func LoadNotifier(path string) (*Notifier, error) {
+	notifier := &Notifier{client: &http.Client{Timeout: 10 * time.Second}}
+	if path == "" {
+		return notifier, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &notifier.config); err != nil {
+		return nil, fmt.Errorf("invalid alert rules file: %w", err)
+	}
+
+	for name, channel := range notifier.config.Channels {
+		switch channel.Type {
+		case "log":
+		case "webhook":
+			if channel.URL == "" {
+				return nil, fmt.Errorf("alert channel %q: webhook url is required", name)
+			}
+		default:
+			return nil, fmt.Errorf("alert channel %q: unknown type %q", name, channel.Type)
+		}
+	}
+	for _, rule := range notifier.config.Rules {
+		if _, ok := notifier.config.Channels[rule.Channel]; !ok {
+			return nil, fmt.Errorf("alert rule %q: unknown channel %q", rule.Name, rule.Channel)
+		}
+	}
+
+	return notifier, nil
+}
+
+// Notify sends the execution to the channel of every matching rule. Each
+// channel is notified at most once per execution.
+func (n *Notifier) Notify(execution Execution) {
+	notified := make(map[string]bool)
+	for _, rule := range n.config.Rules {
+		if notified[rule.Channel] || !rule.matches(&execution) {
+			continue
+		}
+		notified[rule.Channel] = true
+
+		if err := n.send(n.config.Channels[rule.Channel], rule, execution); err != nil {
+			slog.Error("Failed to send alert", "rule", rule.Name, "channel", rule.Channel, "error", err)
+		}
+	}
+}
+
+// send delivers an alert to a single channel
+func (n *Notifier) send(channel AlertChannel, rule AlertRule, execution Execution) error {
+	switch channel.Type {
+	case "log":
+		slog.Warn("ALERT", "rule", rule.Name, "execution_id", execution.ID, "command", execution.Command,
+			"status", execution.Status, "exit_code", execution.ExitCode)
+		return nil
+	case "webhook":
+		body, err := json.Marshal(AlertPayload{Rule: rule.Name, Execution: execution})
+		if err != nil {
+			return err
+		}
+		resp, err := n.client.Post(channel.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+// matches reports whether the rule applies to an execution
+func (r AlertRule) matches(execution *Execution) bool {
+	if len(r.Statuses) == 0 {
+		// Slow (soft timeout) alerts must be opted into explicitly
+		if execution.Status == "slow" {
+			return false
+		}
+	} else if !containsString(r.Statuses, execution.Status) {
+		return false
+	}
+	for _, tag := range r.Tags {
+		if !containsString(execution.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// commandTemplate parses a command or workdir template. Every declared
+// parameter is available both as {{name}} and {{.name}}.
+func commandTemplate(text string, values map[string]string) (*template.Template, error) {
+	funcs := make(template.FuncMap, len(values))
+	for name, value := range values {
+		value := value
+		funcs[name] = func() string { return value }
+	}
+	return template.New("command").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate substitutes parameter values into text
+func renderTemplate(text string, values map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := commandTemplate(text, values)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, values); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// validateParameters checks parameter declarations and that the command and
+// workdir only reference declared parameters
+func validateParameters(cmd *Command) error {
+	values := make(map[string]string, len(cmd.Parameters))
+	for _, param := range cmd.Parameters {
+		if !envKeyPattern.MatchString(param.Name) {
+			return fmt.Errorf("Invalid parameter name: %q", param.Name)
+		}
+		if _, ok := values[param.Name]; ok {
+			return fmt.Errorf("Duplicate parameter: %q", param.Name)
+		}
+		values[param.Name] = param.Default
+	}
+
+	for field, text := range map[string]string{"command": cmd.Command, "workdir": cmd.Workdir} {
+		if _, err := renderTemplate(text, values); err != nil {
+			return fmt.Errorf("Invalid %s template: %v", field, err)
+		}
+	}
+	if _, err := renderSteps(cmd.Steps, values); err != nil {
+		return fmt.Errorf("Invalid template: %v", err)
+	}
+	return nil
+}
+
+// resolveParameters merges supplied values with the declared defaults and
+// returns the command and workdir with placeholders substituted. The result
+// still has to be checked with ValidateCommand.
+func resolveParameters(cmd *Command, supplied map[string]string) (command, workdir string, values map[string]string, err error) {
+	values = make(map[string]string, len(cmd.Parameters))
+	for _, param := range cmd.Parameters {
+		value, ok := supplied[param.Name]
+		if !ok || value == "" {
+			value = param.Default
+		}
+		if param.Required && value == "" {
+			return "", "", nil, fmt.Errorf("Missing required parameter: %q", param.Name)
+		}
+		values[param.Name] = value
+	}
+	for name := range supplied {
+		if _, ok := values[name]; !ok {
+			return "", "", nil, fmt.Errorf("Unknown parameter: %q", name)
+		}
+	}
+
+	if command, err = renderTemplate(cmd.Command, values); err != nil {
+		return "", "", nil, err
+	}
+	if workdir, err = renderTemplate(cmd.Workdir, values); err != nil {
+		return "", "", nil, err
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return command, workdir, values, nil
+}
+
+// resolveExecution fills in what params runs from a saved command and the
+// supplied parameter values, substituting them into its steps as well, and
+// expands the workdir if the command asks for it. The result still has to
+// be validated.
+func resolveExecution(params *ExecuteParams, cmd *Command, supplied map[string]string) error {
+	var err error
+	params.Command, params.Workdir, params.Params, err = resolveParameters(cmd, supplied)
+	if err == nil && cmd.ExpandWorkdir {
+		params.Workdir, err = expandWorkdir(params.Workdir, params.Env)
+	}
+	if err != nil || len(cmd.Steps) == 0 {
+		return err
+	}
+	if params.Steps, err = renderSteps(cmd.Steps, params.Params); err != nil {
+		return err
+	}
+	params.Command = stepsCommand(params.Steps)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordDigest prepares a password for bcrypt, which ignores everything
+// past 72 bytes while passwords may be up to maxPasswordLength long
+func passwordDigest(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// hashPassword returns the bcrypt hash a password is stored as
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(passwordDigest(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the stored hash
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), passwordDigest(password)) == nil
+}
+
+// hashPlainPasswords hashes the passwords that older versions stored in
+// plain text, reporting whether any user was changed
+func hashPlainPasswords(users map[string]*User) (bool, error) {
+	changed := false
+	for _, user := range users {
+		if _, err := bcrypt.Cost([]byte(user.Password)); err == nil {
+			continue
+		}
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			return changed, err
+		}
+		user.Password = hash
+		changed = true
+	}
+	return changed, nil
+}
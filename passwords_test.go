@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		want     bool
+	}{
+		{name: "match", stored: "Secret123", password: "Secret123", want: true},
+		{name: "wrong password", stored: "Secret123", password: "Secret124"},
+		{name: "case matters", stored: "Secret123", password: "secret123"},
+		{name: "empty", stored: "Secret123", password: ""},
+		{name: "long password", stored: long, password: long, want: true},
+		// bcrypt alone would ignore everything after 72 bytes
+		{name: "long password differing at the end", stored: long, password: long[:99] + "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := hashPassword(tt.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(hash, tt.stored) {
+				t.Fatal("hash contains the password")
+			}
+			if got := checkPassword(hash, tt.password); got != tt.want {
+				t.Errorf("checkPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPlainPasswords(t *testing.T) {
+	hashed, err := hashPassword("Secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]*User{
+		"alice": {Username: "alice", Password: "Plain456"},
+		"bob":   {Username: "bob", Password: hashed},
+	}
+
+	changed, err := hashPlainPasswords(users)
+	if err != nil || !changed {
+		t.Fatalf("hashPlainPasswords() = %v, %v, want true", changed, err)
+	}
+	if !checkPassword(users["alice"].Password, "Plain456") {
+		t.Error("plain text password was not hashed")
+	}
+	if users["bob"].Password != hashed {
+		t.Error("hashed password was hashed again")
+	}
+
+	if changed, err := hashPlainPasswords(users); err != nil || changed {
+		t.Errorf("second run = %v, %v, want nothing to change", changed, err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// presetNamePattern matches valid environment preset names
+var presetNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// EnvPresetRequest represents a request to create or update a preset
+type EnvPresetRequest struct {
+	Name string            `json:"name"`
+	Env  map[string]string `json:"env"`
+}
+
+// presetResponse returns a preset with its values redacted. Preset values are
+// write-only and never returned by the API.
+func presetResponse(preset *EnvPreset) EnvPreset {
+	redacted := *preset
+	redacted.Env = redactEnv(preset.Env)
+	if redacted.Env == nil {
+		redacted.Env = map[string]string{}
+	}
+	return redacted
+}
+
+// resolveEnvLocked merges the named preset with env, env taking precedence.
+// Caller must hold app.mu.
+func (app *App) resolveEnvLocked(presetName string, env map[string]string) (map[string]string, error) {
+	if presetName == "" {
+		return env, nil
+	}
+
+	preset, ok := app.presets[presetName]
+	if !ok {
+		return nil, fmt.Errorf("environment preset not found: %q", presetName)
+	}
+
+	merged := make(map[string]string, len(preset.Env)+len(env))
+	for key, value := range preset.Env {
+		merged[key] = value
+	}
+	for key, value := range env {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// ListPresetsHandler handles GET /api/env-presets
+func (app *App) ListPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	presets := make([]EnvPreset, 0, len(app.presets))
+	for _, preset := range app.presets {
+		presets = append(presets, presetResponse(preset))
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+
+	respondJSON(w, http.StatusOK, presets)
+}
+
+// GetPresetHandler handles GET /api/env-presets/:name
+func (app *App) GetPresetHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	preset, ok := app.presets[name]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Environment preset not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presetResponse(preset))
+}
+
+// CreatePresetHandler handles POST /api/env-presets
+func (app *App) CreatePresetHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var req EnvPresetRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Validate
+	if !presetNamePattern.MatchString(req.Name) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Preset name may only contain letters, digits, '-' and '_'"})
+		return
+	}
+	if err := ValidateEnv(req.Env); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if _, exists := app.presets[req.Name]; exists {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Environment preset already exists"})
+		return
+	}
+
+	preset := &EnvPreset{
+		Name:      req.Name,
+		Env:       req.Env,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	app.presets[preset.Name] = preset
+	if err := app.storage.SavePresets(app.presets); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save environment preset"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, presetResponse(preset))
+}
+
+// UpdatePresetHandler handles PUT /api/env-presets/:name
+//
+// The body replaces the preset's variables. Since values are write-only, a
+// value of "********" keeps the variable's current value.
+func (app *App) UpdatePresetHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	existing, ok := app.presets[name]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Environment preset not found"})
+		return
+	}
+
+	var req EnvPresetRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateEnv(req.Env); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	env := make(map[string]string, len(req.Env))
+	for key, value := range req.Env {
+		if current, ok := existing.Env[key]; ok && value == redactedValue {
+			value = current
+		}
+		env[key] = value
+	}
+
+	existing.Env = env
+	existing.UpdatedAt = time.Now()
+
+	if err := app.storage.SavePresets(app.presets); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update environment preset"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presetResponse(existing))
+}
+
+// DeletePresetHandler handles DELETE /api/env-presets/:name
+func (app *App) DeletePresetHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if _, ok := app.presets[name]; !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Environment preset not found"})
+		return
+	}
+
+	// Refuse to delete presets that saved commands still reference
+	for _, cmd := range app.commands {
+		if cmd.EnvPreset == name {
+			respondJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("Environment preset is used by command %q", cmd.Name)})
+			return
+		}
+	}
+
+	delete(app.presets, name)
+	if err := app.storage.SavePresets(app.presets); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete environment preset"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Environment preset deleted successfully"})
+}
+
+// validatePresetRefLocked checks that a referenced preset exists. Caller must
+// hold app.mu.
+func (app *App) validatePresetRefLocked(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := app.presets[name]; !ok {
+		return errors.New("Environment preset not found")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PromMetrics holds the Prometheus collectors updated by the executor
+type PromMetrics struct {
+	registry   *prometheus.Registry
+	started    prometheus.Counter
+	executions *prometheus.CounterVec
+	duration   prometheus.Histogram
+}
+
+// NewPromMetrics creates and registers the execution collectors. running and
+// queued report the number of running and queued executions when scraped.
+func NewPromMetrics(running, queued func() int) *PromMetrics {
+	m := &PromMetrics{
+		registry: prometheus.NewRegistry(),
+		started: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "deployar_executions_started_total",
+			Help: "Number of executions started.",
+		}),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "deployar_executions_total",
+			Help: "Number of finished executions by final status.",
+		}, []string{"status"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "deployar_execution_duration_seconds",
+			Help:    "Duration of successful and failed executions.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.started,
+		m.executions,
+		m.duration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "deployar_executions_running",
+			Help: "Number of currently running executions.",
+		}, func() float64 { return float64(running()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "deployar_executions_queued",
+			Help: "Number of executions waiting for a free slot.",
+		}, func() float64 { return float64(queued()) }),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Started records a started execution
+func (m *PromMetrics) Started() {
+	m.started.Inc()
+}
+
+// Finished records the final status of an execution. Only completed runs
+// are added to the duration histogram.
+func (m *PromMetrics) Finished(execution *Execution) {
+	m.executions.WithLabelValues(execution.Status).Inc()
+	if execution.Status == "success" || execution.Status == "failed" {
+		m.duration.Observe(execution.EndedAt.Sub(execution.StartedAt).Seconds())
+	}
+}
+
+// PrometheusHandler handles GET /metrics. If DEPLOYAR_METRICS_TOKEN is set the
+// scraper must send it as a Bearer token.
+func (app *App) PrometheusHandler() http.Handler {
+	handler := promhttp.HandlerFor(app.executor.prom.registry, promhttp.HandlerOpts{})
+	token := app.config.MetricsToken
+	if token == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := parseBearerToken(r.Header.Get("Authorization"))
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			respondJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid metrics token"})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
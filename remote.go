@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshConnectTimeout bounds connecting and authenticating to a remote host
+const sshConnectTimeout = 15 * time.Second
+
+// errRemoteStopped is returned when a remote process is stopped before it
+// could be started
+var errRemoteStopped = errors.New("stopped before connecting")
+
+// RemoteHost is a host a command runs on over SSH instead of locally
+type RemoteHost struct {
+	Address        string `json:"address"`                    // host or host:port, port 22 by default
+	User           string `json:"user"`                       // Login user
+	KeyPath        string `json:"key_path"`                   // Private key file on the Deployar server
+	KnownHostsPath string `json:"known_hosts_path,omitempty"` // Defaults to ~/.ssh/known_hosts of the server's user
+}
+
+// String returns the host as user@address
+func (h *RemoteHost) String() string {
+	return h.User + "@" + h.Address
+}
+
+// addr returns the address to dial, with the default port if none is given
+func (h *RemoteHost) addr() string {
+	if _, _, err := net.SplitHostPort(h.Address); err == nil {
+		return h.Address
+	}
+	return net.JoinHostPort(h.Address, "22")
+}
+
+// knownHostsPath returns the known hosts file the host key is checked against
+func (h *RemoteHost) knownHostsPath() (string, error) {
+	if h.KnownHostsPath != "" {
+		return h.KnownHostsPath, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// validateRemoteHost checks the connection details of a remote host, if any
+func validateRemoteHost(host *RemoteHost) error {
+	if host == nil {
+		return nil
+	}
+	if strings.TrimSpace(host.Address) == "" {
+		return errors.New("host address is required")
+	}
+	if strings.TrimSpace(host.User) == "" {
+		return errors.New("host user is required")
+	}
+	if !filepath.IsAbs(host.KeyPath) {
+		return errors.New("host key_path must be an absolute path")
+	}
+	if host.KnownHostsPath != "" && !filepath.IsAbs(host.KnownHostsPath) {
+		return errors.New("host known_hosts_path must be an absolute path")
+	}
+	return nil
+}
+
+// sshClientConfig builds the client configuration for host, authenticating
+// with its key and verifying its host key against the known hosts file
+func sshClientConfig(host *RemoteHost) (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(host.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+	knownHosts, err := host.knownHostsPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find known hosts file: %w", err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	return &ssh.ClientConfig{
+		User:            host.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	}, nil
+}
+
+// remoteProcess runs a command on a remote host over SSH. Start only kicks
+// off connecting, so it is as quick as starting a local process and can be
+// called with the executor lock held; connection errors are returned by
+// Wait.
+type remoteProcess struct {
+	host    *RemoteHost
+	command string
+	shell   string
+	args    []string
+	env     map[string]string // Exported before the command runs
+
+	mu      sync.Mutex
+	client  *ssh.Client // Set once connected
+	session *ssh.Session
+	stopped bool // Terminated; no new connection is made
+	done    chan struct{}
+	err     error
+}
+
+// newRemoteProcess builds the remote process of a command. Labels are passed
+// as DEPLOYAR_LABEL_<NAME> like for local processes.
+func newRemoteProcess(host *RemoteHost, command, shell string, args []string, env, labels map[string]string) *remoteProcess {
+	vars := make(map[string]string, len(env)+len(labels))
+	for key, value := range env {
+		vars[key] = value
+	}
+	for key, value := range labels {
+		vars[labelEnvName(key)] = value
+	}
+	return &remoteProcess{host: host, command: command, shell: shell, args: args, env: vars}
+}
+
+// remoteScript builds the script that runs a command in dir on a remote host
+// the way shellCommand runs it locally, with env exported
+func remoteScript(dir, command, shell string, args []string, env map[string]string) string {
+	var script strings.Builder
+	script.WriteString("cd " + shellQuote(dir) + " || exit 1\n")
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		script.WriteString("export " + key + "=" + shellQuote(env[key]) + "\n")
+	}
+
+	var argv []string
+	switch shell {
+	case directExec:
+		argv = append([]string{command}, args...)
+	case "":
+		shell = defaultShell
+		fallthrough
+	default:
+		argv = []string{shell, "-c", command}
+		if len(args) > 0 {
+			argv = append(append(argv, shell), args...)
+		}
+	}
+	script.WriteString("exec")
+	for _, arg := range argv {
+		script.WriteString(" " + shellQuote(arg))
+	}
+	return script.String()
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Start connects to the host in the background and runs the command in the
+// directory of cmd, wired to its standard streams
+func (p *remoteProcess) Start(cmd *exec.Cmd) {
+	script := remoteScript(cmd.Dir, p.command, p.shell, p.args, p.env)
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		p.err = p.run(script, cmd.Stdin, cmd.Stdout, cmd.Stderr)
+	}()
+}
+
+// run connects, runs script and waits for it to exit
+func (p *remoteProcess) run(script string, stdin io.Reader, stdout, stderr io.Writer) error {
+	config, err := sshClientConfig(p.host)
+	if err != nil {
+		return err
+	}
+	client, err := ssh.Dial("tcp", p.host.addr(), config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", p.host, err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return errRemoteStopped
+	}
+	p.client, p.session = client, session
+	p.mu.Unlock()
+
+	if err := session.Start(script); err != nil {
+		return fmt.Errorf("failed to start remote command: %w", err)
+	}
+	return session.Wait()
+}
+
+// Wait waits for the remote command to exit. A non-zero exit status is
+// returned as *ssh.ExitError.
+func (p *remoteProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// terminate sends SIGTERM to the remote command and drops the connection if
+// it is still running after the grace period. Servers that do not support
+// signals only see the connection close, and commands that ignore it may
+// keep running on the host.
+func (p *remoteProcess) terminate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	if p.session == nil {
+		return
+	}
+	p.session.Signal(ssh.SIGTERM)
+	client := p.client
+	go func() {
+		select {
+		case <-p.done:
+		case <-time.After(killGracePeriod):
+			client.Close()
+		}
+	}()
+}
+
+// localShell returns the shell to look up on this server before running a
+// command. The shells and programs of remote commands are only found on
+// their host, so they are not checked.
+func localShell(shell string, host *RemoteHost) string {
+	if host != nil {
+		return ""
+	}
+	return shell
+}
+
+// validateLocalWorkdirs checks that the workdirs of params exist, unless
+// they run on a remote host
+func validateLocalWorkdirs(params ExecuteParams) error {
+	if params.Host != nil {
+		return nil
+	}
+	if err := ValidateWorkdirExists(params.Workdir); err != nil {
+		return err
+	}
+	return ValidateStepWorkdirs(params.Workdir, params.Steps)
+}
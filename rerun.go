@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RerunRequest represents a request to re-run an execution
+type RerunRequest struct {
+	ReauthPassword string `json:"reauth_password,omitempty"` // Required if the saved command requires it
+}
+
+// rerunParams rebuilds the parameters of a finished execution. The workdir,
+// command, steps, parameter values and labels are taken from the record as
+// they were run. Environment values are not stored, so runs of saved
+// commands use the command's current environment and settings, and ad-hoc
+// runs with environment variables cannot be re-run.
+func (app *App) rerunParams(execution *Execution, username string) (ExecuteParams, *Command, error) {
+	params := ExecuteParams{
+		Workdir:     execution.Workdir,
+		Command:     execution.Command,
+		Shell:       execution.Shell,
+		Args:        execution.Args,
+		Tags:        execution.Tags,
+		CommandID:   execution.CommandID,
+		CommandName: execution.Name,
+		Params:      execution.Params,
+		Labels:      execution.Labels,
+		Username:    username,
+		RerunOf:     execution.ID,
+		StdinFile:   execution.StdinFile,
+	}
+	for _, step := range execution.Steps {
+		params.Steps = append(params.Steps, step.Step)
+	}
+
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	cmd, ok := app.commands[execution.CommandID]
+	if !ok {
+		if len(execution.Env) > 0 {
+			return params, nil, errors.New("Environment values of the execution are not stored, so it cannot be re-run")
+		}
+		if execution.Host != "" {
+			return params, nil, errors.New("Connection details of the execution's host are not stored, so it cannot be re-run")
+		}
+		if execution.StdinBytes > 0 {
+			return params, nil, errors.New("Standard input of the execution is not stored, so it cannot be re-run")
+		}
+		// Never sync an ad-hoc working copy back, the record does not say
+		// whether the original run did
+		params.IsolateWorkdir = execution.IsolatedWorkdir != ""
+		return params, nil, nil
+	}
+	if cmd.ArchivedAt != nil {
+		return params, nil, errors.New("Command is archived, restore it to run it")
+	}
+
+	snapshot := *cmd
+	env, err := app.resolveEnvLocked(cmd.EnvPreset, cmd.Env)
+	if err != nil {
+		return params, nil, err
+	}
+	saved := savedCommandParams(&snapshot, env, username)
+	params.Env = saved.Env
+	params.Stdin = saved.Stdin
+	params.StdinFile = saved.StdinFile
+	params.Host = saved.Host
+	params.SoftTimeout = saved.SoftTimeout
+	params.HardTimeout = saved.HardTimeout
+	params.IsolateWorkdir = saved.IsolateWorkdir
+	params.SyncBack = saved.SyncBack
+	params.SingleInstance = saved.SingleInstance
+	params.MaxRetries = saved.MaxRetries
+	params.RetryDelay = saved.RetryDelay
+	return params, &snapshot, nil
+}
+
+// validateRerunParams validates parameters rebuilt from an execution record
+// against the current rules
+func (app *App) validateRerunParams(params ExecuteParams) error {
+	err := ValidateCommand(params.Workdir, params.Command, app.config.AllowedWorkdirs, app.config.Limits, app.config.CommandRules)
+	if err == nil {
+		err = ValidateSteps(params.Workdir, localShell(params.Shell, params.Host), params.Args, params.Steps, app.config.AllowedWorkdirs)
+	}
+	if err == nil {
+		err = ValidateStdin(params.Workdir, params.Stdin, params.StdinFile, app.config.AllowedWorkdirs)
+	}
+	if err == nil && len(params.Steps) == 0 {
+		err = ValidateShell(localShell(params.Shell, params.Host), params.Command, params.Args)
+	}
+	if err == nil {
+		err = validateLocalWorkdirs(params)
+	}
+	return err
+}
+
+// RerunExecutionHandler handles POST /api/executions/:id/rerun, running a
+// finished execution again with the same workdir, command and parameters
+func (app *App) RerunExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.throttleExecuteRequest(w, r) {
+		return
+	}
+
+	original, ok := app.executor.GetExecution(mux.Vars(r)["id"])
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Execution not found"})
+		return
+	}
+	if original.Status == "queued" || original.Status == "running" {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is still running"})
+		return
+	}
+	if original.Status == pendingApprovalStatus {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Execution is waiting for approval"})
+		return
+	}
+
+	params, cmd, err := app.rerunParams(original, currentUsername(r))
+	if err != nil {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if cmd != nil {
+		var req RerunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondInvalidBody(w, err)
+			return
+		}
+		if cmd.RequireReauth && !app.verifyReauth(w, r, req.ReauthPassword) {
+			return
+		}
+
+		if remaining := app.failureCooldown(cmd); remaining > 0 {
+			message := fmt.Sprintf("Command failed recently, try again in %s", remaining.Round(time.Second))
+			app.executor.RecordRejected(params, message)
+			w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+			respondJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: message})
+			return
+		}
+	}
+
+	// The original may have run under rules that have changed since
+	if err := app.validateRerunParams(params); err != nil {
+		app.executor.RecordRejected(params, err.Error())
+		respondJSON(w, validationStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if !app.allowExecution(w, params) {
+		return
+	}
+
+	var execution *Execution
+	if cmd != nil {
+		execution, err = app.executeSaved(params, cmd)
+	} else {
+		execution, err = app.executor.Execute(params)
+	}
+	if err != nil {
+		respondExecuteError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, app.executeResponse(execution))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Janitor prunes the execution history on an interval according to the
+// retention policy
+type Janitor struct {
+	executor       *Executor
+	maxAge         time.Duration // Zero keeps executions regardless of age
+	keepPerCommand int           // Zero keeps every run of a command
+	interval       time.Duration
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// NewJanitor creates a janitor applying the retention settings of config
+func NewJanitor(executor *Executor, config *Config) *Janitor {
+	return &Janitor{
+		executor:       executor,
+		maxAge:         config.ExecutionRetention,
+		keepPerCommand: config.KeepExecutionsPerCommand,
+		interval:       config.RetentionInterval,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// Start prunes the history once and then on every interval in the
+// background. It does nothing if no retention limit is set.
+func (j *Janitor) Start() {
+	if j.maxAge <= 0 && j.keepPerCommand <= 0 {
+		close(j.done)
+		return
+	}
+
+	j.prune()
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.prune()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops pruning and waits for a pass in progress to finish
+func (j *Janitor) Stop() {
+	close(j.stop)
+	<-j.done
+}
+
+// prune runs one pass of the retention policy
+func (j *Janitor) prune() {
+	if removed := j.executor.PruneExecutions(j.maxAge, j.keepPerCommand); removed > 0 {
+		slog.Info("Retention removed executions", "removed", removed)
+	}
+}
+
+// PruneExecutions removes finished executions that ended more than maxAge
+// ago, and all but the keepPerCommand most recent runs of each saved
+// command, along with their log files. Zero disables either limit. Rejected
+// records are capped separately and do not count towards keepPerCommand. It
+// returns how many executions were removed.
+func (e *Executor) PruneExecutions(maxAge time.Duration, keepPerCommand int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	expired := make(map[string]*Execution)
+	runs := make(map[string][]*Execution)
+	for id, exec := range e.executions {
+		if exec.Status == "queued" || exec.Status == "running" || exec.Status == pendingApprovalStatus {
+			continue
+		}
+		ended := exec.EndedAt
+		if ended.IsZero() {
+			ended = exec.StartedAt
+		}
+		if maxAge > 0 && now.Sub(ended) > maxAge {
+			expired[id] = exec
+			continue
+		}
+		if exec.CommandID != "" && exec.Status != "rejected" {
+			runs[exec.CommandID] = append(runs[exec.CommandID], exec)
+		}
+	}
+
+	if keepPerCommand > 0 {
+		for _, list := range runs {
+			if len(list) <= keepPerCommand {
+				continue
+			}
+			sort.Slice(list, func(i, j int) bool {
+				return newerExecution(list[i], list[j])
+			})
+			for _, exec := range list[keepPerCommand:] {
+				expired[exec.ID] = exec
+			}
+		}
+	}
+
+	if len(expired) == 0 {
+		return 0
+	}
+	for id, exec := range expired {
+		removeExecutionLogs(exec)
+		delete(e.executions, id)
+	}
+	for _, exec := range expired {
+		e.unindexLastRunLocked(exec)
+	}
+	e.version++
+	e.saveLocked()
+	return len(expired)
+}
+
+// evictOverCapLocked removes the oldest finished executions by start time,
+// along with their log files, until no more than MaxExecutions are stored.
+// Executions that are queued, running or waiting for approval are kept. It
+// returns how many executions were removed. Caller must hold e.mu and save.
+func (e *Executor) evictOverCapLocked() int {
+	limit := e.config.MaxExecutions
+	if limit <= 0 || len(e.executions) <= limit {
+		return 0
+	}
+
+	var finished []*Execution
+	for _, exec := range e.executions {
+		if exec.Status == "queued" || exec.Status == "running" || exec.Status == pendingApprovalStatus {
+			continue
+		}
+		finished = append(finished, exec)
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		a, b := finished[i], finished[j]
+		if !a.StartedAt.Equal(b.StartedAt) {
+			return a.StartedAt.Before(b.StartedAt)
+		}
+		return a.Seq < b.Seq
+	})
+	excess := min(len(e.executions)-limit, len(finished))
+	for _, exec := range finished[:excess] {
+		removeExecutionLogs(exec)
+		delete(e.executions, exec.ID)
+	}
+	for _, exec := range finished[:excess] {
+		e.unindexLastRunLocked(exec)
+	}
+	if excess > 0 {
+		e.version++
+		slog.Info("Removed the oldest executions over the cap", "removed", excess, "max", limit)
+	}
+	return excess
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+)
+
+// schedulerUsername is recorded as ExecutedBy for scheduled runs
+const schedulerUsername = "scheduler"
+
+// Scheduler runs saved commands on cron schedules
+type Scheduler struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[string]cron.EntryID // Command ID -> cron entry
+	run     func(commandID string)
+}
+
+// NewScheduler creates a scheduler that calls run for every due command
+func NewScheduler(run func(commandID string)) *Scheduler {
+	return &Scheduler{
+		cron:    cron.New(),
+		entries: make(map[string]cron.EntryID),
+		run:     run,
+	}
+}
+
+// Start starts running scheduled commands in the background
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops scheduling runs and waits for runs being started to return
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+// Set (re)schedules a command. An empty schedule removes it.
+func (s *Scheduler) Set(commandID, schedule string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.entries[commandID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, commandID)
+	}
+	if schedule == "" {
+		return nil
+	}
+
+	id, err := s.cron.AddFunc(schedule, func() { s.run(commandID) })
+	if err != nil {
+		return err
+	}
+	s.entries[commandID] = id
+	return nil
+}
+
+// Remove unschedules a command
+func (s *Scheduler) Remove(commandID string) {
+	s.Set(commandID, "")
+}
+
+// Next returns the next time a command is due to run
+func (s *Scheduler) Next(commandID string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.entries[commandID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return s.cron.Entry(id).Next, true
+}
+
+// validateSchedule checks a cron expression
+func validateSchedule(schedule string) error {
+	if schedule == "" {
+		return nil
+	}
+	if _, err := cron.ParseStandard(schedule); err != nil {
+		return fmt.Errorf("Invalid schedule: %v", err)
+	}
+	return nil
+}
+
+// runScheduledCommand executes a saved command on behalf of the scheduler.
+// The run is skipped if the command is still running from a previous run.
+func (app *App) runScheduledCommand(commandID string) {
+	app.mu.RLock()
+	saved, ok := app.commands[commandID]
+	if !ok || saved.ArchivedAt != nil {
+		app.mu.RUnlock()
+		return
+	}
+	cmd := *saved
+	env, err := app.resolveEnvLocked(cmd.EnvPreset, cmd.Env)
+	app.mu.RUnlock()
+
+	if app.executor.IsCommandRunning(cmd.ID) {
+		slog.Info("Skipping scheduled run, previous run is still running", "command", cmd.Name)
+		return
+	}
+	if err != nil {
+		slog.Error("Scheduled run failed", "command", cmd.Name, "error", err)
+		return
+	}
+	if remaining := app.failureCooldown(&cmd); remaining > 0 {
+		slog.Info("Skipping scheduled run, failed recently", "command", cmd.Name, "cooldown", remaining.Round(time.Second))
+		return
+	}
+
+	// Scheduled runs use the parameter defaults
+	params := savedCommandParams(&cmd, env, schedulerUsername)
+	err = resolveExecution(&params, &cmd, nil)
+	if err == nil {
+		err = ValidateCommand(params.Workdir, params.Command, app.config.AllowedWorkdirs, app.config.Limits, app.config.CommandRules)
+	}
+	if err == nil {
+		err = ValidateSteps(params.Workdir, localShell(params.Shell, params.Host), params.Args, params.Steps, app.config.AllowedWorkdirs)
+	}
+	if err == nil {
+		err = ValidateStdin(params.Workdir, params.Stdin, params.StdinFile, app.config.AllowedWorkdirs)
+	}
+	if err == nil {
+		err = validateLocalWorkdirs(params)
+	}
+	if err != nil {
+		slog.Error("Scheduled run failed", "command", cmd.Name, "error", err)
+		return
+	}
+
+	if _, err := app.executeSaved(params, &cmd); err != nil {
+		slog.Error("Scheduled run failed", "command", cmd.Name, "error", err)
+	}
+}
+
+// ScheduleRequest represents a request to set a command's schedule
+type ScheduleRequest struct {
+	Schedule string `json:"schedule"` // Empty removes the schedule
+}
+
+// ScheduleResponse describes a command's schedule
+type ScheduleResponse struct {
+	CommandID string     `json:"command_id"`
+	Schedule  string     `json:"schedule"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+}
+
+// scheduleResponse builds the schedule response for a command
+func (app *App) scheduleResponse(cmd *Command) ScheduleResponse {
+	resp := ScheduleResponse{CommandID: cmd.ID, Schedule: cmd.Schedule}
+	if next, ok := app.scheduler.Next(cmd.ID); ok {
+		resp.NextRun = &next
+	}
+	return resp
+}
+
+// GetScheduleHandler handles GET /api/commands/:id/schedule
+func (app *App) GetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	cmd, ok := app.commands[id]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, app.scheduleResponse(cmd))
+}
+
+// UpdateScheduleHandler handles PUT /api/commands/:id/schedule
+func (app *App) UpdateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	cmd, ok := app.commands[id]
+	if !ok {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Command not found"})
+		return
+	}
+
+	var req ScheduleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateSchedule(req.Schedule); err != nil {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	old := *cmd
+	cmd.Schedule = req.Schedule
+	cmd.UpdatedAt = time.Now()
+	recordRevision(&old, cmd, currentUsername(r), app.config.CommandRevisions)
+
+	app.commandsChanged()
+	if err := app.storage.SaveCommands(app.commands); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update schedule"})
+		return
+	}
+	app.scheduler.Set(cmd.ID, cmd.activeSchedule())
+
+	respondJSON(w, http.StatusOK, app.scheduleResponse(cmd))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaType describes a JSON value
+type SchemaType struct {
+	Type   string         `json:"type"`             // string, integer, number, boolean, array, object
+	Format string         `json:"format,omitempty"` // date-time for timestamps
+	Items  *SchemaType    `json:"items,omitempty"`  // Element type of arrays and values of maps
+	Fields []*SchemaField `json:"fields,omitempty"` // Fields of nested objects
+}
+
+// SchemaField describes one JSON field of a model
+type SchemaField struct {
+	Name string `json:"name"`
+	SchemaType
+	Required bool `json:"required"`
+	Editable bool `json:"editable"`
+}
+
+// ModelSchema describes the fields of a model
+type ModelSchema struct {
+	Fields []*SchemaField `json:"fields"`
+}
+
+// SchemaResponse is the body of GET /api/schema
+type SchemaResponse struct {
+	Command   ModelSchema `json:"command"`
+	Execution ModelSchema `json:"execution"`
+}
+
+// timeType is handled as a string rather than a struct
+var timeType = reflect.TypeOf(time.Time{})
+
+// describeModel lists the JSON fields of a struct type. Fields tagged
+// schema:"required" are required and schema:"readonly" are not editable;
+// editable is false for every field of read-only models.
+func describeModel(t reflect.Type, editable bool) []*SchemaField {
+	fields := make([]*SchemaField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// Embedded structs are flattened into the JSON object
+			fields = append(fields, describeModel(field.Type, editable)...)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, &SchemaField{
+			Name:       name,
+			SchemaType: *describeType(field.Type, editable),
+			Required:   field.Tag.Get("schema") == "required",
+			Editable:   editable && field.Tag.Get("schema") != "readonly",
+		})
+	}
+	return fields
+}
+
+// describeType maps a Go type to its JSON schema type
+func describeType(t reflect.Type, editable bool) *SchemaType {
+	if t == timeType {
+		return &SchemaType{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &SchemaType{Type: "string"}
+	case reflect.Bool:
+		return &SchemaType{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &SchemaType{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &SchemaType{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &SchemaType{Type: "array", Items: describeType(t.Elem(), editable)}
+	case reflect.Map:
+		return &SchemaType{Type: "object", Items: describeType(t.Elem(), editable)}
+	case reflect.Struct:
+		return &SchemaType{Type: "object", Fields: describeModel(t, editable)}
+	case reflect.Pointer:
+		return describeType(t.Elem(), editable)
+	}
+	return &SchemaType{Type: "object"}
+}
+
+// SchemaHandler handles GET /api/schema
+func (app *App) SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, SchemaResponse{
+		Command:   ModelSchema{Fields: describeModel(reflect.TypeOf(Command{}), true)},
+		Execution: ModelSchema{Fields: describeModel(reflect.TypeOf(Execution{}), false)},
+	})
+}
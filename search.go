@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Relevance weights of the command fields matched by a search
+const (
+	nameMatchWeight        = 4
+	tagMatchWeight         = 3
+	descriptionMatchWeight = 2
+	commandMatchWeight     = 1
+)
+
+const (
+	// maxSearchMatches caps the matches returned for each execution
+	maxSearchMatches = 5
+	// searchSnippetContext is how many bytes of text a match is shown with
+	// on each side
+	searchSnippetContext = 60
+	// maxSearchLineLength is the longest output line searched; the rest of a
+	// log after a longer line is skipped
+	maxSearchLineLength = 1024 * 1024
+)
+
+// CommandQuery filters the commands list. Empty fields match everything.
+type CommandQuery struct {
+	Text string // Case-insensitive search of name, description, command and tags
+	Tag  string // Exact tag match
+
+	IncludeArchived bool // Also match archived commands
+}
+
+// commandScore returns how well cmd matches every word of the search text,
+// or 0 if some word does not match
+func commandScore(cmd *Command, text string) int {
+	name := strings.ToLower(cmd.Name)
+	description := strings.ToLower(cmd.Description)
+	command := strings.ToLower(cmd.script())
+
+	score := 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		wordScore := 0
+		if strings.Contains(name, word) {
+			wordScore += nameMatchWeight
+		}
+		for _, tag := range cmd.Tags {
+			if strings.Contains(strings.ToLower(tag), word) {
+				wordScore += tagMatchWeight
+				break
+			}
+		}
+		if strings.Contains(description, word) {
+			wordScore += descriptionMatchWeight
+		}
+		if strings.Contains(command, word) {
+			wordScore += commandMatchWeight
+		}
+		if wordScore == 0 {
+			return 0
+		}
+		score += wordScore
+	}
+	return score
+}
+
+// hasTag reports whether cmd is tagged with tag
+func hasTag(cmd *Command, tag string) bool {
+	for _, t := range cmd.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// searchCommands returns the commands matching query, most relevant first
+// and then by name
+func searchCommands(commands map[string]*Command, query CommandQuery) []*Command {
+	scores := make(map[string]int, len(commands))
+	matched := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		if cmd.ArchivedAt != nil && !query.IncludeArchived {
+			continue
+		}
+		if query.Tag != "" && !hasTag(cmd, query.Tag) {
+			continue
+		}
+		if strings.TrimSpace(query.Text) != "" {
+			score := commandScore(cmd, query.Text)
+			if score == 0 {
+				continue
+			}
+			scores[cmd.ID] = score
+		}
+		matched = append(matched, cmd)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if scores[a.ID] != scores[b.ID] {
+			return scores[a.ID] > scores[b.ID]
+		}
+		if !strings.EqualFold(a.Name, b.Name) {
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+		return a.ID < b.ID
+	})
+	return matched
+}
+
+// ExecutionSearchMatch is an occurrence of the search text in an execution,
+// split into the match and the text around it for highlighting
+type ExecutionSearchMatch struct {
+	Field  string `json:"field"`          // name, command, note or output
+	Line   int    `json:"line,omitempty"` // Line of the command or output, from 1
+	Before string `json:"before"`
+	Match  string `json:"match"`
+	After  string `json:"after"`
+}
+
+// ExecutionSearchResult is an execution matching a search
+type ExecutionSearchResult struct {
+	Execution *Execution             `json:"execution"`
+	Matches   []ExecutionSearchMatch `json:"matches"` // At most maxSearchMatches
+}
+
+// ExecutionSearchResponse represents the executions matching a search
+type ExecutionSearchResponse struct {
+	Results   []ExecutionSearchResult `json:"results"`
+	Limit     int                     `json:"limit"`
+	Truncated bool                    `json:"truncated"` // More executions match than the limit
+}
+
+// searchExecutions returns up to limit of executions whose name, command,
+// note or output contain text, case-insensitively, in the given order. Output is
+// read line by line from the log of each execution's latest attempt.
+func searchExecutions(executions []*Execution, text string, limit int) ([]ExecutionSearchResult, bool, error) {
+	pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(text))
+
+	results := make([]ExecutionSearchResult, 0)
+	for _, execution := range executions {
+		matches := matchLines("name", execution.Name, pattern, nil)
+		matches = matchLines("command", execution.Command, pattern, matches)
+		matches = matchLines("note", execution.Note, pattern, matches)
+		if execution.LogFile == "" {
+			// Records created before log files store combined output inline
+			matches = matchLines("output", execution.Output, pattern, matches)
+		} else if len(matches) < maxSearchMatches {
+			var err error
+			if matches, err = matchLog(execution.LogFile, pattern, matches); err != nil {
+				return nil, false, err
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		if len(results) == limit {
+			return results, true, nil
+		}
+		results = append(results, ExecutionSearchResult{Execution: execution, Matches: matches})
+	}
+	return results, false, nil
+}
+
+// matchLines appends the first match of pattern on each line of text to
+// matches, up to maxSearchMatches
+func matchLines(field, text string, pattern *regexp.Regexp, matches []ExecutionSearchMatch) []ExecutionSearchMatch {
+	for i, line := range strings.Split(text, "\n") {
+		if len(matches) == maxSearchMatches {
+			break
+		}
+		if loc := pattern.FindStringIndex(line); loc != nil {
+			matches = append(matches, newSearchMatch(field, i+1, line, loc))
+		}
+	}
+	return matches
+}
+
+// matchLog appends the first match of pattern on each line of an output log
+// to matches, up to maxSearchMatches. Only one line is held in memory at a
+// time. A missing log has no matches.
+func matchLog(path string, pattern *regexp.Regexp, matches []ExecutionSearchMatch) ([]ExecutionSearchMatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return matches, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSearchLineLength)
+	for line := 1; len(matches) < maxSearchMatches && scanner.Scan(); line++ {
+		if loc := pattern.FindIndex(scanner.Bytes()); loc != nil {
+			matches = append(matches, newSearchMatch("output", line, scanner.Text(), loc))
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// newSearchMatch builds the match at loc in a line, with up to
+// searchSnippetContext bytes of context on each side, cut at rune boundaries
+func newSearchMatch(field string, line int, text string, loc []int) ExecutionSearchMatch {
+	start := loc[0] - searchSnippetContext
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := loc[1] + searchSnippetContext
+	if end > len(text) {
+		end = len(text)
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	match := ExecutionSearchMatch{
+		Field:  field,
+		Before: text[start:loc[0]],
+		Match:  text[loc[0]:loc[1]],
+		After:  text[loc[1]:end],
+	}
+	if field != "name" {
+		match.Line = line
+	}
+	return match
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// ConfigResponse is the body of GET /api/config: the runtime settings that
+// affect how commands run, without secrets such as tokens or TLS keys
+type ConfigResponse struct {
+	Version         string   `json:"version"`
+	BasePath        string   `json:"base_path"`
+	TLSEnabled      bool     `json:"tls_enabled"`
+	Store           string   `json:"store"` // json or sqlite
+	DataDir         string   `json:"data_dir"`
+	AllowedWorkdirs []string `json:"allowed_workdirs"` // Empty allows any workdir
+
+	CommandRules *CommandRules `json:"command_rules"` // Null allows any command
+
+	MaxConcurrent      int   `json:"max_concurrent"`   // Zero or less means unlimited
+	MaxOutputBytes     int64 `json:"max_output_bytes"` // Zero or less means unlimited
+	KillOnOutputLimit  bool  `json:"kill_on_output_limit"`
+	MaxTimeoutOverride int   `json:"max_timeout_override_seconds"` // Zero means unlimited
+	ShutdownTimeout    int   `json:"shutdown_timeout_seconds"`
+
+	ExecutionRateLimit  int     `json:"execution_rate_limit"` // Zero disables it
+	ExecutionRateWindow int     `json:"execution_rate_window_seconds"`
+	ExecuteRequestRate  float64 `json:"execute_request_rate"` // Zero disables it
+	ExecuteRequestBurst int     `json:"execute_request_burst"`
+
+	ExecutionRetention       int `json:"execution_retention_seconds"` // Zero keeps executions forever
+	KeepExecutionsPerCommand int `json:"keep_executions_per_command"` // Zero keeps all
+	RetentionInterval        int `json:"retention_interval_seconds"`
+	MaxExecutions            int `json:"max_executions"` // Zero or less means unlimited
+
+	MaxRequestBytes int64       `json:"max_request_bytes"`
+	Limits          FieldLimits `json:"limits"`
+
+	Compression      bool `json:"compression"`
+	CompressMinBytes int  `json:"compress_min_bytes"`
+}
+
+// ConfigHandler handles GET /api/config (admin only). Saved commands have no
+// timeout unless they set one, so there is no server-wide default to report.
+func (app *App) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	config := app.config
+	allowedWorkdirs := config.AllowedWorkdirs
+	if allowedWorkdirs == nil {
+		allowedWorkdirs = []string{}
+	}
+	respondJSON(w, http.StatusOK, ConfigResponse{
+		Version:         version,
+		BasePath:        config.BasePath,
+		TLSEnabled:      config.tlsEnabled(),
+		Store:           config.Store,
+		DataDir:         config.DataDir,
+		AllowedWorkdirs: allowedWorkdirs,
+
+		CommandRules: config.CommandRules,
+
+		MaxConcurrent:      config.MaxConcurrent,
+		MaxOutputBytes:     config.MaxOutputBytes,
+		KillOnOutputLimit:  config.KillOnOutputLimit,
+		MaxTimeoutOverride: seconds(config.MaxTimeoutOverride),
+		ShutdownTimeout:    seconds(config.ShutdownTimeout),
+
+		ExecutionRateLimit:  config.ExecutionRateLimit,
+		ExecutionRateWindow: seconds(config.ExecutionRateWindow),
+		ExecuteRequestRate:  config.ExecuteRequestRate,
+		ExecuteRequestBurst: config.ExecuteRequestBurst,
+
+		ExecutionRetention:       seconds(config.ExecutionRetention),
+		KeepExecutionsPerCommand: config.KeepExecutionsPerCommand,
+		RetentionInterval:        seconds(config.RetentionInterval),
+		MaxExecutions:            config.MaxExecutions,
+
+		MaxRequestBytes: config.MaxRequestBytes,
+		Limits:          config.Limits,
+
+		Compression:      config.Compression,
+		CompressMinBytes: config.CompressMinBytes,
+	})
+}
+
+// seconds returns d in whole seconds
+func seconds(d time.Duration) int {
+	return int(d / time.Second)
+}
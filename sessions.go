@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Session is an authenticated login identified by an opaque token
+type Session struct {
+	Token     string
+	Username  string
+	ExpiresAt time.Time
+}
+
+// SessionStore keeps sessions in memory. Sessions expire after the TTL, which
+// slides forward every time the session is used.
+type SessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates a session store with the given TTL
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create starts a new session for username
+func (s *SessionStore) Create(username string) (*Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		Token:     hex.EncodeToString(buf),
+		Username:  username,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+// Validate returns the session for token if it exists and has not expired,
+// extending its expiry
+func (s *SessionStore) Validate(token string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return Session{}, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, token)
+		return Session{}, false
+	}
+
+	session.ExpiresAt = time.Now().Add(s.ttl)
+	return *session, true
+}
+
+// Revoke ends a session
+func (s *SessionStore) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+}
+
+// RevokeUser ends all sessions of a user
+func (s *SessionStore) RevokeUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// pruneLocked removes expired sessions. Caller must hold s.mu.
+func (s *SessionStore) pruneLocked() {
+	now := time.Now()
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
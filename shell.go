@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// defaultShell runs commands unless another shell is chosen
+const defaultShell = "sh"
+
+// directExec is the shell value that runs the command as a program, without
+// a shell interpreting it
+const directExec = "none"
+
+// maxArgs caps the number of arguments of a command
+const maxArgs = 100
+
+// ValidateShell checks that the shell a command runs in exists. When running
+// directly, a program given by name must be found on PATH; paths are
+// resolved against the workdir when the command starts.
+func ValidateShell(shell, command string, args []string) error {
+	if len(args) > maxArgs {
+		return fmt.Errorf("args cannot have more than %d entries", maxArgs)
+	}
+	switch shell {
+	case "":
+		return nil
+	case directExec:
+		if strings.ContainsRune(command, '/') {
+			return nil
+		}
+		if _, err := exec.LookPath(command); err != nil {
+			return fmt.Errorf("program %q not found", command)
+		}
+		return nil
+	default:
+		if _, err := exec.LookPath(shell); err != nil {
+			return fmt.Errorf("shell %q not found", shell)
+		}
+		return nil
+	}
+}
+
+// shellCommand builds the process for a command. In a shell the command is
+// run with -c and args become the positional parameters $1, $2, ...; with
+// directExec the command is the program and args are passed to it as is.
+func shellCommand(command, shell string, args []string) *exec.Cmd {
+	if shell == directExec {
+		return exec.Command(command, args...)
+	}
+	if shell == "" {
+		shell = defaultShell
+	}
+	if len(args) == 0 {
+		return exec.Command(shell, "-c", command)
+	}
+	// The argument after the command is $0
+	return exec.Command(shell, append([]string{"-c", command, shell}, args...)...)
+}
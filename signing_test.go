@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSignedRequest returns a request signed with key as of at
+func newSignedRequest(key, method, uri, body, nonce string, at time.Time) *http.Request {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n" + body))
+
+	r := httptest.NewRequest(method, uri, strings.NewReader(body))
+	r.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(nonceHeader, nonce)
+	return r
+}
+
+func TestRequestVerifierSkew(t *testing.T) {
+	const maxSkew = 5 * time.Minute
+	tests := []struct {
+		name    string
+		offset  time.Duration // Timestamp relative to now
+		wantErr bool
+	}{
+		{name: "now", offset: 0},
+		{name: "slightly behind", offset: -maxSkew + time.Minute},
+		{name: "slightly ahead", offset: maxSkew - time.Minute},
+		{name: "too old", offset: -maxSkew - time.Minute, wantErr: true},
+		{name: "too far ahead", offset: maxSkew + time.Minute, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewRequestVerifier(maxSkew)
+			r := newSignedRequest("secret", "POST", "/api/execute", `{"command":"ls"}`, "n1", time.Now().Add(tt.offset))
+			err := v.Verify(r, "secret")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestVerifierSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		key     string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			request: func() *http.Request {
+				return newSignedRequest("secret", "POST", "/api/execute?x=1", "body", "n1", time.Now())
+			},
+			key: "secret",
+		},
+		{
+			name: "wrong key",
+			request: func() *http.Request {
+				return newSignedRequest("secret", "POST", "/api/execute", "body", "n1", time.Now())
+			},
+			key:     "other",
+			wantErr: "Invalid request signature",
+		},
+		{
+			name: "tampered body",
+			request: func() *http.Request {
+				r := newSignedRequest("secret", "POST", "/api/execute", "body", "n1", time.Now())
+				r.Body = io.NopCloser(strings.NewReader("tampered"))
+				return r
+			},
+			key:     "secret",
+			wantErr: "Invalid request signature",
+		},
+		{
+			name: "tampered nonce",
+			request: func() *http.Request {
+				r := newSignedRequest("secret", "POST", "/api/execute", "body", "n1", time.Now())
+				r.Header.Set(nonceHeader, "n2")
+				return r
+			},
+			key:     "secret",
+			wantErr: "Invalid request signature",
+		},
+		{
+			name: "missing signature",
+			request: func() *http.Request {
+				r := newSignedRequest("secret", "GET", "/api/commands", "", "n1", time.Now())
+				r.Header.Del(signatureHeader)
+				return r
+			},
+			key:     "secret",
+			wantErr: "Request signature required",
+		},
+		{
+			name: "invalid timestamp",
+			request: func() *http.Request {
+				r := newSignedRequest("secret", "GET", "/api/commands", "", "n1", time.Now())
+				r.Header.Set(timestampHeader, "yesterday")
+				return r
+			},
+			key:     "secret",
+			wantErr: "Invalid request timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewRequestVerifier(5 * time.Minute)
+			err := v.Verify(tt.request(), tt.key)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestVerifierReplay(t *testing.T) {
+	v := NewRequestVerifier(5 * time.Minute)
+	now := time.Now()
+	if err := v.Verify(newSignedRequest("secret", "POST", "/api/execute", "body", "n1", now), "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(newSignedRequest("secret", "POST", "/api/execute", "body", "n1", now), "secret"); err == nil {
+		t.Fatal("replayed signature was accepted")
+	}
+	if err := v.Verify(newSignedRequest("secret", "POST", "/api/execute", "body", "n2", now), "secret"); err != nil {
+		t.Fatalf("new nonce was rejected: %v", err)
+	}
+}
+
+func TestRequestVerifierRestoresBody(t *testing.T) {
+	v := NewRequestVerifier(5 * time.Minute)
+	r := newSignedRequest("secret", "POST", "/api/execute", `{"command":"ls"}`, "n1", time.Now())
+	if err := v.Verify(r, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || string(body) != `{"command":"ls"}` {
+		t.Errorf("body after verifying = %q, %v", body, err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTable tracks the last persisted JSON of every row in a table so that
+// saves only write rows that changed
+type sqliteTable struct {
+	mu    sync.Mutex
+	name  string
+	saved map[string]string
+}
+
+// SQLiteStore persists data in a SQLite database. Each record is stored as a
+// JSON document keyed by its ID, and saves only insert, update or delete the
+// rows that changed since the last save.
+type SQLiteStore struct {
+	db         *sql.DB
+	path       string
+	commands   *sqliteTable
+	executions *sqliteTable
+	users      *sqliteTable
+	presets    *sqliteTable
+	tokens     *sqliteTable
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{
+		db:         db,
+		path:       path,
+		commands:   &sqliteTable{name: "commands"},
+		executions: &sqliteTable{name: "executions"},
+		users:      &sqliteTable{name: "users"},
+		presets:    &sqliteTable{name: "presets"},
+		tokens:     &sqliteTable{name: "tokens"},
+	}
+
+	// Records are stored in the same format as the JSON data files, and the
+	// database records their schema version
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > storeSchemaVersion {
+		db.Close()
+		return nil, &StoreFileError{Path: path, Err: fmt.Errorf("schema version %d is newer than the supported version %d", version, storeSchemaVersion)}
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", storeSchemaVersion)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to write schema version: %w", err)
+	}
+
+	for _, table := range []*sqliteTable{s.commands, s.executions, s.users, s.presets, s.tokens} {
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data TEXT NOT NULL)", table.name)
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create table %s: %w", table.name, err)
+		}
+		if _, err := s.readRows(table); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table counters: %w", err)
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS audit (seq INTEGER PRIMARY KEY AUTOINCREMENT, data TEXT NOT NULL)"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table audit: %w", err)
+	}
+
+	return s, nil
+}
+
+// LoadCommands reads commands from the database
+func (s *SQLiteStore) LoadCommands() (map[string]*Command, error) {
+	return loadSQLiteRows[Command](s, s.commands)
+}
+
+// SaveCommands writes changed commands to the database
+func (s *SQLiteStore) SaveCommands(commands map[string]*Command) error {
+	return saveSQLiteRows(s, s.commands, commands)
+}
+
+// LoadExecutions reads executions from the database
+func (s *SQLiteStore) LoadExecutions() (map[string]*Execution, error) {
+	return loadSQLiteRows[Execution](s, s.executions)
+}
+
+// SaveExecutions writes changed executions to the database
+func (s *SQLiteStore) SaveExecutions(executions map[string]*Execution) error {
+	return saveSQLiteRows(s, s.executions, executions)
+}
+
+// LoadUsers reads users from the database
+func (s *SQLiteStore) LoadUsers() (map[string]*User, error) {
+	return loadSQLiteRows[User](s, s.users)
+}
+
+// SaveUsers writes changed users to the database
+func (s *SQLiteStore) SaveUsers(users map[string]*User) error {
+	return saveSQLiteRows(s, s.users, users)
+}
+
+// LoadPresets reads environment presets from the database
+func (s *SQLiteStore) LoadPresets() (map[string]*EnvPreset, error) {
+	return loadSQLiteRows[EnvPreset](s, s.presets)
+}
+
+// SavePresets writes changed environment presets to the database
+func (s *SQLiteStore) SavePresets(presets map[string]*EnvPreset) error {
+	return saveSQLiteRows(s, s.presets, presets)
+}
+
+// LoadTokens reads API tokens from the database
+func (s *SQLiteStore) LoadTokens() (map[string]*APIToken, error) {
+	return loadSQLiteRows[APIToken](s, s.tokens)
+}
+
+// SaveTokens writes changed API tokens to the database
+func (s *SQLiteStore) SaveTokens(tokens map[string]*APIToken) error {
+	return saveSQLiteRows(s, s.tokens, tokens)
+}
+
+// LoadSequence reads the last assigned execution sequence number
+func (s *SQLiteStore) LoadSequence() (int64, error) {
+	var seq int64
+	err := s.db.QueryRow("SELECT value FROM counters WHERE name = 'execution_seq'").Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// SaveSequence writes the last assigned execution sequence number
+func (s *SQLiteStore) SaveSequence(seq int64) error {
+	_, err := s.db.Exec("INSERT INTO counters (name, value) VALUES ('execution_seq', ?) ON CONFLICT(name) DO UPDATE SET value = excluded.value", seq)
+	return err
+}
+
+// AppendAudit inserts an entry into the audit table
+func (s *SQLiteStore) AppendAudit(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO audit (data) VALUES (?)", string(data))
+	return err
+}
+
+// LoadAudit reads the audit table in insertion order
+func (s *SQLiteStore) LoadAudit() ([]*AuditEntry, error) {
+	rows, err := s.db.Query("SELECT seq, data FROM audit ORDER BY seq")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		var seq int64
+		var doc string
+		if err := rows.Scan(&seq, &doc); err != nil {
+			return nil, err
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(doc), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit row %d: %w", seq, err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+// readRows reads all rows of a table and refreshes its saved snapshot.
+// Caller must hold table.mu or have exclusive access to the table.
+func (s *SQLiteStore) readRows(table *sqliteTable) (map[string]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id, data FROM %s", table.name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := make(map[string]string)
+	for rows.Next() {
+		var id, doc string
+		if err := rows.Scan(&id, &doc); err != nil {
+			return nil, err
+		}
+		data[id] = doc
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	table.saved = data
+	return data, nil
+}
+
+// loadSQLiteRows decodes every row of a table
+func loadSQLiteRows[T any](s *SQLiteStore, table *sqliteTable) (map[string]*T, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	data, err := s.readRows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*T, len(data))
+	for id, doc := range data {
+		var item T
+		if err := json.Unmarshal([]byte(doc), &item); err != nil {
+			// Saving after a failed load would delete the row, so refuse
+			return nil, &StoreFileError{Path: s.path, Err: fmt.Errorf("failed to decode %s row %s: %w", table.name, id, err)}
+		}
+		result[id] = &item
+	}
+	return result, nil
+}
+
+// saveSQLiteRows upserts rows that changed and deletes rows that were removed
+// since the last save, in a single transaction
+func saveSQLiteRows[T any](s *SQLiteStore, table *sqliteTable, items map[string]*T) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	current := make(map[string]string, len(items))
+	for id, item := range items {
+		doc, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		current[id] = string(doc)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := fmt.Sprintf("INSERT INTO %s (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data", table.name)
+	for id, doc := range current {
+		if table.saved[id] == doc {
+			continue
+		}
+		if _, err := tx.Exec(upsert, id, doc); err != nil {
+			return err
+		}
+	}
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE id = ?", table.name)
+	for id := range table.saved {
+		if _, ok := current[id]; ok {
+			continue
+		}
+		if _, err := tx.Exec(remove, id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	table.saved = current
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// staticHandler serves the web UI from dir. Paths that are not files fall
+// back to index.html so client-side routes survive a refresh, except for
+// API paths and paths with a file extension, which are missing assets and
+// get a 404. A missing /favicon.ico is answered with favicon.svg.
+func staticHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if name == "/api" || strings.HasPrefix(name, "/api/") {
+			respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+			return
+		}
+		if staticFileExists(dir, name) {
+			files.ServeHTTP(w, r)
+			return
+		}
+		if name == "/favicon.ico" {
+			http.ServeFile(w, r, filepath.Join(dir, "favicon.svg"))
+			return
+		}
+		if path.Ext(name) != "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			http.NotFound(w, r)
+			return
+		}
+		// Always revalidated, the UI it points to changes with each release
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, filepath.Join(dir, "index.html"))
+	})
+}
+
+// staticFileExists reports whether name, a cleaned URL path, is a file or
+// directory under dir
+func staticFileExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil
+}
@@ -1,7 +1,7 @@
 // ===========================
 // API Configuration
 // ===========================
-const API_BASE = '/api';
+const API_BASE = 'api';
 const REFRESH_INTERVAL = 2000;
 
 // ===========================
@@ -12,6 +12,7 @@ let executions = [];
 let refreshTimer = null;
 let selectedExecutionId = null;
 let commandSearchQuery = '';
+let commandSearchTimer = null;
 
 // ===========================
 // Initialization
@@ -66,16 +67,19 @@ async function apiRequest(endpoint, options = {}) {
     }
 
     try {
-        const response = await fetch(`${API_BASE}${endpoint}`, {
+        const url = `${API_BASE}${endpoint}`;
+        const signature = await signRequest(options.method || 'GET', url, options.body);
+        const response = await fetch(url, {
             headers: {
                 'Content-Type': 'application/json',
                 'Authorization': authHeader,
+                ...signature,
                 ...options.headers,
             },
             ...options,
         });
 
-        if (response.status === 401) {
+        if (response.status === 401 && !response.headers.get('X-Deployar-Reauth')) {
             clearAuthCredentials();
             redirectToLogin();
             return;
@@ -83,7 +87,10 @@ async function apiRequest(endpoint, options = {}) {
 
         if (!response.ok) {
             const error = await response.json();
-            throw new Error(error.error || 'Request failed');
+            const err = new Error(error.error || 'Request failed');
+            err.status = response.status;
+            err.body = error;
+            throw err;
         }
 
         return await response.json();
@@ -94,11 +101,11 @@ async function apiRequest(endpoint, options = {}) {
     }
 }
 
-async function executeCommand(workdir, command, commandId = null) {
+async function executeCommand(workdir, command, commandId = null, params = null, reauthPassword = null) {
     const endpoint = commandId ? `/commands/${commandId}/execute` : '/execute';
     return await apiRequest(endpoint, {
         method: 'POST',
-        body: JSON.stringify({ workdir, command }),
+        body: JSON.stringify(commandId ? { params, reauth_password: reauthPassword || undefined } : { workdir, command }),
     });
 }
 
@@ -116,14 +123,20 @@ async function updateCommand(id, commandData) {
     });
 }
 
-async function deleteCommand(id) {
-    return await apiRequest(`/commands/${id}`, {
+async function deleteCommand(id, force = false) {
+    return await apiRequest(force ? `/commands/${id}?force=true` : `/commands/${id}`, {
         method: 'DELETE',
     });
 }
 
-async function getCommands() {
-    return await apiRequest('/commands');
+async function setFavorite(id, favorite) {
+    return await apiRequest(`/commands/${id}/favorite`, {
+        method: favorite ? 'POST' : 'DELETE',
+    });
+}
+
+async function getCommands(query = '') {
+    return await apiRequest(query ? `/commands?q=${encodeURIComponent(query)}` : '/commands');
 }
 
 async function getExecutions() {
@@ -134,6 +147,19 @@ async function getExecution(id) {
     return await apiRequest(`/executions/${id}`);
 }
 
+async function cancelExecution(id) {
+    return await apiRequest(`/executions/${id}/cancel`, {
+        method: 'POST',
+    });
+}
+
+async function decideApproval(id, decision, body = {}) {
+    return await apiRequest(`/executions/${id}/${decision}`, {
+        method: 'POST',
+        body: JSON.stringify(body),
+    });
+}
+
 async function clearAllExecutions() {
     return await apiRequest('/executions/clear', {
         method: 'POST',
@@ -157,27 +183,33 @@ async function loadCurrentUser() {
 // ===========================
 async function loadCommands() {
     try {
-        commands = await apiRequest('/commands');
+        commands = await getCommands(commandSearchQuery);
+        // Pinned commands come first, otherwise keeping the server's order
+        commands.sort((a, b) => Number(b.is_favorite) - Number(a.is_favorite));
         renderCommands();
     } catch (error) {
         console.error('Failed to load commands:', error);
     }
 }
 
-// Handle command search
+// Handle command search. The server does the matching, so wait until the
+// user stops typing.
 function handleCommandSearch(e) {
-    commandSearchQuery = e.target.value.toLowerCase().trim();
-    renderCommands();
+    commandSearchQuery = e.target.value.trim();
+    clearTimeout(commandSearchTimer);
+    commandSearchTimer = setTimeout(loadCommands, 200);
 }
 
 async function loadExecutions() {
     try {
-        executions = await getExecutions();
+        const page = await getExecutions();
+        executions = page.executions;
         renderExecutions();
 
-        // Refresh selected execution details if one is selected
-        if (selectedExecutionId) {
-            const exec = executions.find(e => e.id === selectedExecutionId);
+        // Refresh selected execution details if one is selected. The list
+        // does not include output, so fetch the full execution.
+        if (selectedExecutionId && executions.some(e => e.id === selectedExecutionId)) {
+            const exec = await getExecution(selectedExecutionId);
             if (exec) {
                 renderExecutionDetails(exec);
             }
@@ -269,10 +301,9 @@ async function handleSaveCommand(e) {
     };
 
     try {
-        if (id) {
-            await updateCommand(id, commandData);
-        } else {
-            await createCommand(commandData);
+        const saved = id ? await updateCommand(id, commandData) : await createCommand(commandData);
+        if (saved && saved.warnings && saved.warnings.length > 0) {
+            alert(`Command saved with warnings:\n${saved.warnings.join('\n')}`);
         }
 
         closeCommandModal();
@@ -285,18 +316,18 @@ async function handleSaveCommand(e) {
 // ===========================
 // Render Functions
 // ===========================
+function lastRunColor(status) {
+    return {
+        running: 'text-blue-400',
+        success: 'text-green-400',
+        failed: 'text-red-400',
+    }[status] || 'text-gray-400';
+}
+
 function renderCommands() {
     const container = document.getElementById('commandsList');
 
-    // Filter commands by search query
-    const filteredCommands = commands.filter(cmd => {
-        if (!commandSearchQuery) return true;
-        return cmd.name.toLowerCase().includes(commandSearchQuery) ||
-            (cmd.description && cmd.description.toLowerCase().includes(commandSearchQuery)) ||
-            (cmd.tags && cmd.tags.some(tag => tag.toLowerCase().includes(commandSearchQuery)));
-    });
-
-    if (filteredCommands.length === 0) {
+    if (commands.length === 0) {
         const message = commandSearchQuery
             ? `No commands matching "${commandSearchQuery}"`
             : 'No saved commands';
@@ -304,13 +335,29 @@ function renderCommands() {
         return;
     }
 
-    const html = filteredCommands.map(cmd => `
+    const html = commands.map(cmd => `
         <div class="bg-gray-800 border border-gray-700 rounded p-2 hover:border-indigo-500 transition group">
-            <div class="font-medium text-xs mb-1 truncate">${escapeHtml(cmd.name)}</div>
+            <div class="flex items-center gap-1 mb-1">
+                <div class="font-medium text-xs truncate flex-1">${escapeHtml(cmd.name)}</div>
+                <button
+                    onclick="toggleFavorite('${cmd.id}', ${!cmd.is_favorite})"
+                    class="text-xs ${cmd.is_favorite ? 'text-yellow-400' : 'text-gray-600 hover:text-yellow-400'} transition"
+                    title="${cmd.is_favorite ? 'Unpin command' : 'Pin command'}"
+                >
+                    <i class="${cmd.is_favorite ? 'fa-solid' : 'fa-regular'} fa-star"></i>
+                </button>
+            </div>
             ${cmd.description ? `<div class="text-xs text-gray-400 mb-1.5 truncate">${escapeHtml(cmd.description)}</div>` : ''}
             <div class="text-xs text-gray-500 mb-1.5 space-y-0.5">
                 <div class="truncate flex items-center gap-1"><i class="fa-regular fa-folder text-gray-600"></i> ${escapeHtml(cmd.workdir)}</div>
-                <div class="truncate flex items-center gap-1"><i class="fa-solid fa-terminal text-gray-600"></i> ${escapeHtml(cmd.command)}</div>
+                <div class="truncate flex items-center gap-1"><i class="fa-solid fa-terminal text-gray-600"></i> ${escapeHtml(cmd.command || (cmd.steps || []).map(step => step.command).join(" && "))}</div>
+                ${cmd.last_execution ? `
+                <div class="truncate flex items-center gap-1" title="Last run">
+                    <i class="fa-solid fa-clock-rotate-left text-gray-600"></i>
+                    <span class="${lastRunColor(cmd.last_execution.status)}">${escapeHtml(cmd.last_execution.status)}</span>
+                    ${formatDateTime(cmd.last_execution.started_at)}
+                </div>
+                ` : ''}
             </div>
             ${cmd.tags && cmd.tags.length > 0 ? `
             <div class="flex flex-wrap gap-1 mb-1.5">
@@ -356,15 +403,27 @@ function renderExecutions() {
 
     const html = executions.slice(0, 50).map(exec => {
         const statusColor = {
+            queued: 'bg-gray-400',
             running: 'bg-blue-500',
             success: 'bg-green-500',
             failed: 'bg-red-500',
+            cancelled: 'bg-yellow-500',
+            interrupted: 'bg-purple-500',
+            rejected: 'bg-orange-500',
+            pending_approval: 'bg-cyan-500',
+            declined: 'bg-orange-500',
         }[exec.status] || 'bg-gray-500';
 
         const statusIcon = {
+            queued: '<i class="fa-solid fa-hourglass-half"></i>',
             running: '<i class="fa-solid fa-spinner fa-spin"></i>',
             success: '<i class="fa-solid fa-check"></i>',
             failed: '<i class="fa-solid fa-xmark"></i>',
+            cancelled: '<i class="fa-solid fa-ban"></i>',
+            interrupted: '<i class="fa-solid fa-power-off"></i>',
+            rejected: '<i class="fa-solid fa-shield-halved"></i>',
+            pending_approval: '<i class="fa-solid fa-user-check"></i>',
+            declined: '<i class="fa-solid fa-thumbs-down"></i>',
         }[exec.status] || '<i class="fa-solid fa-question"></i>';
 
         const isSelected = exec.id === selectedExecutionId;
@@ -374,7 +433,7 @@ function renderExecutions() {
                  class="bg-gray-800 border ${isSelected ? 'border-indigo-500' : 'border-gray-700'} rounded p-2 hover:border-indigo-500 transition cursor-pointer">
                 <div class="flex items-center justify-between mb-1">
                     <div class="font-medium text-xs truncate flex-1">
-                        ${exec.name ? escapeHtml(exec.name) : '<span class="text-gray-400">Quick Execute</span>'}
+                        ${exec.seq ? `<span class="text-gray-500">#${exec.seq}</span> ` : ''}${exec.name ? escapeHtml(exec.name) : '<span class="text-gray-400">Quick Execute</span>'}
                     </div>
                     <span class="${statusColor} w-2 h-2 rounded-full ml-2"></span>
                 </div>
@@ -394,15 +453,27 @@ function renderExecutionDetails(execution) {
     selectedExecutionId = execution.id;
 
     const statusColor = {
+        queued: 'text-gray-300',
         running: 'text-blue-400',
         success: 'text-green-400',
         failed: 'text-red-400',
+        cancelled: 'text-yellow-400',
+        interrupted: 'text-purple-400',
+        rejected: 'text-orange-400',
+        pending_approval: 'text-cyan-400',
+        declined: 'text-orange-400',
     }[execution.status] || 'text-gray-400';
 
     const statusIcon = {
+        queued: '<i class="fa-solid fa-hourglass-half"></i>',
         running: '<i class="fa-solid fa-spinner fa-spin"></i>',
         success: '<i class="fa-solid fa-check"></i>',
         failed: '<i class="fa-solid fa-xmark"></i>',
+        cancelled: '<i class="fa-solid fa-ban"></i>',
+        interrupted: '<i class="fa-solid fa-power-off"></i>',
+        rejected: '<i class="fa-solid fa-shield-halved"></i>',
+        pending_approval: '<i class="fa-solid fa-user-check"></i>',
+        declined: '<i class="fa-solid fa-thumbs-down"></i>',
     }[execution.status] || '<i class="fa-solid fa-question"></i>';
 
     const html = `
@@ -411,7 +482,37 @@ function renderExecutionDetails(execution) {
                 <div class="text-xs text-gray-400 mb-1">Status</div>
                 <div class="flex items-center gap-2 ${statusColor} font-medium text-sm">
                     ${statusIcon} ${execution.status.toUpperCase()}
+                    ${execution.status === 'running' || execution.status === 'queued' ? `
+                    <button 
+                        onclick="cancelRunningExecution('${execution.id}')" 
+                        class="ml-auto bg-red-600 hover:bg-red-700 text-white px-2 py-1 rounded text-xs transition flex items-center gap-1"
+                        title="Cancel execution"
+                    >
+                        <i class="fa-solid fa-stop"></i> Cancel
+                    </button>
+                    ` : ''}
+                    ${execution.status === 'pending_approval' ? `
+                    <button 
+                        onclick="decideExecution('${execution.id}', 'approve')" 
+                        class="ml-auto bg-green-600 hover:bg-green-700 text-white px-2 py-1 rounded text-xs transition flex items-center gap-1"
+                        title="Approve and run"
+                    >
+                        <i class="fa-solid fa-check"></i> Approve
+                    </button>
+                    <button 
+                        onclick="decideExecution('${execution.id}', 'reject')" 
+                        class="bg-red-600 hover:bg-red-700 text-white px-2 py-1 rounded text-xs transition flex items-center gap-1"
+                        title="Reject"
+                    >
+                        <i class="fa-solid fa-xmark"></i> Reject
+                    </button>
+                    ` : ''}
                 </div>
+                ${execution.approval ? `
+                <div class="text-xs text-gray-400 mt-1">
+                    Requested by ${escapeHtml(execution.approval.requested_by)}${execution.approval.decision ? `, ${escapeHtml(execution.approval.decision)} by ${escapeHtml(execution.approval.decided_by)}${execution.approval.reason ? `: ${escapeHtml(execution.approval.reason)}` : ''}` : ''}
+                </div>
+                ` : ''}
             </div>
             
             ${execution.name ? `
@@ -486,8 +587,23 @@ async function runSavedCommand(commandId) {
     const command = commands.find(c => c.id === commandId);
     if (!command) return;
 
+    // Ask for parameter values, prefilled with their defaults
+    const params = {};
+    for (const param of command.parameters || []) {
+        const value = prompt(`${param.name}${param.required ? ' (required)' : ''}`, param.default || '');
+        if (value === null) return;
+        params[param.name] = value;
+    }
+
+    // Sensitive commands ask for the password again
+    let reauthPassword = null;
+    if (command.require_reauth) {
+        reauthPassword = prompt(`"${command.name}" requires your password to run`);
+        if (reauthPassword === null) return;
+    }
+
     try {
-        const result = await executeCommand(command.workdir, command.command, commandId);
+        const result = await executeCommand(command.workdir, command.command, commandId, params, reauthPassword);
 
         // Auto-select the newly created execution
         if (result && result.execution_id) {
@@ -513,11 +629,59 @@ function editCommand(command) {
 }
 
 async function removeCommand(commandId) {
-    if (!confirm('Delete this command?')) return;
+    if (!confirm('Delete this command? It is archived and can be restored through the API.')) return;
 
     try {
         await deleteCommand(commandId);
         loadCommands();
+    } catch (error) {
+        // Commands still in use need a second confirmation
+        if (error.status !== 409 || !error.body.references) return;
+        const uses = error.body.references.map(ref => `${ref.type}: ${ref.detail || ref.name}`).join('\n');
+        if (!confirm(`This command is still in use:\n${uses}\n\nDelete it anyway?`)) return;
+        try {
+            await deleteCommand(commandId, true);
+            loadCommands();
+        } catch (error) {
+            // Error already handled
+        }
+    }
+}
+
+async function toggleFavorite(commandId, favorite) {
+    try {
+        await setFavorite(commandId, favorite);
+        loadCommands();
+    } catch (error) {
+        // Error already handled
+    }
+}
+
+async function cancelRunningExecution(executionId) {
+    if (!confirm('Cancel this execution?')) return;
+
+    try {
+        await cancelExecution(executionId);
+        loadExecutions();
+    } catch (error) {
+        // Error already handled
+    }
+}
+
+async function decideExecution(executionId, decision) {
+    let body = {};
+    if (decision === 'reject') {
+        const reason = prompt('Reason for rejecting (optional)');
+        if (reason === null) return;
+        body = { reason };
+    } else if (!confirm('Approve and run this execution?')) {
+        return;
+    }
+
+    try {
+        await decideApproval(executionId, decision, body);
+        loadExecutions();
+        selectExecution(executionId);
     } catch (error) {
         // Error already handled
     }
@@ -582,5 +746,8 @@ function formatDateTime(dateString) {
 window.runSavedCommand = runSavedCommand;
 window.editCommand = editCommand;
 window.removeCommand = removeCommand;
+window.toggleFavorite = toggleFavorite;
 window.selectExecution = selectExecution;
+window.cancelRunningExecution = cancelRunningExecution;
+window.decideExecution = decideExecution;
 window.closeCommandModal = closeCommandModal;
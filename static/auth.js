@@ -36,8 +36,8 @@ function getAuthCredentials() {
     }
 }
 
-function setAuthCredentials(username, password) {
-    const creds = { username, password };
+function setAuthCredentials(username, token) {
+    const creds = { username, token };
     setCookie('auth_creds', encodeURIComponent(JSON.stringify(creds)), 7);
 }
 
@@ -47,28 +47,60 @@ function clearAuthCredentials() {
 
 function getAuthHeader() {
     const creds = getAuthCredentials();
-    if (!creds) return null;
-    const encoded = btoa(creds.username + ':' + creds.password);
-    return 'Basic ' + encoded;
+    if (!creds || !creds.token) return null;
+    return 'Bearer ' + creds.token;
+}
+
+// signRequest returns the HMAC signature headers for a request, keyed with the
+// session token. The server only checks them when DEPLOYAR_REQUEST_SIGNING is enabled.
+async function signRequest(method, url, body) {
+    const creds = getAuthCredentials();
+    if (!creds || !creds.token || !window.crypto || !window.crypto.subtle) return {};
+
+    const timestamp = Math.floor(Date.now() / 1000).toString();
+    const nonce = Array.from(crypto.getRandomValues(new Uint8Array(16)), b => b.toString(16).padStart(2, '0')).join('');
+    const target = new URL(url, window.location.href);
+    const message = [timestamp, nonce, method.toUpperCase(), target.pathname + target.search, body || ''].join('\n');
+
+    const encoder = new TextEncoder();
+    const key = await crypto.subtle.importKey('raw', encoder.encode(creds.token), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign']);
+    const signature = await crypto.subtle.sign('HMAC', key, encoder.encode(message));
+
+    return {
+        'X-Deployar-Timestamp': timestamp,
+        'X-Deployar-Nonce': nonce,
+        'X-Deployar-Signature': Array.from(new Uint8Array(signature), b => b.toString(16).padStart(2, '0')).join(''),
+    };
 }
 
 function isAuthenticated() {
-    return getAuthCredentials() !== null;
+    return getAuthHeader() !== null;
 }
 
 function redirectToLogin() {
-    window.location.href = '/login.html';
+    window.location.href = 'login.html';
 }
 
 function redirectToSetup() {
-    window.location.href = '/setup.html';
+    window.location.href = 'setup.html';
 }
 
 function redirectToMain() {
-    window.location.href = '/index.html';
+    window.location.href = 'index.html';
 }
 
-function logout() {
+async function logout() {
+    const authHeader = getAuthHeader();
+    if (authHeader) {
+        try {
+            await fetch('api/auth/logout', {
+                method: 'POST',
+                headers: { 'Authorization': authHeader, ...(await signRequest('POST', 'api/auth/logout')) },
+            });
+        } catch (error) {
+            console.error('Failed to logout:', error);
+        }
+    }
     clearAuthCredentials();
     redirectToLogin();
 }
@@ -78,7 +110,7 @@ function logout() {
 // ===========================
 async function checkSetup() {
     try {
-        const response = await fetch('/api/auth/setup');
+        const response = await fetch('api/auth/setup');
         const data = await response.json();
         return data.needs_setup;
     } catch (error) {
@@ -95,6 +127,7 @@ window.getAuthCredentials = getAuthCredentials;
 window.setAuthCredentials = setAuthCredentials;
 window.clearAuthCredentials = clearAuthCredentials;
 window.getAuthHeader = getAuthHeader;
+window.signRequest = signRequest;
 window.isAuthenticated = isAuthenticated;
 window.redirectToLogin = redirectToLogin;
 window.redirectToSetup = redirectToSetup;
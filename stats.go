@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultStatsTop and maxStatsTop bound how many commands ?top= lists
+	defaultStatsTop = 10
+	maxStatsTop     = 100
+)
+
+// statsWindows are the periods success rates are reported for
+var statsWindows = []struct {
+	name   string
+	period time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// SuccessRate is the share of successful runs among the executions that
+// finished as success or failed within a period
+type SuccessRate struct {
+	Success int     `json:"success"`
+	Failed  int     `json:"failed"`
+	Rate    float64 `json:"rate"` // 0 to 1, or 0 without finished runs
+}
+
+// CommandRunCount is how often a saved command ran
+type CommandRunCount struct {
+	CommandID string `json:"command_id"`
+	Name      string `json:"name"` // Name of the latest run
+	Count     int    `json:"count"`
+}
+
+// ExecutionStats summarizes the whole execution history
+type ExecutionStats struct {
+	Total        int                    `json:"total"`
+	ByStatus     map[string]int         `json:"by_status"`
+	Durations    DurationSummary        `json:"durations"`     // Of executions that finished as success or failed; p50 is the median
+	SuccessRates map[string]SuccessRate `json:"success_rates"` // By period ("24h", "7d"), of executions started within it
+	TopCommands  []CommandRunCount      `json:"top_commands"`  // Most-run saved commands first
+}
+
+// Stats aggregates the execution history in one pass, without copying the
+// records. Executions started within a window of now count towards its
+// success rate, and the top most-run saved commands are listed.
+func (e *Executor) Stats(now time.Time, top int) ExecutionStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stats := ExecutionStats{
+		Total:        len(e.executions),
+		ByStatus:     make(map[string]int),
+		SuccessRates: make(map[string]SuccessRate, len(statsWindows)),
+		TopCommands:  make([]CommandRunCount, 0),
+	}
+	var durations []float64
+	runs := make(map[string]*CommandRunCount)
+	latest := make(map[string]time.Time)
+	for _, exec := range e.executions {
+		stats.ByStatus[exec.Status]++
+
+		if exec.CommandID != "" && exec.Status != "rejected" {
+			run, ok := runs[exec.CommandID]
+			if !ok {
+				run = &CommandRunCount{CommandID: exec.CommandID}
+				runs[exec.CommandID] = run
+			}
+			run.Count++
+			if exec.StartedAt.After(latest[exec.CommandID]) || run.Name == "" {
+				run.Name = exec.Name
+				latest[exec.CommandID] = exec.StartedAt
+			}
+		}
+
+		if exec.Status != "success" && exec.Status != "failed" {
+			continue
+		}
+		durations = append(durations, exec.EndedAt.Sub(exec.StartedAt).Seconds())
+		for _, window := range statsWindows {
+			if now.Sub(exec.StartedAt) > window.period {
+				continue
+			}
+			rate := stats.SuccessRates[window.name]
+			if exec.Status == "success" {
+				rate.Success++
+			} else {
+				rate.Failed++
+			}
+			stats.SuccessRates[window.name] = rate
+		}
+	}
+	stats.Durations = summarizeDurations(durations)
+
+	for _, window := range statsWindows {
+		rate := stats.SuccessRates[window.name]
+		if finished := rate.Success + rate.Failed; finished > 0 {
+			rate.Rate = float64(rate.Success) / float64(finished)
+		}
+		stats.SuccessRates[window.name] = rate
+	}
+
+	for _, run := range runs {
+		stats.TopCommands = append(stats.TopCommands, *run)
+	}
+	sort.Slice(stats.TopCommands, func(i, j int) bool {
+		a, b := stats.TopCommands[i], stats.TopCommands[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	if len(stats.TopCommands) > top {
+		stats.TopCommands = stats.TopCommands[:top]
+	}
+	return stats
+}
+
+// StatsHandler handles GET /api/stats
+//
+// Supports ?top= to change how many of the most-run commands are listed.
+func (app *App) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	top, err := parseIntParam(r.URL.Query().Get("top"), defaultStatsTop)
+	if err != nil || top < 0 || top > maxStatsTop {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "top must be an integer between 0 and 100"})
+		return
+	}
+	respondJSON(w, http.StatusOK, app.executor.Stats(time.Now(), top))
+}
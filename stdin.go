@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateStdin checks the standard input of a command run in workdir. A
+// stdin file must fall under one of allowedWorkdirs; relative paths are
+// resolved against the workdir. Whether it exists is only known when the
+// command runs.
+func ValidateStdin(workdir, stdin, stdinFile string, allowedWorkdirs []string) error {
+	if stdinFile == "" {
+		return nil
+	}
+	if stdin != "" {
+		return errors.New("stdin and stdin_file cannot be combined")
+	}
+	// Templated workdirs are checked once their parameters are substituted
+	if strings.Contains(workdir, "{{") {
+		return nil
+	}
+	if err := checkWorkdirAllowed(stdinPath(workdir, stdinFile), allowedWorkdirs); err != nil {
+		return fmt.Errorf("stdin_file: %w", err)
+	}
+	return nil
+}
+
+// stdinPath resolves a stdin file against the directory the command runs in
+func stdinPath(dir, stdinFile string) string {
+	if filepath.IsAbs(stdinFile) {
+		return stdinFile
+	}
+	return filepath.Join(dir, stdinFile)
+}
+
+// processStdin returns the standard input of cmd, or nil to leave it
+// connected to the null device
+func processStdin(cmd *exec.Cmd, params ExecuteParams) io.ReadCloser {
+	if params.StdinFile != "" {
+		return &stdinFile{cmd: cmd, path: params.StdinFile}
+	}
+	if params.Stdin != "" {
+		return io.NopCloser(strings.NewReader(params.Stdin))
+	}
+	return nil
+}
+
+// stdinFile streams a file to a process. The file is opened on the first
+// read, once the process is running in its final directory, so a missing
+// file fails the execution instead of its creation.
+type stdinFile struct {
+	cmd  *exec.Cmd
+	path string
+	file *os.File
+}
+
+// Read implements io.Reader
+func (s *stdinFile) Read(p []byte) (int, error) {
+	if s.file == nil {
+		file, err := os.Open(stdinPath(s.cmd.Dir, s.path))
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+	}
+	return s.file.Read(p)
+}
+
+// Close closes the file if it was opened. The process may exit without
+// reading all of it.
+func (s *stdinFile) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxSteps caps the number of steps of a command
+const maxSteps = 50
+
+// Step is one command of a multi-step execution. Steps run one after the
+// other; the first failing step stops the run unless it continues on error.
+type Step struct {
+	Name            string `json:"name,omitempty"`
+	Command         string `json:"command" schema:"required"`
+	Workdir         string `json:"workdir,omitempty"`           // Relative to the command's workdir, which is the default
+	ContinueOnError bool   `json:"continue_on_error,omitempty"` // A failure does not stop or fail the run
+}
+
+// StepResult is the outcome of a step in the latest attempt of an execution
+type StepResult struct {
+	Step
+	Status    string     `json:"status"` // pending, running, success, failed, cancelled, interrupted, skipped
+	ExitCode  int        `json:"exit_code"`
+	Error     string     `json:"error,omitempty"` // Why the step could not start
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	LogOffset int64      `json:"log_offset"` // Where the step's output starts in the combined log
+	LogSize   int64      `json:"log_size"`   // Size of the step's output in the combined log
+}
+
+// stepError is the error a multi-step run ends with when a step fails
+type stepError struct {
+	step int // Index of the failed step
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("step %d failed: %v", e.step+1, e.err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// stepsCommand describes steps as a script with one command per line, used
+// as the command of stepped executions for display, search and linting
+func stepsCommand(steps []Step) string {
+	commands := make([]string, len(steps))
+	for i, step := range steps {
+		commands[i] = step.Command
+	}
+	return strings.Join(commands, "\n")
+}
+
+// stepWorkdir returns the directory a step runs in given the command's
+// workdir
+func stepWorkdir(workdir string, step Step) string {
+	switch {
+	case step.Workdir == "":
+		return workdir
+	case filepath.IsAbs(step.Workdir):
+		return step.Workdir
+	default:
+		return filepath.Join(workdir, step.Workdir)
+	}
+}
+
+// ValidateSteps checks the steps of a command run in workdir. Args cannot be
+// combined with steps.
+func ValidateSteps(workdir, shell string, args []string, steps []Step, allowedWorkdirs []string) error {
+	if len(steps) == 0 {
+		return nil
+	}
+	if len(steps) > maxSteps {
+		return fmt.Errorf("steps cannot have more than %d entries", maxSteps)
+	}
+	if len(args) > 0 {
+		return errors.New("args cannot be combined with steps")
+	}
+	for i, step := range steps {
+		if strings.TrimSpace(step.Command) == "" {
+			return fmt.Errorf("step %d: command cannot be empty", i+1)
+		}
+		if err := ValidateShell(shell, step.Command, nil); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		// Templated workdirs are checked once their parameters are substituted
+		if step.Workdir != "" && !strings.Contains(step.Workdir, "{{") {
+			if err := checkWorkdirAllowed(stepWorkdir(workdir, step), allowedWorkdirs); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateStepWorkdirs checks that every step's workdir exists
+func ValidateStepWorkdirs(workdir string, steps []Step) error {
+	for i, step := range steps {
+		if step.Workdir == "" {
+			continue
+		}
+		if err := ValidateWorkdirExists(stepWorkdir(workdir, step)); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// renderSteps substitutes parameter values into the commands and workdirs of
+// steps
+func renderSteps(steps []Step, values map[string]string) ([]Step, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	rendered := make([]Step, len(steps))
+	for i, step := range steps {
+		var err error
+		rendered[i] = step
+		if rendered[i].Command, err = renderTemplate(step.Command, values); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if rendered[i].Workdir, err = renderTemplate(step.Workdir, values); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return rendered, nil
+}
+
+// newStepResults returns the pending results of steps
+func newStepResults(steps []Step) []StepResult {
+	if len(steps) == 0 {
+		return nil
+	}
+	results := make([]StepResult, len(steps))
+	for i, step := range steps {
+		results[i] = StepResult{Step: step, Status: "pending"}
+	}
+	return results
+}
+
+// interruptSteps returns a copy of results with running steps marked
+// interrupted and the ones that did not run skipped, for executions cut off
+// by the server stopping
+func interruptSteps(results []StepResult) []StepResult {
+	if len(results) == 0 {
+		return results
+	}
+	results = append([]StepResult(nil), results...)
+	for i := range results {
+		switch results[i].Status {
+		case "running":
+			results[i].Status = "interrupted"
+			results[i].ExitCode = -1
+		case "pending":
+			results[i].Status = "skipped"
+		}
+	}
+	return results
+}
+
+// beginStepLocked marks the current step of proc as running and writes a
+// header for it to the combined log. Results are replaced rather than
+// modified, as snapshots of the execution share them. Caller must hold e.mu.
+func (e *Executor) beginStepLocked(execution *Execution, proc *runningProcess) {
+	if len(execution.Steps) == 0 {
+		return
+	}
+	steps := append([]StepResult(nil), execution.Steps...)
+	step := &steps[proc.step]
+
+	proc.log.Flush()
+	header := fmt.Sprintf("==> Step %d/%d", proc.step+1, len(steps))
+	if step.Name != "" {
+		header += ": " + step.Name
+	}
+	if proc.log.size > 0 {
+		fmt.Fprintln(proc.log)
+	}
+	fmt.Fprintf(proc.log, "%s\n$ %s\n", header, step.Command)
+
+	now := time.Now()
+	step.Status = "running"
+	step.StartedAt = &now
+	step.LogOffset = proc.log.size
+	execution.Steps = steps
+	e.touchLocked(execution)
+}
+
+// endStepLocked records the result of the current step of proc if it is
+// running, and with last set marks the steps that did not run as skipped.
+// Caller must hold e.mu.
+func (e *Executor) endStepLocked(execution *Execution, proc *runningProcess, err error, last bool) {
+	if len(execution.Steps) == 0 {
+		return
+	}
+	steps := append([]StepResult(nil), execution.Steps...)
+
+	if step := &steps[proc.step]; step.Status == "running" {
+		proc.log.Flush()
+		now := time.Now()
+		step.Status, step.ExitCode = processResult(proc, err)
+		if _, ok := exitCode(err); err != nil && !ok && !proc.cancelled && proc.killed == "" {
+			step.Error = err.Error()
+			fmt.Fprintf(io.MultiWriter(proc.log, proc.stderr), "Error: %v\n", err)
+			proc.log.Flush()
+		}
+		step.EndedAt = &now
+		step.Duration = now.Sub(*step.StartedAt).String()
+		step.LogSize = proc.log.size - step.LogOffset
+	}
+	if last {
+		for i := range steps {
+			if steps[i].Status == "pending" {
+				steps[i].Status = "skipped"
+			}
+		}
+	}
+	execution.Steps = steps
+	e.touchLocked(execution)
+}
+
+// nextStep records the result of the current step of a multi-step process
+// and starts the next step if the run goes on. It returns false once the
+// attempt is over, with the error it ends with. A true result with an error
+// means the next step failed to start.
+func (e *Executor) nextStep(execution *Execution, proc *runningProcess, err error) (bool, error) {
+	if len(proc.params.Steps) == 0 {
+		return false, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	step := proc.params.Steps[proc.step]
+	stopped := proc.cancelled || proc.killed != ""
+	// A failure of a step that continues on error does not fail the run
+	var runErr error
+	if err != nil && (stopped || !step.ContinueOnError) {
+		runErr = &stepError{step: proc.step, err: err}
+	}
+	last := stopped || runErr != nil || proc.step == len(proc.params.Steps)-1
+	e.endStepLocked(execution, proc, err, last)
+	if last {
+		e.saveLocked()
+		return false, runErr
+	}
+
+	proc.step++
+	proc.cmd = e.processCommand(execution, proc, proc.baseDir(execution))
+	e.beginStepLocked(execution, proc)
+	e.saveLocked()
+	return true, e.startProcessLocked(execution, proc)
+}
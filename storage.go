@@ -2,132 +2,356 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	commandsFile   = "commands.json"
 	executionsFile = "executions.json"
 	usersFile      = "users.json"
+	presetsFile    = "presets.json"
+	tokensFile     = "tokens.json"
+	sequenceFile   = "sequence.json"
+	auditFile      = "audit.log"
 )
 
-// Storage manages persistent data storage
-type Storage struct {
+// Store persists commands, executions and users
+type Store interface {
+	LoadCommands() (map[string]*Command, error)
+	SaveCommands(commands map[string]*Command) error
+	LoadExecutions() (map[string]*Execution, error)
+	SaveExecutions(executions map[string]*Execution) error
+	LoadUsers() (map[string]*User, error)
+	SaveUsers(users map[string]*User) error
+	LoadPresets() (map[string]*EnvPreset, error)
+	SavePresets(presets map[string]*EnvPreset) error
+	LoadTokens() (map[string]*APIToken, error)
+	SaveTokens(tokens map[string]*APIToken) error
+	LoadSequence() (int64, error)
+	SaveSequence(seq int64) error
+	AppendAudit(entry *AuditEntry) error
+	LoadAudit() ([]*AuditEntry, error) // Oldest first
+}
+
+// NewStore creates the store selected by the configuration, creating the
+// data directory if needed
+func NewStore(config *Config) (Store, error) {
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	switch config.Store {
+	case "", "json":
+		return NewJSONStore(config.DataDir, AuditRotation{
+			MaxBytes:    config.AuditMaxBytes,
+			Daily:       config.AuditRotateDaily,
+			Compress:    config.AuditCompress,
+			MaxSegments: config.AuditMaxSegments,
+		}), nil
+	case "sqlite":
+		return NewSQLiteStore(config.sqlitePath())
+	default:
+		return nil, fmt.Errorf("unknown store %q", config.Store)
+	}
+}
+
+// JSONStore persists data as JSON files, rewriting a whole file on every save
+type JSONStore struct {
+	dir           string // Directory holding the data files
+	auditRotation AuditRotation
+
 	commandsMutex   sync.RWMutex
 	executionsMutex sync.RWMutex
 	usersMutex      sync.RWMutex
+	presetsMutex    sync.RWMutex
+	tokensMutex     sync.RWMutex
+	sequenceMutex   sync.RWMutex
+	auditMutex      sync.Mutex
+}
+
+// sequenceState is the content of the sequence file
+type sequenceState struct {
+	ExecutionSeq int64 `json:"execution_seq"`
 }
 
-// NewStorage creates a new storage instance
-func NewStorage() *Storage {
-	return &Storage{}
+// NewJSONStore creates a new JSON file store keeping its files in dir
+func NewJSONStore(dir string, auditRotation AuditRotation) *JSONStore {
+	return &JSONStore{dir: dir, auditRotation: auditRotation}
+}
+
+// path returns the path of a data file
+func (s *JSONStore) path(name string) string {
+	return filepath.Join(s.dir, name)
 }
 
 // SaveCommands writes commands to JSON file
-func (s *Storage) SaveCommands(commands map[string]*Command) error {
+func (s *JSONStore) SaveCommands(commands map[string]*Command) error {
 	s.commandsMutex.Lock()
 	defer s.commandsMutex.Unlock()
 
-	data, err := json.MarshalIndent(commands, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(commandsFile, data, 0644)
+	return s.writeFile(commandsFile, commands, 0644)
 }
 
 // LoadCommands reads commands from JSON file
-func (s *Storage) LoadCommands() (map[string]*Command, error) {
+func (s *JSONStore) LoadCommands() (map[string]*Command, error) {
 	s.commandsMutex.RLock()
 	defer s.commandsMutex.RUnlock()
 
 	commands := make(map[string]*Command)
 
-	data, err := os.ReadFile(commandsFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return commands, nil
-		}
+	if err := s.readFile(commandsFile, &commands); err != nil {
 		return nil, err
 	}
-
-	if len(data) == 0 {
-		return commands, nil
-	}
-
-	err = json.Unmarshal(data, &commands)
-	return commands, err
+	return commands, nil
 }
 
-// SaveExecutions writes executions to JSON file
-func (s *Storage) SaveExecutions(executions map[string]*Execution) error {
+// SaveExecutions writes executions to JSON file. Records are encoded one by
+// one, so a record that cannot be encoded is left out and reported in the
+// returned error instead of preventing the others from being saved.
+func (s *JSONStore) SaveExecutions(executions map[string]*Execution) error {
 	s.executionsMutex.Lock()
 	defer s.executionsMutex.Unlock()
 
-	data, err := json.MarshalIndent(executions, "", "  ")
-	if err != nil {
+	records := make(map[string]json.RawMessage, len(executions))
+	var skipped []string
+	for id, execution := range executions {
+		record, err := json.Marshal(sanitizeExecution(execution))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", id, err))
+			continue
+		}
+		records[id] = record
+	}
+
+	if err := s.writeFile(executionsFile, records, 0644); err != nil {
 		return err
 	}
 
-	return os.WriteFile(executionsFile, data, 0644)
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		return fmt.Errorf("skipped %d executions that could not be encoded: %s", len(skipped), strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// invalidUTF8Replacement replaces invalid UTF-8 sequences in stored text
+const invalidUTF8Replacement = "\uFFFD"
+
+// sanitizeExecution returns a copy of execution with invalid UTF-8 in its
+// text fields replaced, so command output never corrupts the stored file
+func sanitizeExecution(execution *Execution) *Execution {
+	clean := *execution
+	for _, field := range []*string{
+		&clean.Name, &clean.Workdir, &clean.Command, &clean.Output,
+		&clean.Stdout, &clean.Stderr, &clean.KillReason, &clean.IsolationResult,
+	} {
+		*field = strings.ToValidUTF8(*field, invalidUTF8Replacement)
+	}
+	clean.Params = sanitizeValues(clean.Params)
+	clean.Tags = append([]string(nil), clean.Tags...)
+	for i, tag := range clean.Tags {
+		clean.Tags[i] = strings.ToValidUTF8(tag, invalidUTF8Replacement)
+	}
+	return &clean
+}
+
+// sanitizeValues returns a copy of values with invalid UTF-8 replaced
+func sanitizeValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	clean := make(map[string]string, len(values))
+	for key, value := range values {
+		clean[strings.ToValidUTF8(key, invalidUTF8Replacement)] = strings.ToValidUTF8(value, invalidUTF8Replacement)
+	}
+	return clean
 }
 
 // LoadExecutions reads executions from JSON file
-func (s *Storage) LoadExecutions() (map[string]*Execution, error) {
+func (s *JSONStore) LoadExecutions() (map[string]*Execution, error) {
 	s.executionsMutex.RLock()
 	defer s.executionsMutex.RUnlock()
 
 	executions := make(map[string]*Execution)
 
-	data, err := os.ReadFile(executionsFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return executions, nil
-		}
+	if err := s.readFile(executionsFile, &executions); err != nil {
 		return nil, err
 	}
-
-	if len(data) == 0 {
-		return executions, nil
-	}
-
-	err = json.Unmarshal(data, &executions)
-	return executions, err
+	return executions, nil
 }
 
 // SaveUsers writes users to JSON file
-func (s *Storage) SaveUsers(users map[string]*User) error {
+func (s *JSONStore) SaveUsers(users map[string]*User) error {
 	s.usersMutex.Lock()
 	defer s.usersMutex.Unlock()
 
-	data, err := json.MarshalIndent(users, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(usersFile, data, 0644)
+	// Users hold password and TOTP secrets
+	return s.writeFile(usersFile, users, 0600)
 }
 
 // LoadUsers reads users from JSON file
-func (s *Storage) LoadUsers() (map[string]*User, error) {
+func (s *JSONStore) LoadUsers() (map[string]*User, error) {
 	s.usersMutex.RLock()
 	defer s.usersMutex.RUnlock()
 
 	users := make(map[string]*User)
 
-	data, err := os.ReadFile(usersFile)
+	// Older versions wrote the file readable by everyone
+	if err := os.Chmod(s.path(usersFile), 0600); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := s.readFile(usersFile, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SavePresets writes environment presets to JSON file
+func (s *JSONStore) SavePresets(presets map[string]*EnvPreset) error {
+	s.presetsMutex.Lock()
+	defer s.presetsMutex.Unlock()
+
+	return s.writeFile(presetsFile, presets, 0600)
+}
+
+// LoadPresets reads environment presets from JSON file
+func (s *JSONStore) LoadPresets() (map[string]*EnvPreset, error) {
+	s.presetsMutex.RLock()
+	defer s.presetsMutex.RUnlock()
+
+	presets := make(map[string]*EnvPreset)
+
+	if err := s.readFile(presetsFile, &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// SaveTokens writes API tokens to JSON file
+func (s *JSONStore) SaveTokens(tokens map[string]*APIToken) error {
+	s.tokensMutex.Lock()
+	defer s.tokensMutex.Unlock()
+
+	return s.writeFile(tokensFile, tokens, 0600)
+}
+
+// LoadTokens reads API tokens from JSON file
+func (s *JSONStore) LoadTokens() (map[string]*APIToken, error) {
+	s.tokensMutex.RLock()
+	defer s.tokensMutex.RUnlock()
+
+	tokens := make(map[string]*APIToken)
+
+	if err := s.readFile(tokensFile, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// SaveSequence writes the last assigned execution sequence number
+func (s *JSONStore) SaveSequence(seq int64) error {
+	s.sequenceMutex.Lock()
+	defer s.sequenceMutex.Unlock()
+
+	return s.writeFile(sequenceFile, sequenceState{ExecutionSeq: seq}, 0644)
+}
+
+// LoadSequence reads the last assigned execution sequence number
+func (s *JSONStore) LoadSequence() (int64, error) {
+	s.sequenceMutex.RLock()
+	defer s.sequenceMutex.RUnlock()
+
+	var state sequenceState
+	err := s.readFile(sequenceFile, &state)
+	return state.ExecutionSeq, err
+}
+
+// AppendAudit appends an entry to the audit log, one JSON object per line
+func (s *JSONStore) AppendAudit(entry *AuditEntry) error {
+	s.auditMutex.Lock()
+	defer s.auditMutex.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := s.rotateAuditLocked(time.Now()); err != nil {
+		slog.Error("Failed to rotate audit log", "error", err)
+	}
+
+	f, err := os.OpenFile(s.path(auditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadAudit reads the rotated audit log segments and the current log
+func (s *JSONStore) LoadAudit() ([]*AuditEntry, error) {
+	s.auditMutex.Lock()
+	defer s.auditMutex.Unlock()
+
+	segments, err := s.auditSegments()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return users, nil
-		}
 		return nil, err
 	}
+	entries := make([]*AuditEntry, 0)
+	for _, path := range append(segments, s.path(auditFile)) {
+		if entries, err = readAuditFile(path, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
 
-	if len(data) == 0 {
-		return users, nil
+// writeFileAtomic writes data to a temp file in the same directory, fsyncs it
+// and renames it over path, so a crash never leaves a truncated file behind.
+// The directory is synced afterwards to persist the rename.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
 	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
 
-	err = json.Unmarshal(data, &users)
-	return users, err
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
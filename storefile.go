@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// storeSchemaVersion is the format version of the JSON data files. Files
+// written before versioning hold their records at the top level and count as
+// version 0.
+const storeSchemaVersion = 1
+
+// storeFile is the content of a JSON data file
+type storeFile struct {
+	SchemaVersion int             `json:"schema_version"`
+	Records       json.RawMessage `json:"records"`
+}
+
+// storeMigration upgrades the records of the data file name from the version
+// it is registered for to the next one
+type storeMigration func(name string, records json.RawMessage) (json.RawMessage, error)
+
+// storeMigrations upgrade data files older than storeSchemaVersion, keyed by
+// the version they upgrade from. Version 0 files only need their records
+// wrapped, which decodeStoreFile does.
+var storeMigrations = map[int]storeMigration{}
+
+// StoreFileError reports stored data that cannot be loaded safely, because
+// it is corrupt or was written by a newer version. Saving over it would lose
+// data, so the server refuses to start.
+type StoreFileError struct {
+	Path   string
+	Backup string // Copy of a corrupt file, if one was made
+	Err    error
+}
+
+func (e *StoreFileError) Error() string {
+	if e.Backup != "" {
+		return fmt.Sprintf("%s: %v (backed up to %s)", e.Path, e.Err, e.Backup)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *StoreFileError) Unwrap() error {
+	return e.Err
+}
+
+// encodeStoreFile encodes records as a data file of the current version
+func encodeStoreFile(records any) ([]byte, error) {
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(storeFile{SchemaVersion: storeSchemaVersion, Records: raw}, "", "  ")
+}
+
+// decodeStoreFile returns the records of a data file and the version they
+// were written with
+func decodeStoreFile(data []byte) (json.RawMessage, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	// Records of version 0 files are objects, so a numeric schema_version
+	// only appears in versioned files
+	var version int
+	records, ok := fields["records"]
+	if !ok || json.Unmarshal(fields["schema_version"], &version) != nil {
+		return data, 0, nil
+	}
+	return records, version, nil
+}
+
+// migrateStoreFile upgrades the records of the data file name to the current
+// version
+func migrateStoreFile(name string, records json.RawMessage, version int) (json.RawMessage, error) {
+	if version > storeSchemaVersion {
+		return nil, fmt.Errorf("schema version %d is newer than the supported version %d", version, storeSchemaVersion)
+	}
+	for ; version < storeSchemaVersion; version++ {
+		migrate, ok := storeMigrations[version]
+		if !ok {
+			continue
+		}
+		var err error
+		if records, err = migrate(name, records); err != nil {
+			return nil, fmt.Errorf("failed to migrate from schema version %d: %w", version, err)
+		}
+		slog.Info("Migrated data file", "file", name, "from_version", version)
+	}
+	return records, nil
+}
+
+// readFile decodes the records of the data file name into v, migrating them
+// to the current version. v is left untouched if the file is missing or
+// empty. A corrupt file is backed up next to itself and left in place.
+func (s *JSONStore) readFile(name string, v any) error {
+	path := s.path(name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	records, version, err := decodeStoreFile(data)
+	if err == nil {
+		if records, err = migrateStoreFile(name, records, version); err != nil {
+			return &StoreFileError{Path: path, Err: err}
+		}
+		err = json.Unmarshal(records, v)
+	}
+	if err == nil {
+		return nil
+	}
+
+	fileErr := &StoreFileError{Path: path, Err: fmt.Errorf("corrupt data file: %w", err)}
+	backup := fmt.Sprintf("%s.corrupt.%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	if backupErr := writeFileAtomic(backup, data, 0600); backupErr != nil {
+		slog.Error("Failed to back up corrupt data file", "path", path, "error", backupErr)
+	} else {
+		fileErr.Backup = backup
+	}
+	slog.Error("CORRUPT DATA FILE", "path", fileErr.Path, "backup", fileErr.Backup, "error", fileErr.Err)
+	return fileErr
+}
+
+// writeFile encodes records as the data file name of the current version
+func (s *JSONStore) writeFile(name string, records any, perm os.FileMode) error {
+	data, err := encodeStoreFile(records)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(name), data, perm)
+}
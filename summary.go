@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the summary range in days
+const (
+	defaultSummaryDays = 30
+	maxSummaryDays     = 366
+)
+
+// DaySummary counts the executions started on one day
+type DaySummary struct {
+	Date     string         `json:"date"` // YYYY-MM-DD in the requested time zone
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// ExecutionSummaryResponse is execution history grouped by day
+type ExecutionSummaryResponse struct {
+	Group    string         `json:"group"`
+	Range    string         `json:"range"`
+	TimeZone string         `json:"tz"`
+	From     string         `json:"from"` // First day, inclusive
+	To       string         `json:"to"`   // Last day (today), inclusive
+	Days     []DaySummary   `json:"days"` // Oldest first, including days without executions
+	Totals   map[string]int `json:"totals"`
+}
+
+// parseDayRange parses a range like "30d"
+func parseDayRange(value string) (int, bool) {
+	if value == "" {
+		return defaultSummaryDays, true
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+	if err != nil || !strings.HasSuffix(value, "d") || days < 1 || days > maxSummaryDays {
+		return 0, false
+	}
+	return days, true
+}
+
+// summarizeByDay counts executions per day and status over the last days
+// days, ending today in loc, in a single pass over executions
+func summarizeByDay(executions []*Execution, days int, loc *time.Location, now time.Time) ExecutionSummaryResponse {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, -(days - 1))
+
+	summary := ExecutionSummaryResponse{
+		Group:    "day",
+		Range:    strconv.Itoa(days) + "d",
+		TimeZone: loc.String(),
+		From:     from.Format("2006-01-02"),
+		To:       today.Format("2006-01-02"),
+		Days:     make([]DaySummary, days),
+		Totals:   make(map[string]int),
+	}
+	index := make(map[string]int, days)
+	for i := range summary.Days {
+		date := from.AddDate(0, 0, i).Format("2006-01-02")
+		summary.Days[i] = DaySummary{Date: date, ByStatus: make(map[string]int)}
+		index[date] = i
+	}
+
+	for _, exec := range executions {
+		i, ok := index[exec.StartedAt.In(loc).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		summary.Days[i].Total++
+		summary.Days[i].ByStatus[exec.Status]++
+		summary.Totals[exec.Status]++
+	}
+	return summary
+}
+
+// ExecutionSummaryHandler handles GET /api/executions/summary
+func (app *App) ExecutionSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if group := query.Get("group"); group != "" && group != "day" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "group must be 'day'"})
+		return
+	}
+
+	days, ok := parseDayRange(query.Get("range"))
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "range must be a number of days between 1d and " + strconv.Itoa(maxSummaryDays) + "d"})
+		return
+	}
+
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Unknown time zone"})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, summarizeByDay(app.executor.GetAllExecutions(), days, loc, time.Now()))
+}
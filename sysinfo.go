@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+var (
+	// probedShells are the shells commands commonly choose
+	probedShells = []string{"sh", "bash", "zsh", "dash", "fish"}
+	// probedTools are programs deploy commands commonly call
+	probedTools = []string{"git", "docker", "kubectl", "make", "ssh", "rsync", "curl"}
+)
+
+// SystemInfoResponse describes the host commands run on
+type SystemInfoResponse struct {
+	Hostname     string            `json:"hostname"`
+	OS           string            `json:"os"`
+	Arch         string            `json:"arch"`
+	CPUs         int               `json:"cpus"`
+	GoVersion    string            `json:"go_version"`
+	Version      string            `json:"version"`
+	Shells       map[string]string `json:"shells"` // Path of each shell found on PATH
+	Tools        map[string]string `json:"tools"`  // Path of each tool found on PATH
+	DataDir      string            `json:"data_dir"`
+	DataDirSpace *DiskSpace        `json:"data_dir_space,omitempty"` // Omitted if it cannot be read
+}
+
+// DiskSpace is the size of a file system in bytes
+type DiskSpace struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"` // Usable by the server's user
+}
+
+// lookPaths returns the path of each of names found on PATH
+func lookPaths(names []string) map[string]string {
+	found := make(map[string]string)
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			found[name] = path
+		}
+	}
+	return found
+}
+
+// diskSpace returns the size of the file system holding dir
+func diskSpace(dir string) (*DiskSpace, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return nil, err
+	}
+	return &DiskSpace{
+		TotalBytes:     stat.Blocks * uint64(stat.Bsize),
+		AvailableBytes: stat.Bavail * uint64(stat.Bsize),
+	}, nil
+}
+
+// SystemInfoHandler handles GET /api/system/info (admin only), which shows
+// whether the server can run the commands users are about to save
+func (app *App) SystemInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	info := SystemInfoResponse{
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+		Version:   version,
+		Shells:    lookPaths(probedShells),
+		Tools:     lookPaths(probedTools),
+		DataDir:   app.config.DataDir,
+	}
+	if space, err := diskSpace(app.config.DataDir); err == nil {
+		info.DataDirSpace = space
+	}
+	respondJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// validateTLS checks that at most one way of serving HTTPS is configured
+func (c *Config) validateTLS() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("DEPLOYAR_TLS_CERT and DEPLOYAR_TLS_KEY must be set together")
+	}
+	if c.TLSCert != "" && len(c.AutocertDomains) > 0 {
+		return errors.New("DEPLOYAR_TLS_CERT and DEPLOYAR_AUTOCERT_DOMAINS cannot be combined")
+	}
+	return nil
+}
+
+// tlsEnabled reports whether the server is served over HTTPS
+func (c *Config) tlsEnabled() bool {
+	return c.TLSCert != "" || len(c.AutocertDomains) > 0
+}
+
+// autocertCacheDir returns where Let's Encrypt certificates are kept,
+// defaulting to the autocert directory under the data directory
+func (c *Config) autocertCacheDir() string {
+	if c.AutocertCacheDir != "" {
+		return c.AutocertCacheDir
+	}
+	return filepath.Join(c.DataDir, "autocert")
+}
+
+// listen serves requests over HTTPS with the configured certificate or one
+// obtained from Let's Encrypt, or over plain HTTP if TLS is not configured
+func listen(server *http.Server, config *Config) error {
+	switch {
+	case config.TLSCert != "":
+		return server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+	case len(config.AutocertDomains) > 0:
+		// Certificates are requested with the TLS-ALPN-01 challenge, which
+		// needs the server to be reachable on port 443
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertDomains...),
+			Cache:      autocert.DirCache(config.autocertCacheDir()),
+			Email:      config.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		return server.ListenAndServeTLS("", "")
+	default:
+		return server.ListenAndServe()
+	}
+}
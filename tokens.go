@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// apiTokenPrefix marks API tokens, telling them apart from session tokens
+	apiTokenPrefix = "dpl_"
+	// apiTokenShownLength is how much of a token is kept to identify it
+	apiTokenShownLength = len(apiTokenPrefix) + 8
+	// maxTokenNameLength caps the length of a token name
+	maxTokenNameLength = 100
+	// tokenUseInterval is how often the last use of a token is recorded, so
+	// busy scripts do not cause a write on every request
+	tokenUseInterval = time.Minute
+)
+
+// APIToken is a long-lived credential for scripts. Only a hash of the token
+// is stored.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	Prefix     string     `json:"prefix"` // Start of the token, to recognize it
+	Hash       string     `json:"hash"`   // SHA-256 of the token
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateTokenRequest represents a request to mint an API token
+type CreateTokenRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Never expires if omitted
+}
+
+// TokenResponse describes an API token without its hash
+type TokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateTokenResponse includes the token itself, which is only shown once
+type CreateTokenResponse struct {
+	TokenResponse
+	Token string `json:"token"`
+}
+
+// newTokenResponse builds the public view of a token
+func newTokenResponse(token *APIToken) TokenResponse {
+	return TokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// TokenStore keeps API tokens in memory and persists them on change
+type TokenStore struct {
+	mu      sync.Mutex
+	storage Store
+	tokens  map[string]*APIToken
+}
+
+// NewTokenStore creates a token store holding tokens
+func NewTokenStore(storage Store, tokens map[string]*APIToken) *TokenStore {
+	return &TokenStore{storage: storage, tokens: tokens}
+}
+
+// Create mints a token for username, returning it with its record
+func (s *TokenStore) Create(username, name string, expiresAt *time.Time) (string, *APIToken, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	raw := apiTokenPrefix + hex.EncodeToString(buf)
+
+	token := &APIToken{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Username:  username,
+		Prefix:    raw[:apiTokenShownLength],
+		Hash:      hashToken(raw),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = token
+	if err := s.storage.SaveTokens(s.tokens); err != nil {
+		delete(s.tokens, token.ID)
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+// List returns the tokens of username, oldest first
+func (s *TokenStore) List(username string) []TokenResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]TokenResponse, 0)
+	for _, token := range s.tokens {
+		if token.Username == username {
+			tokens = append(tokens, newTokenResponse(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Revoke deletes the token id of username, reporting whether it existed
+func (s *TokenStore) Revoke(username, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.Username != username {
+		return false, nil
+	}
+	delete(s.tokens, id)
+	return true, s.storage.SaveTokens(s.tokens)
+}
+
+// RevokeUser deletes all tokens of username
+func (s *TokenStore) RevokeUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.Username == username {
+			delete(s.tokens, id)
+		}
+	}
+	return s.storage.SaveTokens(s.tokens)
+}
+
+// Validate returns the user a raw token belongs to if it is known and has
+// not expired, recording its use
+func (s *TokenStore) Validate(raw string) (string, bool) {
+	hash := hashToken(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		now := time.Now()
+		if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+			return "", false
+		}
+		if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenUseInterval {
+			token.LastUsedAt = &now
+			if err := s.storage.SaveTokens(s.tokens); err != nil {
+				slog.Error("Failed to record token use", "token_id", token.ID, "error", err)
+			}
+		}
+		return token.Username, true
+	}
+	return "", false
+}
+
+// hashToken returns the hex SHA-256 of a raw token
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateTokenHandler handles POST /api/auth/tokens
+func (app *App) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Token name is required"})
+		return
+	}
+	if len(req.Name) > maxTokenNameLength {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Token name is too long"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "expires_at must be in the future"})
+		return
+	}
+
+	raw, token, err := app.tokens.Create(currentUsername(r), req.Name, req.ExpiresAt)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to create token"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, CreateTokenResponse{TokenResponse: newTokenResponse(token), Token: raw})
+}
+
+// ListTokensHandler handles GET /api/auth/tokens
+func (app *App) ListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, app.tokens.List(currentUsername(r)))
+}
+
+// RevokeTokenHandler handles DELETE /api/auth/tokens/:id
+func (app *App) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	found, err := app.tokens.Revoke(currentUsername(r), mux.Vars(r)["id"])
+	if !found {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Token not found"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke token"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Token revoked"})
+}
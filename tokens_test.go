@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{name: "same token", a: "dpl_abc", b: "dpl_abc", same: true},
+		{name: "different token", a: "dpl_abc", b: "dpl_abd"},
+		{name: "case matters", a: "dpl_abc", b: "dpl_ABC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := hashToken(tt.a), hashToken(tt.b)
+			if len(a) != 64 || strings.Contains(a, tt.a) {
+				t.Errorf("hashToken(%q) = %q, want a hex SHA-256", tt.a, a)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("hashes equal = %v, want %v", a == b, tt.same)
+			}
+		})
+	}
+}
+
+func TestTokenStoreValidate(t *testing.T) {
+	store := NewTokenStore(NewJSONStore(t.TempDir(), AuditRotation{}), make(map[string]*APIToken))
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	valid, _, err := store.Create("alice", "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unexpired, _, err := store.Create("bob", "deploy", &future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := store.Create("alice", "old", &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedToken, err := store.Create("alice", "revoked", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Revoke("alice", revokedToken.ID); !ok || err != nil {
+		t.Fatalf("Revoke() = %v, %v", ok, err)
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		wantUser string
+		wantOK   bool
+	}{
+		{name: "valid", raw: valid, wantUser: "alice", wantOK: true},
+		{name: "not yet expired", raw: unexpired, wantUser: "bob", wantOK: true},
+		{name: "expired", raw: expired},
+		{name: "revoked", raw: revoked},
+		{name: "unknown", raw: apiTokenPrefix + strings.Repeat("0", 64)},
+		{name: "truncated", raw: valid[:len(valid)-1]},
+		{name: "hash instead of token", raw: hashToken(valid)},
+		{name: "empty", raw: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, ok := store.Validate(tt.raw)
+			if username != tt.wantUser || ok != tt.wantOK {
+				t.Errorf("Validate() = %q, %v, want %q, %v", username, ok, tt.wantUser, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTokenStoreStoresHashOnly(t *testing.T) {
+	store := NewTokenStore(NewJSONStore(t.TempDir(), AuditRotation{}), make(map[string]*APIToken))
+	raw, token, err := store.Create("alice", "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		t.Errorf("token %q lacks the %q prefix", raw, apiTokenPrefix)
+	}
+	if token.Hash != hashToken(raw) || strings.Contains(token.Hash, raw) {
+		t.Error("stored hash does not match the token")
+	}
+	if token.Prefix != raw[:apiTokenShownLength] {
+		t.Errorf("prefix = %q, want %q", token.Prefix, raw[:apiTokenShownLength])
+	}
+	if token.LastUsedAt != nil {
+		t.Error("new token already has a last use")
+	}
+
+	if _, ok := store.Validate(raw); !ok {
+		t.Fatal("token was rejected")
+	}
+	if token.LastUsedAt == nil {
+		t.Error("use of the token was not recorded")
+	}
+}
+
+func TestTokenStoreRevoke(t *testing.T) {
+	store := NewTokenStore(NewJSONStore(t.TempDir(), AuditRotation{}), make(map[string]*APIToken))
+	raw, token, err := store.Create("alice", "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := store.Create("bob", "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := store.Revoke("bob", token.ID); ok {
+		t.Fatal("a user revoked someone else's token")
+	}
+	if err := store.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Validate(raw); ok {
+		t.Error("token of a revoked user is still valid")
+	}
+	if _, ok := store.Validate(other); !ok {
+		t.Error("revoking one user revoked another's token")
+	}
+}
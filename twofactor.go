@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+)
+
+// totpIssuer names the account in authenticator apps
+const totpIssuer = "Deployar"
+
+// recoveryCodeCount is how many one-time recovery codes are issued
+const recoveryCodeCount = 10
+
+// EnrollTwoFactorResponse holds a new TOTP secret to add to an authenticator
+type EnrollTwoFactorResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // otpauth:// URI, usually shown as a QR code
+}
+
+// VerifyTwoFactorRequest confirms enrollment with a code from the authenticator
+type VerifyTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+// VerifyTwoFactorResponse holds the recovery codes, shown only once
+type VerifyTwoFactorResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorRequiredResponse is returned when login needs a second factor
+type TwoFactorRequiredResponse struct {
+	Error             string `json:"error"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+}
+
+// EnrollTwoFactorHandler handles POST /api/auth/2fa/enroll. It generates a
+// secret that takes effect once confirmed with /api/auth/2fa/verify.
+func (app *App) EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+	user, exists := app.users[currentUsername(r)]
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate secret"})
+		return
+	}
+	user.TOTPSecret = key.Secret()
+	if err := app.storage.SaveUsers(app.users); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, EnrollTwoFactorResponse{Secret: key.Secret(), OTPAuthURI: key.URL()})
+}
+
+// VerifyTwoFactorHandler handles POST /api/auth/2fa/verify, enabling 2FA
+// once the user proves their authenticator works
+func (app *App) VerifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req VerifyTwoFactorRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+	user, exists := app.users[currentUsername(r)]
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		respondJSON(w, http.StatusConflict, ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Start enrollment first"})
+		return
+	}
+	if !totp.Validate(strings.TrimSpace(req.Code), user.TOTPSecret) {
+		respondJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate recovery codes"})
+		return
+	}
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashes
+	if err := app.storage.SaveUsers(app.users); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, VerifyTwoFactorResponse{RecoveryCodes: codes})
+}
+
+// ResetTwoFactorHandler handles DELETE /api/users/:username/2fa, letting an
+// admin turn off 2FA for a user who lost their authenticator
+func (app *App) ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.isAdmin(currentUsername(r)) {
+		respondJSON(w, http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+		return
+	}
+
+	app.usersMu.Lock()
+	defer app.usersMu.Unlock()
+	user, exists := app.users[mux.Vars(r)["username"]]
+	if !exists {
+		respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.RecoveryCodes = nil
+	if err := app.storage.SaveUsers(app.users); err != nil {
+		respondJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save user"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication reset"})
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code,
+// which is used up. Caller must hold usersMu for writing and save users if a
+// recovery code was used.
+func checkSecondFactor(user *User, code string) (ok, usedRecovery bool) {
+	code = strings.TrimSpace(code)
+	if totp.Validate(code, user.TOTPSecret) {
+		return true, false
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// newRecoveryCodes returns fresh recovery codes and their hashes for storage
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
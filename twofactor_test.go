@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestCheckSecondFactor(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := totp.GenerateCode(key.Secret(), time.Now().Add(-10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		wantOK       bool
+		wantRecovery bool
+	}{
+		{name: "current code", code: current, wantOK: true},
+		{name: "current code with spaces", code: " " + current + "\n", wantOK: true},
+		{name: "stale code", code: stale},
+		{name: "wrong code", code: "000000"},
+		{name: "empty", code: ""},
+		{name: "recovery code", code: codes[0], wantOK: true, wantRecovery: true},
+		{name: "recovery code without dash in upper case", code: strings.ToUpper(strings.ReplaceAll(codes[1], "-", "")), wantOK: true, wantRecovery: true},
+		{name: "unknown recovery code", code: "aaaaa-bbbbb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{Username: "alice", TOTPSecret: key.Secret(), TOTPEnabled: true, RecoveryCodes: append([]string(nil), hashes...)}
+			ok, usedRecovery := checkSecondFactor(user, tt.code)
+			if ok != tt.wantOK || usedRecovery != tt.wantRecovery {
+				t.Fatalf("checkSecondFactor() = %v, %v, want %v, %v", ok, usedRecovery, tt.wantOK, tt.wantRecovery)
+			}
+
+			wantLeft := len(hashes)
+			if tt.wantRecovery {
+				wantLeft--
+			}
+			if len(user.RecoveryCodes) != wantLeft {
+				t.Errorf("%d recovery codes left, want %d", len(user.RecoveryCodes), wantLeft)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Username: "alice", TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabled: true, RecoveryCodes: hashes}
+
+	if ok, _ := checkSecondFactor(user, codes[3]); !ok {
+		t.Fatal("recovery code was rejected")
+	}
+	if ok, _ := checkSecondFactor(user, codes[3]); ok {
+		t.Fatal("recovery code was accepted twice")
+	}
+	if ok, _ := checkSecondFactor(user, codes[4]); !ok {
+		t.Fatal("other recovery codes stopped working")
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("hash of code %d does not match", i)
+		}
+		if hashes[i] == code || strings.Contains(hashes[i], strings.ReplaceAll(code, "-", "")) {
+			t.Errorf("code %d is stored in the clear", i)
+		}
+		if seen[code] {
+			t.Errorf("code %q was issued twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestUsersFileMode(t *testing.T) {
+	dir := t.TempDir()
+	store := NewJSONStore(dir, AuditRotation{})
+	path := filepath.Join(dir, usersFile)
+
+	users := map[string]*User{"alice": {Username: "alice", TOTPSecret: "JBSWY3DPEHPK3PXP"}}
+	if err := store.SaveUsers(users); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Fatalf("saved users file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Files written by older versions are tightened when loaded
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Fatalf("loaded users file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
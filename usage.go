@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// startProcessLocked starts the current process of an execution and records
+// its PID. Commands run on a remote host start connecting in the
+// background. Caller must hold e.mu.
+func (e *Executor) startProcessLocked(execution *Execution, proc *runningProcess) error {
+	if proc.remote != nil {
+		// Remote processes have no local PID
+		proc.remote.Start(proc.cmd)
+		return nil
+	}
+	if err := proc.cmd.Start(); err != nil {
+		return err
+	}
+	execution.PID = proc.cmd.Process.Pid
+	e.touchLocked(execution)
+	return nil
+}
+
+// wait waits for the current process of an execution to exit, closes its
+// input and adds the resources it used to the execution's usage. The usage
+// of remote processes is not known.
+func (e *Executor) wait(execution *Execution, proc *runningProcess) error {
+	if proc.remote != nil {
+		err := proc.remote.Wait()
+		if proc.stdin != nil {
+			proc.stdin.Close()
+		}
+		return err
+	}
+	err := proc.cmd.Wait()
+	if proc.stdin != nil {
+		proc.stdin.Close()
+	}
+	if proc.cmd.ProcessState != nil {
+		e.mu.Lock()
+		addUsage(execution, proc.cmd.ProcessState)
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// addUsage adds the resources used by an exited process to the execution's
+// usage. Snapshots share the previous usage, so it is replaced rather than
+// modified.
+func addUsage(execution *Execution, state *os.ProcessState) {
+	usage := ResourceUsage{}
+	if execution.Usage != nil {
+		usage = *execution.Usage
+	}
+	usage.UserCPUSeconds += state.UserTime().Seconds()
+	usage.SystemCPUSeconds += state.SystemTime().Seconds()
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		maxRSS := int64(rusage.Maxrss)
+		if runtime.GOOS == "darwin" {
+			// Reported in bytes on macOS, kilobytes elsewhere
+			maxRSS /= 1024
+		}
+		usage.MaxRSSKB = max(usage.MaxRSSKB, maxRSS)
+	}
+	execution.Usage = &usage
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteTimeout bounds how long a message may take to reach a client
+	wsWriteTimeout = 10 * time.Second
+	// wsPongTimeout is how long a client may stay silent before it is
+	// considered gone; pings are sent well within it
+	wsPongTimeout = 60 * time.Second
+	wsPingPeriod  = wsPongTimeout * 9 / 10
+
+	// wsProtocol is the subprotocol the server speaks. Browsers cannot set
+	// headers on WebSocket requests, so they offer the session or API token
+	// as a second subprotocol, wsTokenProtocol followed by the token.
+	wsProtocol      = "deployar"
+	wsTokenProtocol = "bearer."
+)
+
+// ExecutionSnapshot is the first message sent to WebSocket clients, with the
+// queued and running executions
+type ExecutionSnapshot struct {
+	Type       string       `json:"type"` // Always "snapshot"
+	Executions []*Execution `json:"executions"`
+}
+
+// ExecutionEvent is sent to WebSocket clients when the status of an
+// execution changes, including when it is created
+type ExecutionEvent struct {
+	Type      string     `json:"type"` // Always "execution"
+	Status    string     `json:"status"`
+	Execution *Execution `json:"execution"`
+}
+
+// closeWebSockets ends all WebSocket connections and waits for their
+// handlers to finish. Server shutdown does not track the connections once
+// they are hijacked, so it must be called after it.
+func (app *App) closeWebSockets() {
+	close(app.wsClosing)
+	app.wsConns.Wait()
+}
+
+// ExecutionsWebSocketHandler handles GET /api/ws/executions, pushing
+// execution status changes to the client until it disconnects
+func (app *App) ExecutionsWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	// Counted before the upgrade, while server shutdown still waits for it
+	app.wsConns.Add(1)
+	defer app.wsConns.Done()
+
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{wsProtocol},
+		// Same-origin requests, and those from origins allowed for CORS
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || origin == "http://"+r.Host || origin == "https://"+r.Host ||
+				allowedOrigin(app.config.CORSOrigins, origin) != ""
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded
+		return
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading processes them and notices
+	// when the client goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Take the cursor before the snapshot so no change in between is missed
+	cursor, changed := app.executor.Changes()
+	snapshot := make([]*Execution, 0)
+	for _, status := range []string{"queued", "running"} {
+		executions, _ := app.executor.ListExecutions(ExecutionFilter{Status: status})
+		snapshot = append(snapshot, executions...)
+	}
+	if !app.sendEvent(conn, ExecutionSnapshot{Type: "snapshot", Executions: snapshot}) {
+		return
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-changed:
+			next, nextChanged := app.executor.Changes()
+			events, ok := app.executor.StatusChanges(cursor, next)
+			if !ok {
+				// Fell behind the status log; send the current status of
+				// everything that changed instead
+				events = changedExecutionEvents(app.executor, cursor)
+			}
+			cursor, changed = next, nextChanged
+			for _, event := range events {
+				if !app.sendEvent(conn, event) {
+					return
+				}
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-app.wsClosing:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
+
+// changedExecutionEvents lists the executions changed after revision since
+// as events with their current status, oldest first
+func changedExecutionEvents(executor *Executor, since int64) []ExecutionEvent {
+	executions, _ := executor.ListExecutions(ExecutionFilter{Since: since})
+	events := make([]ExecutionEvent, 0, len(executions))
+	for i := len(executions) - 1; i >= 0; i-- {
+		events = append(events, ExecutionEvent{Type: "execution", Status: executions[i].Status, Execution: executions[i]})
+	}
+	return events
+}
+
+// websocketToken returns the token a WebSocket request offers as a
+// subprotocol, if any
+func websocketToken(r *http.Request) string {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return ""
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, wsTokenProtocol); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// sendEvent writes a message to a WebSocket client, reporting whether it was
+// delivered
+func (app *App) sendEvent(conn *websocket.Conn, event any) bool {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := conn.WriteJSON(event); err != nil {
+		slog.Debug("WebSocket client dropped", "error", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusChanges(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	since, _ := e.Changes()
+	execution := mustExecute(t, e, ExecuteParams{Command: "true"})
+	waitFinished(t, e, execution.ID)
+	until, _ := e.Changes()
+
+	events, ok := e.StatusChanges(since, until)
+	if !ok {
+		t.Fatal("status log does not reach back to the cursor")
+	}
+	var statuses []string
+	for _, event := range events {
+		if event.Execution.ID != execution.ID {
+			t.Fatalf("event for unexpected execution %s", event.Execution.ID)
+		}
+		statuses = append(statuses, event.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != "running" || statuses[1] != "success" {
+		t.Errorf("statuses = %v, want [running success]", statuses)
+	}
+
+	// Nothing changed after until
+	if events, _ := e.StatusChanges(until, until); len(events) != 0 {
+		t.Errorf("%d events after the last change, want none", len(events))
+	}
+}
+
+func TestStatusLogBounded(t *testing.T) {
+	e := newTestExecutor(t, 0)
+	e.mu.Lock()
+	for i := int64(1); i <= maxStatusLog+10; i++ {
+		e.logStatusLocked(statusChange{Rev: i, ID: "x", Status: "running"})
+	}
+	size := len(e.statusLog)
+	e.mu.Unlock()
+
+	if size > maxStatusLog {
+		t.Errorf("status log holds %d entries, want at most %d", size, maxStatusLog)
+	}
+	if _, ok := e.StatusChanges(1, maxStatusLog+10); ok {
+		t.Error("a cursor older than the log was accepted")
+	}
+	if _, ok := e.StatusChanges(maxStatusLog, maxStatusLog+10); !ok {
+		t.Error("a recent cursor was refused")
+	}
+}
+
+func TestWebsocketToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		upgrade   string
+		protocols string
+		want      string
+	}{
+		{name: "token protocol", upgrade: "websocket", protocols: "deployar, bearer.abc123", want: "abc123"},
+		{name: "api token", upgrade: "websocket", protocols: "deployar, bearer.dpl_abc", want: "dpl_abc"},
+		{name: "no token", upgrade: "websocket", protocols: "deployar"},
+		{name: "not an upgrade", protocols: "bearer.abc123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/ws/executions", nil)
+			if tt.upgrade != "" {
+				r.Header.Set("Upgrade", tt.upgrade)
+			}
+			r.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			if got := websocketToken(r); got != tt.want {
+				t.Errorf("websocketToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}